@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mariotoffia/gojwtlic/license"
+	"github.com/mariotoffia/gojwtlic/license/licact"
+	"github.com/mariotoffia/gojwtlic/license/licjwt"
+	"github.com/mariotoffia/gojwtlic/license/licjwt/licbuiltin"
+)
+
+// activateCommand handles the offline activation sub commands _request_, _issue_ and
+// _import_ and returns the exit code; zero on success, one on error and two on usage
+// error.
+//
+// .Example Usage
+// [source,bash]
+// ....
+// gojwtlic activate request -product valmatics -version 2.0.0 > request.txt
+// gojwtlic activate issue -key private.pem -info info.json request.txt > license.txt
+// gojwtlic activate import -key public.pem license.txt
+// ....
+func activateCommand(args []string) int {
+
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: gojwtlic activate request|issue|import [flags] [file]")
+		return 2
+	}
+
+	switch args[0] {
+	case "request":
+		return activateRequest(args[1:])
+	case "issue":
+		return activateIssue(args[1:])
+	case "import":
+		return activateImport(args[1:])
+	}
+
+	fmt.Fprintf(os.Stderr, "unknown activate command %s\n", args[0])
+	return 2
+}
+
+// activateRequest prints a new activation request for this machine.
+func activateRequest(args []string) int {
+
+	fs := flag.NewFlagSet("activate request", flag.ContinueOnError)
+
+	product := fs.String("product", "", "name of the product")
+	version := fs.String("version", "", "version of the product")
+	licenseID := fs.String("license", "", "id of the requested license, only honoured in signed requests")
+	key := fs.String("key", "", "private key PEM file to sign the request with")
+	fingerprint := fs.String("fingerprint", "", "fingerprint to use instead of the machine fingerprint")
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	fpt := *fingerprint
+
+	if fpt == "" {
+
+		var err error
+
+		if fpt, err = licact.MachineFingerprint(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+	}
+
+	req := licact.NewActivationRequest(fpt, *product, *version, *licenseID)
+
+	var s string
+	var err error
+
+	if *key != "" {
+		s, err = req.EncodeSigned(licbuiltin.NewRSAKeysFromFile("", *key))
+	} else {
+		s, err = req.Encode()
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Println(s)
+	return 0
+}
+
+// activateIssue reads an activation request and prints the activated license.
+func activateIssue(args []string) int {
+
+	fs := flag.NewFlagSet("activate issue", flag.ContinueOnError)
+
+	key := fs.String("key", "", "private key PEM file to sign the license with")
+	verify := fs.String("verify", "", "public key PEM file to verify signed requests with")
+	info := fs.String("info", "", "JSON file with the license claims")
+	days := fs.Int("days", 365, "number of days the license is valid")
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if *key == "" {
+		fmt.Fprintln(os.Stderr, "a private key is required")
+		return 2
+	}
+
+	s, err := readActivationInput(fs.Arg(0))
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	var verifyKeys license.RSAKeyPair
+
+	if *verify != "" {
+		verifyKeys = licbuiltin.NewRSAKeysFromFile(*verify, "")
+	}
+
+	req, err := licact.DecodeActivationRequest(s, verifyKeys)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	generator := licjwt.NewGeneratorBuilderWithSigner(
+		licbuiltin.NewSignCreator(licbuiltin.NewRSAKeysFromFile("", *key), "RS256"),
+	).LicenseLength(time.Hour * 24 * time.Duration(*days))
+
+	fi := generator.CreateFeatureInfo()
+
+	if *info != "" {
+
+		buf, err := ioutil.ReadFile(*info)
+
+		if err == nil {
+			err = json.Unmarshal(buf, fi)
+		}
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", *info, err)
+			return 1
+		}
+
+	}
+
+	lic, err := licact.Activate(req, generator, fi)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Println(lic)
+	return 0
+}
+
+// activateImport validates an activated license and prints the license claims.
+func activateImport(args []string) int {
+
+	fs := flag.NewFlagSet("activate import", flag.ContinueOnError)
+
+	key := fs.String("key", "", "public key PEM file to verify the license with")
+	fingerprint := fs.String("fingerprint", "", "fingerprint to use instead of the machine fingerprint")
+	out := fs.String("out", "", "file to write the license JWT to")
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if *key == "" {
+		fmt.Fprintln(os.Stderr, "a public key is required")
+		return 2
+	}
+
+	s, err := readActivationInput(fs.Arg(0))
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fpt := *fingerprint
+
+	if fpt == "" {
+
+		if fpt, err = licact.MachineFingerprint(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+	}
+
+	info, token, err := licact.ImportLicense(
+		s, licbuiltin.NewValidator(licbuiltin.NewRSAKeysFromFile(*key, "")), fpt,
+	)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if *out != "" {
+
+		if err := ioutil.WriteFile(*out, []byte(token), 0600); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+	}
+
+	buf, err := info.ToJSONIndent()
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Println(string(buf))
+	return 0
+}
+
+// readActivationInput reads the encoded data from _file_ or from stdin if _file_ is
+// empty or "-".
+func readActivationInput(file string) (string, error) {
+
+	var buf []byte
+	var err error
+
+	if file == "" || file == "-" {
+		buf, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		buf, err = ioutil.ReadFile(file)
+	}
+
+	return strings.TrimSpace(string(buf)), err
+}
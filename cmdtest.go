@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/mariotoffia/gojwtlic/license/licpol"
+	"github.com/open-policy-agent/opa/storage/inmem"
+	"github.com/open-policy-agent/opa/util"
+)
+
+// testCommand runs all rego tests in the directories given in _args_ and returns the
+// exit code; zero when all tests passed, one if any test failed and two on error.
+//
+// .Example Usage
+// [source,bash]
+// ....
+// gojwtlic test -coverage -data data.json rego
+// ....
+func testCommand(args []string) int {
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+
+	verbose := fs.Bool("v", false, "print all tests, not only failed")
+	coverage := fs.Bool("coverage", false, "report coverage per module")
+	data := fs.String("data", "", "JSON file to use as the data document")
+
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gojwtlic test [-v] [-coverage] [-data file.json] [directory ...]")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	dirs := fs.Args()
+
+	if len(dirs) == 0 {
+		dirs = []string{"."}
+	}
+
+	ctx := context.Background()
+	pctx := licpol.New()
+
+	for _, dir := range dirs {
+
+		prp, err := licpol.NewFilesystemPRP(dir, 0)
+
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+
+		pctx.RegisterPRP(ctx, prp)
+	}
+
+	if *data != "" {
+
+		buf, err := ioutil.ReadFile(*data)
+
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+
+		var doc map[string]interface{}
+
+		if err := util.UnmarshalJSON(buf, &doc); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", *data, err)
+			return 2
+		}
+
+		pctx.UseStore(inmem.NewFromObject(doc))
+	}
+
+	report, err := pctx.RunTests(ctx, *coverage)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	report.Print(os.Stdout, *verbose, *coverage)
+
+	if !report.Passed() {
+		return 1
+	}
+
+	return 0
+}
@@ -1,11 +1,21 @@
 package license
 
-import "time"
+import (
+	"fmt"
+	"sync"
+	"time"
+)
 
 // GeneratorBuilder is a wrapper of a single ´Generator` that
 // implements the fluent builder pattern.
 type GeneratorBuilder struct {
-	gen Generator
+	gen       Generator
+	guard     IssuanceGuard
+	catalog   *FeatureCatalog
+	editions  *EditionCatalog
+	encryptor *ClaimEncryptor
+	err       error
+	genMu     sync.Mutex
 }
 
 // NewGenerator creates a new `GeneratorBuilder` by wrapping
@@ -20,15 +30,40 @@ func NewGenerator(gen Generator) *GeneratorBuilder {
 
 // Error will return the last error, if any.
 func (g *GeneratorBuilder) Error() error {
+
+	if g.err != nil {
+		return g.err
+	}
+
 	return g.gen.Error()
 }
 
 // ClearError will clear any error that is present
 func (g *GeneratorBuilder) ClearError() *GeneratorBuilder {
+	g.err = nil
 	g.gen.ClearError()
 	return g
 }
 
+// Guard sets a `IssuanceGuard` that must allow each license before it is created.
+//
+// .Example Usage
+// [source,go]
+// ....
+// generator := licjwt.NewGeneratorBuilderWithSigner(signer).
+// Guard(licpol.NewIssuanceGuard(pctx, "issue", "data.licpol.testing.allow_create"))
+//
+// lic := generator.CreateFor(callerClaims, info)
+//
+// if errors.Is(generator.Error(), license.ErrIssuanceDenied) {
+// // caller may not issue the license
+// }
+// ....
+func (g *GeneratorBuilder) Guard(guard IssuanceGuard) *GeneratorBuilder {
+	g.guard = guard
+	return g
+}
+
 // Audience sets the default audience
 func (g *GeneratorBuilder) Audience(aud string) *GeneratorBuilder {
 	g.gen.Audience(aud)
@@ -68,7 +103,138 @@ func (g *GeneratorBuilder) CreateFeatureInfo() *FeatureInfo {
 	return g.gen.CreateFeatureInfo()
 }
 
+// Catalog sets a `FeatureCatalog` that validates the features, and sets the default
+// claims, of each license before it is created.
+func (g *GeneratorBuilder) Catalog(catalog *FeatureCatalog) *GeneratorBuilder {
+	g.catalog = catalog
+	return g
+}
+
+// Editions sets the `EditionCatalog` used by `CreateEditionInfo`.
+func (g *GeneratorBuilder) Editions(editions *EditionCatalog) *GeneratorBuilder {
+	g.editions = editions
+	return g
+}
+
+// EncryptClaims sets a `ClaimEncryptor` that encrypts the sensitive claims of each
+// license before it is signed.
+func (g *GeneratorBuilder) EncryptClaims(encryptor *ClaimEncryptor) *GeneratorBuilder {
+	g.encryptor = encryptor
+	return g
+}
+
+// CreateEditionInfo creates a `license.FeatureInfo` with default values set and the
+// features of the _selection_ expanded using the catalog set by `Editions`.
+//
+// If the selection can not be expanded, the error is set.
+func (g *GeneratorBuilder) CreateEditionInfo(selection EditionSelection) *FeatureInfo {
+
+	info, err := g.CreateEditionInfoE(selection)
+
+	if err != nil {
+		g.err = err
+	}
+
+	return info
+}
+
+// CreateEditionInfoE is the same as `CreateEditionInfo` but returns the error instead of
+// setting the error state.
+func (g *GeneratorBuilder) CreateEditionInfoE(selection EditionSelection) (*FeatureInfo, error) {
+
+	info := g.gen.CreateFeatureInfo()
+
+	if g.editions == nil {
+		return info, fmt.Errorf("no edition catalog set")
+	}
+
+	return info, g.editions.Apply(info, selection)
+}
+
 // Create generates a new license.
+//
+// If a `IssuanceGuard` is set, it is consulted without any caller claims, use `CreateFor`
+// to pass the claims of the caller.
 func (g *GeneratorBuilder) Create(info *FeatureInfo) string {
+	return g.CreateFor(nil, info)
+}
+
+// CreateFor generates a new license on behalf of a caller with the claims _caller_. If a
+// `FeatureCatalog` is set, the features are validated first. If a `IssuanceGuard` is set
+// and it denies the issuance, an empty string is returned and the error is set. If a
+// `ClaimEncryptor` is set, the sensitive claims are encrypted last in a copy of _info_.
+func (g *GeneratorBuilder) CreateFor(caller map[string]interface{}, info *FeatureInfo) string {
+
+	info, err := g.prepare(caller, info)
+
+	if err != nil {
+		g.err = err
+		return ""
+	}
+
 	return g.gen.Create(info)
 }
+
+// CreateForE is the same as `CreateFor` but returns the error instead of setting the
+// error state. Hence it may be used concurrently, e.g. by a server, given that the
+// `Generator` implements `GeneratorE`. Otherwise the creations are serialized and the
+// error state of the `Generator` is cleared after each creation.
+//
+// .Example Usage
+// [source,go]
+// ....
+// lic, err := generator.CreateForE(callerClaims, info)
+//
+// if errors.Is(err, license.ErrIssuanceDenied) {
+// // caller may not issue the license
+// }
+// ....
+func (g *GeneratorBuilder) CreateForE(caller map[string]interface{}, info *FeatureInfo) (string, error) {
+
+	info, err := g.prepare(caller, info)
+
+	if err != nil {
+		return "", err
+	}
+
+	if gen, ok := g.gen.(GeneratorE); ok {
+		return gen.CreateE(info)
+	}
+
+	g.genMu.Lock()
+	defer g.genMu.Unlock()
+
+	lic := g.gen.Create(info)
+	err = g.gen.Error()
+	g.gen.ClearError()
+
+	return lic, err
+}
+
+// prepare validates the features, consults the guard and encrypts the sensitive claims
+// and returns the _info_ to create. When encrypted, it is a copy such that _info_ is
+// never altered by the encryption.
+func (g *GeneratorBuilder) prepare(caller map[string]interface{}, info *FeatureInfo) (*FeatureInfo, error) {
+
+	if g.catalog != nil {
+
+		if err := g.catalog.Apply(info); err != nil {
+			return nil, err
+		}
+
+	}
+
+	if g.guard != nil {
+
+		if err := g.guard.AllowIssue(caller, info); err != nil {
+			return nil, err
+		}
+
+	}
+
+	if g.encryptor != nil {
+		return g.encryptor.EncryptCopy(info)
+	}
+
+	return info, nil
+}
@@ -0,0 +1,304 @@
+package license
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ErrInvalidFeatures is returned (wrapped) by `FeatureCatalog.Apply` when the features of
+// a license do not conform to the catalog.
+var ErrInvalidFeatures = errors.New("invalid features")
+
+var featureNameRegexp = regexp.MustCompile(`^[a-z]+(-[a-z]+)*$`)
+
+// FeatureDefinition defines a single feature in a `FeatureCatalog`.
+type FeatureDefinition struct {
+	// Name is the unique name of the feature, e.g. "settings".
+	Name string `json:"name"`
+	// Description is a human readable description of the feature.
+	Description string `json:"description,omitempty"`
+	// Schema is the _JSON Schema_ of the `FeatureImpl.Claims`. If omitted, any claims
+	// are accepted. Only a subset of _JSON Schema_ is supported, any other keyword is
+	// rejected by `FeatureCatalog.Define`.
+	Schema map[string]interface{} `json:"schema,omitempty"`
+	// Defaults are claim values that are set when the claim is not present.
+	Defaults map[string]interface{} `json:"defaults,omitempty"`
+	// Requires is the features that must be granted when this feature is granted.
+	Requires []string `json:"requires,omitempty"`
+}
+
+// FeatureCatalog is a catalog of all features that may be granted in a license. It
+// validates the feature claims of a license and fills in default claim values.
+//
+// .Example Usage
+// [source,go]
+// ....
+// catalog := license.NewFeatureCatalog().
+// Define(license.FeatureDefinition{Name: "ui"}).
+// Define(license.FeatureDefinition{
+// Name:     "settings",
+// Requires: []string{"ui"},
+// Defaults: map[string]interface{}{"access": "r"},
+// Schema: map[string]interface{}{
+// "type":                 "object",
+// "additionalProperties": false,
+// "properties": map[string]interface{}{
+// "access": map[string]interface{}{"enum": []string{"r", "rw"}},
+// },
+// },
+// })
+//
+// generator.Catalog(catalog)
+// ....
+type FeatureCatalog struct {
+	err      error
+	features map[string]*FeatureDefinition
+}
+
+// NewFeatureCatalog creates a new empty `FeatureCatalog`.
+func NewFeatureCatalog() *FeatureCatalog {
+
+	return &FeatureCatalog{
+		features: map[string]*FeatureDefinition{},
+	}
+
+}
+
+// Error returns the current error state.
+func (fc *FeatureCatalog) Error() error {
+	return fc.err
+}
+
+// ClearError will clear any error state.
+func (fc *FeatureCatalog) ClearError() *FeatureCatalog {
+	fc.err = nil
+	return fc
+}
+
+// Define adds the feature definition _def_ to the catalog. It is an error to define the
+// same feature twice, to use a schema keyword that is not supported or to have defaults
+// that do not conform to the schema.
+func (fc *FeatureCatalog) Define(def FeatureDefinition) *FeatureCatalog {
+
+	if fc.err != nil {
+		return fc
+	}
+
+	if !featureNameRegexp.MatchString(def.Name) {
+		fc.err = fmt.Errorf("invalid feature name %q", def.Name)
+		return fc
+	}
+
+	if _, ok := fc.features[def.Name]; ok {
+		fc.err = fmt.Errorf("feature %s already defined", def.Name)
+		return fc
+	}
+
+	// normalize to JSON types, e.g. []string to []interface{}
+	if err := normalizeJSON(&def.Schema); err != nil {
+		fc.err = err
+		return fc
+	}
+
+	if err := normalizeJSON(&def.Defaults); err != nil {
+		fc.err = err
+		return fc
+	}
+
+	if def.Schema != nil {
+
+		if err := checkSchema(def.Schema, "schema"); err != nil {
+			fc.err = fmt.Errorf("feature %s: %w", def.Name, err)
+			return fc
+		}
+
+	}
+
+	if def.Schema != nil && def.Defaults != nil {
+
+		if errs := validateSchema(def.Schema, def.Defaults, def.Name); len(errs) > 0 {
+			fc.err = fmt.Errorf("feature %s defaults: %s", def.Name, strings.Join(errs, "; "))
+			return fc
+		}
+
+	}
+
+	fc.features[def.Name] = &def
+	return fc
+}
+
+// Feature returns the definition of the feature _name_ or `nil` if not defined.
+func (fc *FeatureCatalog) Feature(name string) *FeatureDefinition {
+	return fc.features[name]
+}
+
+// Names returns the names of all defined features sorted.
+func (fc *FeatureCatalog) Names() []string {
+
+	names := make([]string, 0, len(fc.features))
+
+	for name := range fc.features {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// Apply validates the features of _info_ against the catalog and sets the default claims.
+// All features, both in `FeatureInfo.Features` and `FeatureInfo.FeatureMap`, must be
+// defined and all required features must be granted.
+//
+// A feature with defaults that is granted without claims, gets a `FeatureImpl` with the
+// default claims.
+func (fc *FeatureCatalog) Apply(info *FeatureInfo) error {
+
+	if fc.err != nil {
+		return fc.err
+	}
+
+	granted := map[string]bool{}
+
+	for _, name := range info.Scopes() {
+		granted[name] = true
+	}
+
+	for name := range info.FeatureMap {
+		granted[name] = true
+	}
+
+	names := make([]string, 0, len(granted))
+
+	for name := range granted {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var errs []string
+
+	for _, name := range names {
+
+		def, ok := fc.features[name]
+
+		if !ok {
+			errs = append(errs, fmt.Sprintf("%s: unknown feature", name))
+			continue
+		}
+
+		for _, required := range def.Requires {
+
+			if !granted[required] {
+				errs = append(errs, fmt.Sprintf("%s: requires feature %s", name, required))
+			}
+
+		}
+
+		errs = append(errs, fc.applyClaims(info, def)...)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidFeatures, strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// applyClaims sets the default claims and validates the claims of the feature _def_.
+func (fc *FeatureCatalog) applyClaims(info *FeatureInfo, def *FeatureDefinition) []string {
+
+	feature, ok := info.FeatureMap[def.Name]
+
+	if !ok && def.Defaults != nil {
+
+		feature = NewFeature(def.Name)
+
+		if info.FeatureMap == nil {
+			info.FeatureMap = map[string]Feature{}
+		}
+
+		info.FeatureMap[def.Name] = feature
+	}
+
+	if impl, ok := feature.(*FeatureImpl); ok {
+
+		if impl.Claims == nil {
+			impl.Claims = map[string]interface{}{}
+		}
+
+		for k, v := range def.Defaults {
+
+			if _, exists := impl.Claims[k]; !exists {
+				impl.Claims[k] = v
+			}
+
+		}
+
+	}
+
+	if feature == nil || def.Schema == nil {
+		return nil
+	}
+
+	var rendered struct {
+		Claims interface{} `json:"claims"`
+	}
+
+	data, err := json.Marshal(feature)
+
+	if err == nil {
+		err = json.Unmarshal(data, &rendered)
+	}
+
+	if err != nil {
+		return []string{fmt.Sprintf("%s: %s", def.Name, err)}
+	}
+
+	if rendered.Claims == nil {
+		rendered.Claims = map[string]interface{}{}
+	}
+
+	return validateSchema(def.Schema, rendered.Claims, def.Name)
+}
+
+// ToJSON will marshal the catalog as _JSON_, i.e. `{"features": [...]}` with the
+// features sorted by name.
+func (fc *FeatureCatalog) ToJSON() ([]byte, error) {
+
+	catalog := struct {
+		Features []*FeatureDefinition `json:"features"`
+	}{Features: []*FeatureDefinition{}}
+
+	for _, name := range fc.Names() {
+		catalog.Features = append(catalog.Features, fc.features[name])
+	}
+
+	return json.Marshal(&catalog)
+}
+
+// normalizeJSON converts the _m_ to only contain _JSON_ types.
+func normalizeJSON(m *map[string]interface{}) error {
+
+	if *m == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(*m)
+
+	if err != nil {
+		return err
+	}
+
+	var normalized map[string]interface{}
+
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return err
+	}
+
+	*m = normalized
+	return nil
+}
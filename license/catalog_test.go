@@ -0,0 +1,128 @@
+package license
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testCatalog() *FeatureCatalog {
+
+	return NewFeatureCatalog().
+		Define(FeatureDefinition{Name: "ui"}).
+		Define(FeatureDefinition{Name: "simulator"}).
+		Define(FeatureDefinition{
+			Name:     "settings",
+			Requires: []string{"ui"},
+			Defaults: map[string]interface{}{"access": "r", "ai": true},
+			Schema: map[string]interface{}{
+				"type":                 "object",
+				"additionalProperties": false,
+				"properties": map[string]interface{}{
+					"access": map[string]interface{}{"enum": []string{"r", "rw"}},
+					"ai":     map[string]interface{}{"type": "boolean"},
+					"ao":     map[string]interface{}{"type": "boolean"},
+				},
+			},
+		})
+}
+
+func TestFeatureCatalogFillsDefaults(t *testing.T) {
+
+	catalog := testCatalog()
+	assert.Equal(t, nil, catalog.Error())
+
+	info := (&FeatureInfo{}).Feature("ui").Feature("settings")
+	assert.Equal(t, nil, catalog.Apply(info))
+
+	assert.Equal(t, map[string]interface{}{"access": "r", "ai": true},
+		info.FeatureMap["settings"].(*FeatureImpl).Claims)
+
+	info = (&FeatureInfo{}).Feature("ui").FeatureDetails(map[string]Feature{
+		"settings": &FeatureImpl{Claims: map[string]interface{}{"access": "rw", "ao": true}},
+	})
+
+	assert.Equal(t, nil, catalog.Apply(info))
+	assert.Equal(t, map[string]interface{}{"access": "rw", "ao": true, "ai": true},
+		info.FeatureMap["settings"].(*FeatureImpl).Claims)
+}
+
+func TestFeatureCatalogRejectsInvalidFeatures(t *testing.T) {
+
+	catalog := testCatalog()
+
+	info := (&FeatureInfo{}).Feature("settings").Feature("admin").FeatureDetails(map[string]Feature{
+		"settings": &FeatureImpl{Claims: map[string]interface{}{"acess": "rw", "ai": 1}},
+	})
+
+	err := catalog.Apply(info)
+
+	assert.True(t, errors.Is(err, ErrInvalidFeatures))
+	assert.Contains(t, err.Error(), "admin: unknown feature")
+	assert.Contains(t, err.Error(), "settings: requires feature ui")
+	assert.Contains(t, err.Error(), "settings/acess: is not allowed")
+	assert.Contains(t, err.Error(), "settings/ai: expected boolean, got integer")
+
+	catalog.Define(FeatureDefinition{
+		Name:     "regulate",
+		Defaults: map[string]interface{}{"max": -1},
+		Schema: map[string]interface{}{
+			"properties": map[string]interface{}{"max": map[string]interface{}{"minimum": 0}},
+		},
+	})
+
+	assert.Contains(t, catalog.Error().Error(), "regulate/max: must be >= 0")
+}
+
+func TestFeatureCatalogToJSON(t *testing.T) {
+
+	data, err := NewFeatureCatalog().
+		Define(FeatureDefinition{Name: "ui", Description: "User interface"}).
+		Define(FeatureDefinition{Name: "settings", Requires: []string{"ui"}}).
+		ToJSON()
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t,
+		`{"features":[{"name":"settings","requires":["ui"]},{"name":"ui","description":"User interface"}]}`,
+		string(data))
+}
+
+func TestFeatureCatalogRejectsUnsupportedSchemaKeywords(t *testing.T) {
+
+	catalog := NewFeatureCatalog().Define(FeatureDefinition{
+		Name: "settings",
+		Schema: map[string]interface{}{
+			"type":                "object",
+			"additonalProperties": false,
+		},
+	})
+
+	assert.EqualError(t, catalog.Error(),
+		"feature settings: schema: unsupported schema keyword additonalProperties")
+
+	catalog = NewFeatureCatalog().Define(FeatureDefinition{
+		Name: "settings",
+		Schema: map[string]interface{}{
+			"description": "settings of the user interface",
+			"properties": map[string]interface{}{
+				"access": map[string]interface{}{
+					"oneOf": []interface{}{map[string]interface{}{"const": "r"}},
+				},
+			},
+		},
+	})
+
+	assert.EqualError(t, catalog.Error(),
+		"feature settings: schema/properties/access: unsupported schema keyword oneOf")
+
+	catalog = NewFeatureCatalog().Define(FeatureDefinition{
+		Name: "settings",
+		Schema: map[string]interface{}{
+			"items": map[string]interface{}{"$ref": "#/definitions/item"},
+		},
+	})
+
+	assert.EqualError(t, catalog.Error(),
+		"feature settings: schema/items: unsupported schema keyword $ref")
+}
@@ -0,0 +1,364 @@
+package license
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ClaimAlgA256GCM is the _AES GCM_ encryption, using a 256 bit key, of sensitive claims.
+const ClaimAlgA256GCM = "A256GCM"
+
+// EncryptedClaims holds the encrypted values of sensitive claims and is rendered as the
+// `enc` claim in the license.
+type EncryptedClaims struct {
+	// KeyID is the, optional, id of the key that the claims are encrypted with.
+	KeyID string `json:"kid,omitempty"`
+	// Algorithm is the encryption algorithm, e.g. "A256GCM".
+	Algorithm string `json:"alg"`
+	// Claims is the _JSON_ pointers of the encrypted claims. These are readable to see
+	// which claims that are hidden, e.g. "/client_secret".
+	Claims []string `json:"claims"`
+	// IV is the base64 url encoded initialization vector.
+	IV string `json:"iv"`
+	// Data is the base64 url encoded cipher text, including the authentication tag, of
+	// the _JSON_ object of pointer and claim value.
+	Data string `json:"data"`
+}
+
+// ClaimEncryptor encrypts only the sensitive claims of a license so the remaining claims
+// e.g. scope and expiry are readable by anyone, e.g. support staff.
+//
+// The sensitive claims are _JSON_ pointers into the rendered license, e.g. "/client_secret"
+// or "/features/settings/claims/ai" or a complete feature "/features/simulator".
+//
+// .Example Usage
+// [source,go]
+// ....
+// enc := license.NewClaimEncryptor("2021-01", key).
+// Sensitive("/client_secret", "/features/settings/claims/pin")
+//
+// generator.EncryptClaims(enc)
+// validator := enc.Validator(licbuiltin.NewValidator(keys))
+// ....
+type ClaimEncryptor struct {
+	keyID     string
+	aead      cipher.AEAD
+	sensitive []string
+}
+
+// NewClaimEncryptor creates a new `ClaimEncryptor` that encrypts using the 32 bytes _key_
+// that is identified by the _keyID_.
+func NewClaimEncryptor(keyID string, key []byte) *ClaimEncryptor {
+
+	if len(key) != 32 {
+		panic("key must be 32 bytes")
+	}
+
+	block, err := aes.NewCipher(key)
+
+	if err != nil {
+		panic(err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return &ClaimEncryptor{
+		keyID: keyID,
+		aead:  aead,
+	}
+
+}
+
+// Sensitive adds the _JSON_ pointers of the _claims_ to encrypt. The license id, i.e.
+// _/jti_, can not be encrypted since it is the additional authenticated data.
+func (ce *ClaimEncryptor) Sensitive(claims ...string) *ClaimEncryptor {
+
+	for _, claim := range claims {
+
+		if !strings.HasPrefix(claim, "/") || claim == "/enc" || strings.HasPrefix(claim, "/enc/") ||
+			claim == "/jti" {
+			panic(fmt.Sprintf("invalid sensitive claim %q", claim))
+		}
+
+	}
+
+	ce.sensitive = append(ce.sensitive, claims...)
+	return ce
+}
+
+// Encrypt removes all sensitive claims present in _info_ and stores them encrypted in
+// `FeatureInfo.Encrypted`. The license id is used as additional authenticated data so
+// the encrypted claims can not be moved to another license.
+func (ce *ClaimEncryptor) Encrypt(info *FeatureInfo) error {
+
+	if info.Encrypted != nil {
+		return fmt.Errorf("license claims already encrypted")
+	}
+
+	doc, err := renderInfo(info)
+
+	if err != nil {
+		return err
+	}
+
+	values := map[string]interface{}{}
+	var claims []string
+
+	for _, pointer := range ce.sensitive {
+
+		if v, ok := removePointer(doc, pointer); ok {
+			values[pointer] = v
+			claims = append(claims, pointer)
+		}
+
+	}
+
+	if len(claims) == 0 {
+		return nil
+	}
+
+	sort.Strings(claims)
+
+	plain, err := json.Marshal(values)
+
+	if err != nil {
+		return err
+	}
+
+	iv := make([]byte, ce.aead.NonceSize())
+
+	if _, err := rand.Read(iv); err != nil {
+		return err
+	}
+
+	data := ce.aead.Seal(nil, iv, plain, []byte(info.LicenseID))
+
+	doc["enc"] = &EncryptedClaims{
+		KeyID:     ce.keyID,
+		Algorithm: ClaimAlgA256GCM,
+		Claims:    claims,
+		IV:        base64.RawURLEncoding.EncodeToString(iv),
+		Data:      base64.RawURLEncoding.EncodeToString(data),
+	}
+
+	return parseInfo(doc, info)
+}
+
+// EncryptCopy is the same as `Encrypt` but encrypts a copy of _info_ and leaves _info_
+// untouched. Hence the same _info_ may be used to create several licenses.
+func (ce *ClaimEncryptor) EncryptCopy(info *FeatureInfo) (*FeatureInfo, error) {
+
+	doc, err := renderInfo(info)
+
+	if err != nil {
+		return nil, err
+	}
+
+	cp := &FeatureInfo{}
+
+	if err := parseInfo(doc, cp); err != nil {
+		return nil, err
+	}
+
+	return cp, ce.Encrypt(cp)
+}
+
+// Decrypt decrypts the `FeatureInfo.Encrypted` claims back into _info_. It is a no-op if
+// _info_ has no encrypted claims.
+func (ce *ClaimEncryptor) Decrypt(info *FeatureInfo) error {
+
+	enc := info.Encrypted
+
+	if enc == nil {
+		return nil
+	}
+
+	if enc.Algorithm != ClaimAlgA256GCM {
+		return fmt.Errorf("unsupported claim encryption %s", enc.Algorithm)
+	}
+
+	if enc.KeyID != ce.keyID {
+		return fmt.Errorf("claims encrypted with unknown key %q", enc.KeyID)
+	}
+
+	iv, err := base64.RawURLEncoding.DecodeString(enc.IV)
+
+	if err != nil {
+		return err
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(enc.Data)
+
+	if err != nil {
+		return err
+	}
+
+	if len(iv) != ce.aead.NonceSize() {
+		return fmt.Errorf("invalid iv length %d", len(iv))
+	}
+
+	plain, err := ce.aead.Open(nil, iv, data, []byte(info.LicenseID))
+
+	if err != nil {
+		return fmt.Errorf("cannot decrypt claims: %w", err)
+	}
+
+	var values map[string]json.RawMessage
+
+	if err := json.Unmarshal(plain, &values); err != nil {
+		return err
+	}
+
+	info.Encrypted = nil
+	doc, err := renderInfo(info)
+
+	if err != nil {
+		return err
+	}
+
+	for _, pointer := range enc.Claims {
+
+		v, ok := values[pointer]
+
+		if !ok {
+			return fmt.Errorf("encrypted claim %s is missing", pointer)
+		}
+
+		if err := insertPointer(doc, pointer, v); err != nil {
+			return err
+		}
+
+	}
+
+	return parseInfo(doc, info)
+}
+
+// Validator wraps the _validator_ so the encrypted claims are decrypted after the
+// license has been validated.
+func (ce *ClaimEncryptor) Validator(validator Validator) Validator {
+	return &claimValidator{encryptor: ce, validator: validator}
+}
+
+// claimValidator implements the `Validator` interface.
+type claimValidator struct {
+	encryptor *ClaimEncryptor
+	validator Validator
+}
+
+func (cv *claimValidator) Validate(license string) (*FeatureInfo, error) {
+
+	info, err := cv.validator.Validate(license)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cv.encryptor.Decrypt(info); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidLicense, err)
+	}
+
+	return info, nil
+}
+
+// renderInfo renders the _info_ as a _JSON_ object where numbers are kept as is.
+func renderInfo(info *FeatureInfo) (map[string]interface{}, error) {
+
+	data, err := json.Marshal(info)
+
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var doc map[string]interface{}
+	return doc, dec.Decode(&doc)
+}
+
+// parseInfo replaces the _info_ with the _doc_.
+func parseInfo(doc map[string]interface{}, info *FeatureInfo) error {
+
+	data, err := json.Marshal(doc)
+
+	if err != nil {
+		return err
+	}
+
+	return info.FromJSON(data)
+}
+
+// removePointer removes the value at the _JSON_ _pointer_ from _doc_ and returns it.
+func removePointer(doc map[string]interface{}, pointer string) (interface{}, bool) {
+
+	parent, key, ok := walkPointer(doc, pointer, false)
+
+	if !ok {
+		return nil, false
+	}
+
+	v, ok := parent[key]
+
+	if ok {
+		delete(parent, key)
+	}
+
+	return v, ok
+}
+
+// insertPointer sets the _value_ at the _JSON_ _pointer_ in _doc_ and creates any
+// missing parent objects.
+func insertPointer(doc map[string]interface{}, pointer string, value json.RawMessage) error {
+
+	parent, key, ok := walkPointer(doc, pointer, true)
+
+	if !ok {
+		return fmt.Errorf("cannot insert claim %s", pointer)
+	}
+
+	parent[key] = value
+	return nil
+}
+
+// walkPointer returns the parent object and key of the _pointer_. If _create_, missing
+// parent objects are created.
+func walkPointer(doc map[string]interface{}, pointer string, create bool) (map[string]interface{}, string, bool) {
+
+	segments := strings.Split(strings.TrimPrefix(pointer, "/"), "/")
+
+	for i, s := range segments {
+		segments[i] = strings.ReplaceAll(strings.ReplaceAll(s, "~1", "/"), "~0", "~")
+	}
+
+	current := doc
+
+	for _, s := range segments[:len(segments)-1] {
+
+		next, ok := current[s].(map[string]interface{})
+
+		if !ok {
+
+			if _, exists := current[s]; exists || !create {
+				return nil, "", false
+			}
+
+			next = map[string]interface{}{}
+			current[s] = next
+		}
+
+		current = next
+	}
+
+	return current, segments[len(segments)-1], true
+}
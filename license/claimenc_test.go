@@ -0,0 +1,105 @@
+package license
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testEncryptedInfo() *FeatureInfo {
+
+	info := &FeatureInfo{
+		BaseInfo:  BaseInfo{Subject: "nisse@hult.se", Expires: 1609231906, LicenseID: "lic-1"},
+		OauthInfo: OauthInfo{ClientID: "valmatics2.x", ClientSecret: "SecretFromAWSCognito"},
+	}
+
+	return info.Feature("ui").Feature("settings").FeatureDetails(map[string]Feature{
+		"settings":  &FeatureImpl{name: "settings", Claims: map[string]interface{}{"access": "rw", "pin": "1234"}},
+		"simulator": &FeatureImpl{name: "simulator", Claims: map[string]interface{}{"factor": 1.5}},
+	})
+}
+
+func TestEncryptDecryptClaims(t *testing.T) {
+
+	enc := NewClaimEncryptor("k1", bytes.Repeat([]byte{7}, 32)).
+		Sensitive("/client_secret", "/features/settings/claims/pin", "/features/simulator", "/missing")
+
+	info := testEncryptedInfo()
+	assert.NoError(t, enc.Encrypt(info))
+
+	data, err := info.ToJSON()
+	assert.NoError(t, err)
+
+	rendered := string(data)
+	assert.NotContains(t, rendered, "SecretFromAWSCognito")
+	assert.NotContains(t, rendered, "1234")
+	assert.NotContains(t, rendered, "factor")
+	assert.Contains(t, rendered, `"scope":"ui settings"`)
+	assert.Contains(t, rendered, `"exp":1609231906`)
+	assert.Contains(t, rendered, `"access":"rw"`)
+	assert.Equal(t, []string{"/client_secret", "/features/settings/claims/pin", "/features/simulator"}, info.Encrypted.Claims)
+	assert.Equal(t, "k1", info.Encrypted.KeyID)
+
+	// as received by a validator
+	var received FeatureInfo
+	assert.NoError(t, received.FromJSON(data))
+	assert.NoError(t, enc.Decrypt(&received))
+
+	assert.Nil(t, received.Encrypted)
+	assert.Equal(t, "SecretFromAWSCognito", received.ClientSecret)
+	assert.Equal(t, "1234", received.FeatureMap["settings"].(*FeatureImpl).Claims["pin"])
+	assert.Equal(t, "rw", received.FeatureMap["settings"].(*FeatureImpl).Claims["access"])
+	assert.Equal(t, 1.5, received.FeatureMap["simulator"].(*FeatureImpl).Claims["factor"])
+	assert.Equal(t, "simulator", received.FeatureMap["simulator"].Name())
+}
+
+func TestDecryptClaimsIsBoundToLicense(t *testing.T) {
+
+	enc := NewClaimEncryptor("k1", bytes.Repeat([]byte{7}, 32)).Sensitive("/client_secret")
+
+	info := testEncryptedInfo()
+	assert.NoError(t, enc.Encrypt(info))
+
+	moved := testEncryptedInfo()
+	moved.LicenseID = "lic-2"
+	moved.ClientSecret = ""
+	moved.Encrypted = info.Encrypted
+
+	assert.Error(t, enc.Decrypt(moved))
+
+	other := NewClaimEncryptor("k1", bytes.Repeat([]byte{8}, 32))
+	assert.Error(t, other.Decrypt(info))
+
+	rotated := NewClaimEncryptor("k2", bytes.Repeat([]byte{7}, 32))
+	assert.Error(t, rotated.Decrypt(info))
+
+	// nothing sensitive present
+	plain := &FeatureInfo{BaseInfo: BaseInfo{LicenseID: "lic-3"}}
+	assert.NoError(t, enc.Encrypt(plain))
+	assert.Nil(t, plain.Encrypted)
+}
+
+func TestSensitiveRejectsLicenseID(t *testing.T) {
+
+	assert.Panics(t, func() {
+		NewClaimEncryptor("k1", bytes.Repeat([]byte{7}, 32)).Sensitive("/jti")
+	})
+}
+
+func TestEncryptCopyLeavesInfoUntouched(t *testing.T) {
+
+	enc := NewClaimEncryptor("k1", bytes.Repeat([]byte{7}, 32)).Sensitive("/client_secret")
+
+	info := testEncryptedInfo()
+	encrypted, err := enc.EncryptCopy(info)
+
+	assert.NoError(t, err)
+	assert.Nil(t, info.Encrypted)
+	assert.Equal(t, "SecretFromAWSCognito", info.ClientSecret)
+	assert.Equal(t, "", encrypted.ClientSecret)
+	assert.Equal(t, []string{"/client_secret"}, encrypted.Encrypted.Claims)
+
+	assert.NoError(t, enc.Decrypt(encrypted))
+	assert.Equal(t, "SecretFromAWSCognito", encrypted.ClientSecret)
+}
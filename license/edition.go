@@ -0,0 +1,292 @@
+package license
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Edition is a named bundle of features with preset claims, e.g. "basic", "pro" or
+// "enterprise". An edition may inherit all features of another edition.
+type Edition struct {
+	// Name is the unique name of the edition, e.g. "pro".
+	Name string `json:"name"`
+	// Description is a human readable description of the edition.
+	Description string `json:"description,omitempty"`
+	// Inherits is the, optional, name of the edition that this edition extends. Claims
+	// in this edition overrides the claims of the inherited edition.
+	Inherits string `json:"inherits,omitempty"`
+	// Features is the features of the edition and their preset claims. The claims may
+	// be `nil` if the feature do not have any claims.
+	Features map[string]map[string]interface{} `json:"features"`
+}
+
+// EditionSelection selects an edition, add-ons and customer specific overrides when
+// creating a license.
+type EditionSelection struct {
+	// Edition is the name of the edition, e.g. "pro".
+	Edition string
+	// AddOns is additional editions, or single feature names, that are added on top of
+	// the edition.
+	AddOns []string
+	// Overrides is claims, per feature, that overrides the preset claims. Only features
+	// that are part of the edition or the add-ons may be overridden.
+	Overrides map[string]map[string]interface{}
+}
+
+// EditionCatalog is a catalog of all editions that is sold.
+//
+// .Example Usage
+// [source,go]
+// ....
+// editions := license.NewEditionCatalog().
+// Define(license.Edition{Name: "basic", Features: map[string]map[string]interface{}{
+// "ui":       nil,
+// "settings": {"access": "r"},
+// }}).
+// Define(license.Edition{Name: "pro", Inherits: "basic", Features: map[string]map[string]interface{}{
+// "simulator": nil,
+// "settings":  {"access": "rw"},
+// }})
+//
+// info := generator.CreateEditionInfo(license.EditionSelection{Edition: "pro"})
+// ....
+type EditionCatalog struct {
+	err      error
+	editions map[string]*Edition
+}
+
+// NewEditionCatalog creates a new empty `EditionCatalog`.
+func NewEditionCatalog() *EditionCatalog {
+
+	return &EditionCatalog{
+		editions: map[string]*Edition{},
+	}
+
+}
+
+// Error returns the current error state.
+func (ec *EditionCatalog) Error() error {
+	return ec.err
+}
+
+// ClearError will clear any error state.
+func (ec *EditionCatalog) ClearError() *EditionCatalog {
+	ec.err = nil
+	return ec
+}
+
+// Define adds the _edition_ to the catalog. The inherited edition must already be defined.
+func (ec *EditionCatalog) Define(edition Edition) *EditionCatalog {
+
+	if ec.err != nil {
+		return ec
+	}
+
+	if !featureNameRegexp.MatchString(edition.Name) {
+		ec.err = fmt.Errorf("invalid edition name %q", edition.Name)
+		return ec
+	}
+
+	if _, ok := ec.editions[edition.Name]; ok {
+		ec.err = fmt.Errorf("edition %s already defined", edition.Name)
+		return ec
+	}
+
+	if _, ok := ec.editions[edition.Inherits]; edition.Inherits != "" && !ok {
+		ec.err = fmt.Errorf("edition %s inherits undefined edition %s", edition.Name, edition.Inherits)
+		return ec
+	}
+
+	for name := range edition.Features {
+
+		if !featureNameRegexp.MatchString(name) {
+			ec.err = fmt.Errorf("edition %s has invalid feature name %q", edition.Name, name)
+			return ec
+		}
+
+	}
+
+	ec.editions[edition.Name] = &edition
+	return ec
+}
+
+// Edition returns the edition _name_ or `nil` if not defined.
+func (ec *EditionCatalog) Edition(name string) *Edition {
+	return ec.editions[name]
+}
+
+// Names returns the names of all defined editions sorted.
+func (ec *EditionCatalog) Names() []string {
+
+	names := make([]string, 0, len(ec.editions))
+
+	for name := range ec.editions {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// Features resolves all features and their claims of the edition _name_ including the
+// inherited editions.
+func (ec *EditionCatalog) Features(name string) (map[string]map[string]interface{}, error) {
+
+	edition, ok := ec.editions[name]
+
+	if !ok {
+		return nil, fmt.Errorf("unknown edition %s", name)
+	}
+
+	features := map[string]map[string]interface{}{}
+
+	if edition.Inherits != "" {
+
+		inherited, err := ec.Features(edition.Inherits)
+
+		if err != nil {
+			return nil, err
+		}
+
+		features = inherited
+	}
+
+	mergeFeatureClaims(features, edition.Features)
+	return features, nil
+}
+
+// Apply expands the _selection_ into the `FeatureInfo.Features` and `FeatureInfo.FeatureMap`
+// of _info_ and sets `FeatureInfo.Edition`.
+//
+// Claims are resolved in the order: inherited editions, the edition, the add-ons and the
+// overrides, where a later replaces the claim of an earlier. Claims already present in
+// _info_ with the same key are replaced.
+func (ec *EditionCatalog) Apply(info *FeatureInfo, selection EditionSelection) error {
+
+	if ec.err != nil {
+		return ec.err
+	}
+
+	features, err := ec.Features(selection.Edition)
+
+	if err != nil {
+		return err
+	}
+
+	for _, addon := range selection.AddOns {
+
+		if _, ok := ec.editions[addon]; !ok {
+
+			if !featureNameRegexp.MatchString(addon) {
+				return fmt.Errorf("invalid add-on %q", addon)
+			}
+
+			mergeFeatureClaims(features, map[string]map[string]interface{}{addon: nil})
+			continue
+		}
+
+		bundle, err := ec.Features(addon)
+
+		if err != nil {
+			return err
+		}
+
+		mergeFeatureClaims(features, bundle)
+	}
+
+	for name := range selection.Overrides {
+
+		if _, ok := features[name]; !ok {
+			return fmt.Errorf("cannot override feature %s that is not part of edition %s", name, selection.Edition)
+		}
+
+	}
+
+	mergeFeatureClaims(features, selection.Overrides)
+
+	names := make([]string, 0, len(features))
+
+	for name := range features {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	granted := map[string]bool{}
+
+	for _, scope := range info.Scopes() {
+		granted[scope] = true
+	}
+
+	for _, name := range names {
+
+		if !granted[name] {
+			info.Feature(name)
+		}
+
+		claims := features[name]
+
+		if len(claims) == 0 {
+			continue
+		}
+
+		if info.FeatureMap == nil {
+			info.FeatureMap = map[string]Feature{}
+		}
+
+		impl, ok := info.FeatureMap[name].(*FeatureImpl)
+
+		if !ok {
+			impl = NewFeature(name)
+			info.FeatureMap[name] = impl
+		}
+
+		if impl.Claims == nil {
+			impl.Claims = map[string]interface{}{}
+		}
+
+		for k, v := range claims {
+			impl.Claims[k] = v
+		}
+
+	}
+
+	info.Edition = selection.Edition
+	return nil
+}
+
+// ToJSON will marshal the catalog as _JSON_, i.e. `{"editions": [...]}` with the
+// editions sorted by name.
+func (ec *EditionCatalog) ToJSON() ([]byte, error) {
+
+	catalog := struct {
+		Editions []*Edition `json:"editions"`
+	}{Editions: []*Edition{}}
+
+	for _, name := range ec.Names() {
+		catalog.Editions = append(catalog.Editions, ec.editions[name])
+	}
+
+	return json.Marshal(&catalog)
+}
+
+// mergeFeatureClaims merges the claims of _src_ into _dst_. The claim maps in _dst_ are
+// always copies.
+func mergeFeatureClaims(dst, src map[string]map[string]interface{}) {
+
+	for name, claims := range src {
+
+		merged, ok := dst[name]
+
+		if !ok || merged == nil {
+			merged = map[string]interface{}{}
+		}
+
+		for k, v := range claims {
+			merged[k] = v
+		}
+
+		dst[name] = merged
+	}
+}
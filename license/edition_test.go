@@ -0,0 +1,65 @@
+package license
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testEditions() *EditionCatalog {
+
+	return NewEditionCatalog().
+		Define(Edition{Name: "basic", Features: map[string]map[string]interface{}{
+			"ui":       nil,
+			"settings": {"access": "r", "ai": true},
+		}}).
+		Define(Edition{Name: "pro", Inherits: "basic", Features: map[string]map[string]interface{}{
+			"simulator": nil,
+			"settings":  {"access": "rw"},
+		}}).
+		Define(Edition{Name: "analytics", Features: map[string]map[string]interface{}{
+			"reports": {"max": 10},
+		}})
+}
+
+func TestEditionInheritsAndOverrides(t *testing.T) {
+
+	editions := testEditions()
+	assert.Equal(t, nil, editions.Error())
+
+	info := &FeatureInfo{}
+
+	assert.Equal(t, nil, editions.Apply(info, EditionSelection{
+		Edition:   "pro",
+		AddOns:    []string{"analytics", "regulate"},
+		Overrides: map[string]map[string]interface{}{"reports": {"max": 50}},
+	}))
+
+	assert.Equal(t, "pro", info.Edition)
+	assert.Equal(t, "regulate reports settings simulator ui", info.Features)
+	assert.Equal(t, map[string]interface{}{"access": "rw", "ai": true}, info.FeatureMap["settings"].(*FeatureImpl).Claims)
+	assert.Equal(t, map[string]interface{}{"max": 50}, info.FeatureMap["reports"].(*FeatureImpl).Claims)
+
+	// the edition itself is not modified by overrides
+	features, err := editions.Features("analytics")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, map[string]interface{}{"max": 10}, features["reports"])
+}
+
+func TestEditionErrors(t *testing.T) {
+
+	editions := testEditions()
+
+	err := editions.Apply(&FeatureInfo{}, EditionSelection{Edition: "gold"})
+	assert.Equal(t, "unknown edition gold", err.Error())
+
+	err = editions.Apply(&FeatureInfo{}, EditionSelection{
+		Edition:   "basic",
+		Overrides: map[string]map[string]interface{}{"simulator": {"speed": 2}},
+	})
+
+	assert.Equal(t, "cannot override feature simulator that is not part of edition basic", err.Error())
+
+	editions.Define(Edition{Name: "enterprise", Inherits: "platinum"})
+	assert.Equal(t, "edition enterprise inherits undefined edition platinum", editions.Error().Error())
+}
@@ -1,6 +1,11 @@
 package license
 
-import "time"
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
 
 // Generator do genereate licenses that is encoded into a JWT
 //
@@ -39,6 +44,14 @@ type Generator interface {
 	Create(info *FeatureInfo) string
 }
 
+// GeneratorE is implemented by a `Generator` that is able to return the error of each
+// creation instead of keeping it as error state. Such a `Generator` may be used
+// concurrently by `GeneratorBuilder.CreateForE`.
+type GeneratorE interface {
+	// CreateE generates a new license or returns the error.
+	CreateE(info *FeatureInfo) (string, error)
+}
+
 // JWTSignerCreator is the one actually does the generation of _JWT_ and
 // signs the payload generated by the `Generator`.
 //
@@ -49,3 +62,35 @@ type JWTSignerCreator interface {
 	// The returned string is a proper signed _JWT_.
 	SignCreate(info *FeatureInfo) (string, error)
 }
+
+// ErrIssuanceDenied is returned (wrapped) when a `IssuanceGuard` denies to issue a license.
+var ErrIssuanceDenied = errors.New("license issuance denied")
+
+// IssuanceGuard decides if a caller may issue a license. It is consulted by the
+// `GeneratorBuilder` before the license is created and signed.
+type IssuanceGuard interface {
+	// AllowIssue returns `nil` if the _caller_, i.e. the claims of the caller, may
+	// issue the license _info_. If denied, a `*IssuanceDeniedError` is returned.
+	AllowIssue(caller map[string]interface{}, info *FeatureInfo) error
+}
+
+// IssuanceDeniedError is returned by a `IssuanceGuard` when denied. It wraps
+// `ErrIssuanceDenied`.
+type IssuanceDeniedError struct {
+	// Reasons is the, optional, reasons for the denial.
+	Reasons []string
+}
+
+func (e *IssuanceDeniedError) Error() string {
+
+	if len(e.Reasons) == 0 {
+		return ErrIssuanceDenied.Error()
+	}
+
+	return fmt.Sprintf("%s: %s", ErrIssuanceDenied, strings.Join(e.Reasons, ", "))
+}
+
+// Unwrap returns `ErrIssuanceDenied`.
+func (e *IssuanceDeniedError) Unwrap() error {
+	return ErrIssuanceDenied
+}
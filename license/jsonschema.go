@@ -0,0 +1,398 @@
+package license
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+)
+
+// schemaKeywords is the supported subset of _JSON Schema_ keywords, including the
+// annotations that do not affect validation.
+var schemaKeywords = map[string]bool{
+	"type":                 true,
+	"enum":                 true,
+	"const":                true,
+	"properties":           true,
+	"required":             true,
+	"additionalProperties": true,
+	"items":                true,
+	"minItems":             true,
+	"maxItems":             true,
+	"minimum":              true,
+	"maximum":              true,
+	"exclusiveMinimum":     true,
+	"exclusiveMaximum":     true,
+	"minLength":            true,
+	"maxLength":            true,
+	"pattern":              true,
+	"$schema":              true,
+	"$comment":             true,
+	"title":                true,
+	"description":          true,
+	"default":              true,
+	"examples":             true,
+}
+
+// checkSchema checks that the _schema_, and all sub-schemas, only uses keywords that
+// `validateSchema` supports. Hence a unsupported keyword, e.g. _$ref_ or _oneOf_, or a
+// misspelled one, e.g. _additonalProperties_, is never silently ignored. The _path_ is
+// the location of the _schema_ used in the error.
+func checkSchema(schema map[string]interface{}, path string) error {
+
+	keys := make([]string, 0, len(schema))
+
+	for k := range schema {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+
+		if !schemaKeywords[k] {
+			return fmt.Errorf("%s: unsupported schema keyword %s", path, k)
+		}
+
+	}
+
+	if pattern, ok := schema["pattern"]; ok {
+
+		if s, ok := pattern.(string); !ok {
+			return fmt.Errorf("%s/pattern: must be a string", path)
+		} else if _, err := regexp.Compile(s); err != nil {
+			return fmt.Errorf("%s/pattern: %s", path, err)
+		}
+
+	}
+
+	if properties, ok := schema["properties"]; ok {
+
+		props, ok := properties.(map[string]interface{})
+
+		if !ok {
+			return fmt.Errorf("%s/properties: must be an object", path)
+		}
+
+		names := make([]string, 0, len(props))
+
+		for name := range props {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+
+		for _, name := range names {
+
+			prop, ok := props[name].(map[string]interface{})
+
+			if !ok {
+				return fmt.Errorf("%s/properties/%s: must be a schema", path, name)
+			}
+
+			if err := checkSchema(prop, path+"/properties/"+name); err != nil {
+				return err
+			}
+
+		}
+
+	}
+
+	if additional, ok := schema["additionalProperties"]; ok {
+
+		switch v := additional.(type) {
+		case bool:
+		case map[string]interface{}:
+
+			if err := checkSchema(v, path+"/additionalProperties"); err != nil {
+				return err
+			}
+
+		default:
+			return fmt.Errorf("%s/additionalProperties: must be a boolean or a schema", path)
+		}
+
+	}
+
+	if items, ok := schema["items"]; ok {
+
+		v, ok := items.(map[string]interface{})
+
+		if !ok {
+			return fmt.Errorf("%s/items: must be a schema", path)
+		}
+
+		if err := checkSchema(v, path+"/items"); err != nil {
+			return err
+		}
+
+	}
+
+	return nil
+}
+
+// validateSchema validates _value_ against the _JSON Schema_ _schema_ and returns all
+// violations. The _path_ is the location of the _value_ used in the messages.
+//
+// Only a subset of _JSON Schema_ is supported: type, enum, const, properties, required,
+// additionalProperties, items, minItems, maxItems, minimum, maximum, exclusiveMinimum,
+// exclusiveMaximum, minLength, maxLength and pattern. Use `checkSchema` to reject any
+// other keyword.
+func validateSchema(schema map[string]interface{}, value interface{}, path string) []string {
+
+	var errs []string
+
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Sprintf("%s: %s", path, fmt.Sprintf(format, args...)))
+	}
+
+	if t, ok := schema["type"]; ok && !schemaTypeMatches(t, value) {
+		fail("expected %v, got %s", t, jsonTypeOf(value))
+		return errs
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+
+		found := false
+
+		for _, e := range enum {
+
+			if jsonEqual(e, value) {
+				found = true
+				break
+			}
+
+		}
+
+		if !found {
+			fail("must be one of %v", enum)
+		}
+
+	}
+
+	if c, ok := schema["const"]; ok && !jsonEqual(c, value) {
+		fail("must be %v", c)
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		errs = append(errs, validateObject(schema, v, path)...)
+	case []interface{}:
+
+		if n, ok := toFloat(schema["minItems"]); ok && float64(len(v)) < n {
+			fail("must have at least %v items", n)
+		}
+
+		if n, ok := toFloat(schema["maxItems"]); ok && float64(len(v)) > n {
+			fail("must have at most %v items", n)
+		}
+
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+
+			for i, item := range v {
+				errs = append(errs, validateSchema(items, item, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+
+		}
+
+	case string:
+
+		if n, ok := toFloat(schema["minLength"]); ok && float64(len([]rune(v))) < n {
+			fail("must be at least %v characters", n)
+		}
+
+		if n, ok := toFloat(schema["maxLength"]); ok && float64(len([]rune(v))) > n {
+			fail("must be at most %v characters", n)
+		}
+
+		if pattern, ok := schema["pattern"].(string); ok {
+
+			re, err := regexp.Compile(pattern)
+
+			if err != nil {
+				fail("invalid pattern %s", pattern)
+			} else if !re.MatchString(v) {
+				fail("must match %s", pattern)
+			}
+
+		}
+
+	default:
+
+		f, ok := toFloat(value)
+
+		if !ok {
+			break
+		}
+
+		if n, ok := toFloat(schema["minimum"]); ok && f < n {
+			fail("must be >= %v", n)
+		}
+
+		if n, ok := toFloat(schema["maximum"]); ok && f > n {
+			fail("must be <= %v", n)
+		}
+
+		if n, ok := toFloat(schema["exclusiveMinimum"]); ok && f <= n {
+			fail("must be > %v", n)
+		}
+
+		if n, ok := toFloat(schema["exclusiveMaximum"]); ok && f >= n {
+			fail("must be < %v", n)
+		}
+
+	}
+
+	return errs
+}
+
+func validateObject(schema map[string]interface{}, obj map[string]interface{}, path string) []string {
+
+	var errs []string
+
+	properties, _ := schema["properties"].(map[string]interface{})
+
+	for _, r := range toStrings(schema["required"]) {
+
+		if _, ok := obj[r]; !ok {
+			errs = append(errs, fmt.Sprintf("%s/%s: is required", path, r))
+		}
+
+	}
+
+	keys := make([]string, 0, len(obj))
+
+	for k := range obj {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+
+		if prop, ok := properties[k].(map[string]interface{}); ok {
+			errs = append(errs, validateSchema(prop, obj[k], path+"/"+k)...)
+			continue
+		}
+
+		if _, ok := properties[k]; ok {
+			continue
+		}
+
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+
+			if !additional {
+				errs = append(errs, fmt.Sprintf("%s/%s: is not allowed", path, k))
+			}
+
+		case map[string]interface{}:
+			errs = append(errs, validateSchema(additional, obj[k], path+"/"+k)...)
+		}
+
+	}
+
+	return errs
+}
+
+// schemaTypeMatches checks the value against a type or a list of types.
+func schemaTypeMatches(t interface{}, value interface{}) bool {
+
+	actual := jsonTypeOf(value)
+
+	for _, expected := range toStrings(t) {
+
+		if expected == actual || (expected == "number" && actual == "integer") {
+			return true
+		}
+
+	}
+
+	return false
+}
+
+// jsonTypeOf returns the _JSON Schema_ type name of _value_.
+func jsonTypeOf(value interface{}) string {
+
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	default:
+
+		if f, ok := toFloat(v); ok {
+
+			if f == math.Trunc(f) {
+				return "integer"
+			}
+
+			return "number"
+		}
+
+		return reflect.TypeOf(value).String()
+	}
+}
+
+// toFloat converts any go or _JSON_ number to a `float64`.
+func toFloat(value interface{}) (float64, bool) {
+
+	switch v := value.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int, int8, int16, int32, int64:
+		return float64(reflect.ValueOf(v).Int()), true
+	case uint, uint8, uint16, uint32, uint64:
+		return float64(reflect.ValueOf(v).Uint()), true
+	}
+
+	return 0, false
+}
+
+// toStrings converts a string or a list of strings to a slice.
+func toStrings(value interface{}) []string {
+
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []string:
+		return v
+	case []interface{}:
+
+		s := make([]string, 0, len(v))
+
+		for _, x := range v {
+			s = append(s, fmt.Sprint(x))
+		}
+
+		return s
+	}
+
+	return nil
+}
+
+// jsonEqual compares two values where numbers are compared by value.
+func jsonEqual(a, b interface{}) bool {
+
+	fa, ok := toFloat(a)
+	fb, ok2 := toFloat(b)
+
+	if ok && ok2 {
+		return fa == fb
+	}
+
+	return reflect.DeepEqual(a, b)
+}
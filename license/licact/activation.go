@@ -0,0 +1,219 @@
+package licact
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mariotoffia/gojwtlic/license"
+)
+
+// ErrFingerprintMismatch is returned (wrapped) by `ImportLicense` when the license is
+// bound to another machine.
+var ErrFingerprintMismatch = errors.New("license is bound to another machine")
+
+// ActivationRequest is created by the product on the machine to be activated. It is
+// encoded and transferred to the issuer by hand, e.g. copy/paste or a _QR_ code, when
+// the machine has no network access.
+type ActivationRequest struct {
+	// Fingerprint is the machine fingerprint, see `MachineFingerprint`.
+	Fingerprint string `json:"fpt"`
+	// Product is the name of the product to be activated.
+	Product string `json:"product"`
+	// Version is the version of the product.
+	Version string `json:"ver"`
+	// LicenseID is the, optional, id of the license that is requested, e.g. when
+	// re-activating a license on a new machine. It is only honoured by `Activate` when
+	// the request is signed.
+	LicenseID string `json:"jti,omitempty"`
+	// Nonce is a random value that makes each request unique.
+	Nonce string `json:"nonce"`
+	// Created is the unix epoch time when the request was created.
+	Created int64 `json:"iat"`
+	// signed is set when the signature was verified when decoded.
+	signed bool
+}
+
+// NewActivationRequest creates a new activation request for the machine with the
+// _fingerprint_.
+//
+// .Example Usage
+// [source,go]
+// ....
+// fpt, err := licact.MachineFingerprint()
+// req, err := licact.NewActivationRequest(fpt, "valmatics", "2.0.0", "").Encode()
+// ....
+func NewActivationRequest(fingerprint, product, version, licenseID string) *ActivationRequest {
+
+	nonce := make([]byte, 10)
+
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+
+	return &ActivationRequest{
+		Fingerprint: fingerprint,
+		Product:     product,
+		Version:     version,
+		LicenseID:   licenseID,
+		Nonce:       encoding.EncodeToString(nonce),
+		Created:     time.Now().Unix(),
+	}
+
+}
+
+// Encode encodes the request protected by a checksum only.
+func (r *ActivationRequest) Encode() (string, error) {
+
+	payload, err := json.Marshal(r)
+
+	if err != nil {
+		return "", err
+	}
+
+	e := &envelope{kind: kindRequest, payload: payload}
+	return e.encode(), nil
+}
+
+// EncodeSigned encodes the request and signs it using the private key of _keys_. The
+// issuer needs the public key to decode the request.
+func (r *ActivationRequest) EncodeSigned(keys license.RSAKeyPair) (string, error) {
+
+	if keys.PrivateKey() == nil {
+		return "", fmt.Errorf("no private key to sign the request with")
+	}
+
+	payload, err := json.Marshal(r)
+
+	if err != nil {
+		return "", err
+	}
+
+	e := &envelope{kind: kindSignedRequest, payload: payload}
+
+	if err := e.sign(keys.PrivateKey()); err != nil {
+		return "", err
+	}
+
+	return e.encode(), nil
+}
+
+// Signed returns `true` if the request was signed and the signature verified by
+// `DecodeActivationRequest`.
+func (r *ActivationRequest) Signed() bool {
+	return r.signed
+}
+
+// DecodeActivationRequest decodes an encoded request. If _keys_ is not `nil`, the request
+// must be signed and the signature is verified using the public key of _keys_.
+func DecodeActivationRequest(s string, keys license.RSAKeyPair) (*ActivationRequest, error) {
+
+	e, err := decodeEnvelope(s)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.kind {
+	case kindRequest:
+
+		if keys != nil {
+			return nil, fmt.Errorf("activation request is not signed")
+		}
+
+	case kindSignedRequest:
+
+		if keys == nil {
+			return nil, fmt.Errorf("activation request is signed but no key to verify it with")
+		}
+
+		if err := e.verify(keys.PublicKey()); err != nil {
+			return nil, fmt.Errorf("activation request signature: %w", err)
+		}
+
+	default:
+		return nil, fmt.Errorf("%w: not an activation request", ErrCorrupt)
+	}
+
+	var r ActivationRequest
+
+	if err := json.Unmarshal(e.payload, &r); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrCorrupt, err)
+	}
+
+	if r.Fingerprint == "" {
+		return nil, fmt.Errorf("activation request has no fingerprint")
+	}
+
+	r.signed = e.kind == kindSignedRequest
+	return &r, nil
+}
+
+// Activate creates a license from _info_ that is bound to the machine of the _request_
+// and returns it encoded. If the request is signed and has a `ActivationRequest.LicenseID`
+// it is used as the license id. The license id of an unsigned request can not be trusted
+// and is ignored, set it in _info_ after it has been checked instead.
+//
+// .Example Usage
+// [source,go]
+// ....
+// req, err := licact.DecodeActivationRequest(s, nil)
+// info := generator.CreateEditionInfo(license.EditionSelection{Edition: "pro"}).
+// WithSubject("nisse@hult.se")
+//
+// lic, err := licact.Activate(req, generator, info)
+// ....
+func Activate(
+	request *ActivationRequest,
+	generator *license.GeneratorBuilder,
+	info *license.FeatureInfo) (string, error) {
+
+	info.Fingerprint = request.Fingerprint
+
+	if request.LicenseID != "" && request.Signed() {
+		info.LicenseID = request.LicenseID
+	}
+
+	token := generator.Create(info)
+
+	if err := generator.Error(); err != nil {
+		return "", err
+	}
+
+	e := &envelope{kind: kindLicense, payload: []byte(token)}
+	return e.encode(), nil
+}
+
+// ImportLicense decodes and validates an activated license and checks that it is bound
+// to the machine with the _fingerprint_. The license information and the license _JWT_
+// are returned.
+func ImportLicense(
+	s string,
+	validator license.Validator,
+	fingerprint string) (*license.FeatureInfo, string, error) {
+
+	e, err := decodeEnvelope(s)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	if e.kind != kindLicense {
+		return nil, "", fmt.Errorf("%w: not a license", ErrCorrupt)
+	}
+
+	token := string(e.payload)
+	info, err := validator.Validate(token)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	if info.Fingerprint != fingerprint {
+		return nil, "", ErrFingerprintMismatch
+	}
+
+	return info, token, nil
+}
@@ -0,0 +1,137 @@
+package licact
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mariotoffia/gojwtlic/license/licjwt"
+	"github.com/mariotoffia/gojwtlic/license/licjwt/licbuiltin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeDecodeRequest(t *testing.T) {
+
+	req := NewActivationRequest(Fingerprint("id:abc", "host:nisse"), "valmatics", "2.0.0", "lic-1")
+
+	s, err := req.Encode()
+	assert.NoError(t, err)
+	assert.Regexp(t, `^[A-Z2-7]{1,8}(-[A-Z2-7]{1,8})*$`, s)
+
+	// lower case, without dashes and with line breaks is accepted
+	mangled := strings.ToLower(strings.ReplaceAll(s, "-", ""))
+	mangled = mangled[:10] + "\n" + mangled[10:]
+
+	decoded, err := DecodeActivationRequest(mangled, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, req, decoded)
+}
+
+func TestDecodeRequestTypoIsDetected(t *testing.T) {
+
+	s, err := NewActivationRequest("fpt", "valmatics", "2.0.0", "").Encode()
+	assert.NoError(t, err)
+
+	typo := []byte(s)
+
+	if typo[3] == 'A' {
+		typo[3] = 'B'
+	} else {
+		typo[3] = 'A'
+	}
+
+	_, err = DecodeActivationRequest(string(typo), nil)
+	assert.True(t, errors.Is(err, ErrCorrupt))
+}
+
+func TestSignedRequest(t *testing.T) {
+
+	keys := licbuiltin.NewRSAKeys(1024)
+	req := NewActivationRequest("fpt", "valmatics", "2.0.0", "")
+
+	s, err := req.EncodeSigned(keys)
+	assert.NoError(t, err)
+
+	decoded, err := DecodeActivationRequest(s, keys)
+	assert.NoError(t, err)
+	assert.True(t, decoded.Signed())
+
+	decoded.signed = false
+	assert.Equal(t, req, decoded)
+
+	_, err = DecodeActivationRequest(s, licbuiltin.NewRSAKeys(1024))
+	assert.Error(t, err)
+
+	_, err = DecodeActivationRequest(s, nil)
+	assert.Error(t, err)
+
+	unsigned, err := req.Encode()
+	assert.NoError(t, err)
+
+	_, err = DecodeActivationRequest(unsigned, keys)
+	assert.Error(t, err)
+}
+
+func TestActivateAndImport(t *testing.T) {
+
+	keys := licbuiltin.NewRSAKeys(2048)
+	fpt := Fingerprint("id:abc", "host:nisse")
+
+	generator := licjwt.NewGeneratorBuilderWithSigner(licbuiltin.NewSignCreator(keys, "RS256")).
+		Audience("https://api.valmatics.se").
+		Issuer("https://api.valmatics.se/licmgr").
+		LicenseLength(time.Hour * 24)
+
+	s, err := NewActivationRequest(fpt, "valmatics", "2.0.0", "lic-1").Encode()
+	assert.NoError(t, err)
+
+	req, err := DecodeActivationRequest(s, nil)
+	assert.NoError(t, err)
+
+	lic, err := Activate(req, generator, generator.CreateFeatureInfo().
+		Feature("ui").
+		WithSubject("nisse@hult.se"))
+
+	assert.NoError(t, err)
+
+	validator := licbuiltin.NewValidator(keys)
+
+	info, token, err := ImportLicense(lic, validator, fpt)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, strings.Count(token, ".")+1)
+	assert.Equal(t, fpt, info.Fingerprint)
+	assert.NotEqual(t, "lic-1", info.LicenseID, "unsigned request must not set license id")
+	assert.True(t, info.HasFeature("ui"))
+
+	_, _, err = ImportLicense(lic, validator, Fingerprint("id:other"))
+	assert.True(t, errors.Is(err, ErrFingerprintMismatch))
+
+	_, _, err = ImportLicense(s, validator, fpt)
+	assert.True(t, errors.Is(err, ErrCorrupt))
+
+	// signed request may request the license id
+	s, err = NewActivationRequest(fpt, "valmatics", "2.0.0", "lic-1").EncodeSigned(keys)
+	assert.NoError(t, err)
+
+	req, err = DecodeActivationRequest(s, keys)
+	assert.NoError(t, err)
+
+	lic, err = Activate(req, generator, generator.CreateFeatureInfo().WithSubject("nisse@hult.se"))
+	assert.NoError(t, err)
+
+	info, _, err = ImportLicense(lic, validator, fpt)
+	assert.NoError(t, err)
+	assert.Equal(t, "lic-1", info.LicenseID)
+}
+
+func TestFingerprintIsOrderIndependent(t *testing.T) {
+
+	assert.Equal(t, Fingerprint("a", "b"), Fingerprint("b", "a"))
+	assert.NotEqual(t, Fingerprint("a", "b"), Fingerprint("a", "c"))
+	assert.Len(t, Fingerprint("a"), 16)
+
+	fpt, err := MachineFingerprint()
+	assert.NoError(t, err)
+	assert.Len(t, fpt, 16)
+}
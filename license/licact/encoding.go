@@ -0,0 +1,150 @@
+package licact
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrCorrupt is returned (wrapped) when an encoded activation file is not valid, e.g.
+// mistyped when copied by hand.
+var ErrCorrupt = errors.New("corrupt activation data")
+
+const (
+	// formatVersion is the version of the binary envelope.
+	formatVersion byte = 1
+	// checksumLength is the number of bytes of the _SHA-256_ checksum that ends the envelope.
+	checksumLength = 8
+	// groupLength is the number of characters in each '-' separated group.
+	groupLength = 8
+)
+
+// kind is the type of payload in an envelope.
+type kind byte
+
+const (
+	kindRequest       kind = 1
+	kindSignedRequest kind = 2
+	kindLicense       kind = 3
+)
+
+// encoding is the _RFC 4648_ base32 alphabet, i.e. upper case A-Z and 2-7, that is both
+// easy to type and can be used in the alphanumeric mode of _QR_ codes.
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// envelope is the decoded binary format
+//
+// [version][kind][payload length (uint32)][payload][signature][checksum]
+type envelope struct {
+	kind      kind
+	payload   []byte
+	signature []byte
+}
+
+// signed returns the header and payload, i.e. the part that is signed.
+func (e *envelope) signed() []byte {
+
+	var buf bytes.Buffer
+
+	buf.WriteByte(formatVersion)
+	buf.WriteByte(byte(e.kind))
+	binary.Write(&buf, binary.BigEndian, uint32(len(e.payload)))
+	buf.Write(e.payload)
+
+	return buf.Bytes()
+}
+
+// sign signs the envelope using _key_.
+func (e *envelope) sign(key *rsa.PrivateKey) error {
+
+	digest := sha256.Sum256(e.signed())
+
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+
+	if err != nil {
+		return err
+	}
+
+	e.signature = sig
+	return nil
+}
+
+// verify verifies the signature using _key_.
+func (e *envelope) verify(key *rsa.PublicKey) error {
+
+	digest := sha256.Sum256(e.signed())
+	return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], e.signature)
+}
+
+// encode encodes the envelope as '-' separated groups of base32 characters.
+func (e *envelope) encode() string {
+
+	data := append(e.signed(), e.signature...)
+	sum := sha256.Sum256(data)
+	data = append(data, sum[:checksumLength]...)
+
+	s := encoding.EncodeToString(data)
+	groups := make([]string, 0, len(s)/groupLength+1)
+
+	for len(s) > groupLength {
+		groups = append(groups, s[:groupLength])
+		s = s[groupLength:]
+	}
+
+	return strings.Join(append(groups, s), "-")
+}
+
+// decodeEnvelope decodes an encoded envelope. All whitespace and '-' are ignored and lower
+// case characters are accepted.
+func decodeEnvelope(s string) (*envelope, error) {
+
+	s = strings.Map(func(r rune) rune {
+
+		if r == '-' || r == ' ' || r == '\t' || r == '\r' || r == '\n' {
+			return -1
+		}
+
+		return r
+
+	}, strings.ToUpper(s))
+
+	data, err := encoding.DecodeString(s)
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrCorrupt, err)
+	}
+
+	if len(data) < 6+checksumLength {
+		return nil, fmt.Errorf("%w: too short", ErrCorrupt)
+	}
+
+	body, checksum := data[:len(data)-checksumLength], data[len(data)-checksumLength:]
+	sum := sha256.Sum256(body)
+
+	if !bytes.Equal(sum[:checksumLength], checksum) {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrCorrupt)
+	}
+
+	if body[0] != formatVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrCorrupt, body[0])
+	}
+
+	n := int(binary.BigEndian.Uint32(body[2:6]))
+
+	if n > len(body)-6 {
+		return nil, fmt.Errorf("%w: invalid payload length", ErrCorrupt)
+	}
+
+	return &envelope{
+		kind:      kind(body[1]),
+		payload:   body[6 : 6+n],
+		signature: body[6+n:],
+	}, nil
+}
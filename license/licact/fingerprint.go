@@ -0,0 +1,67 @@
+package licact
+
+import (
+	"crypto/sha256"
+	"net"
+	"os"
+	"sort"
+	"strings"
+)
+
+// machineIDFiles is the files, in order, where the machine id is read from.
+var machineIDFiles = []string{"/etc/machine-id", "/var/lib/dbus/machine-id"}
+
+// Fingerprint creates a fingerprint from the _parts_, e.g. a machine id and a product
+// serial number. The order of the parts do not matter.
+func Fingerprint(parts ...string) string {
+
+	sorted := append([]string{}, parts...)
+	sort.Strings(sorted)
+
+	sum := sha256.Sum256([]byte(strings.Join(sorted, "\n")))
+	return encoding.EncodeToString(sum[:10])
+}
+
+// MachineFingerprint creates a fingerprint of the current machine from the machine id,
+// if available, the host name and the hardware addresses of all non loopback interfaces.
+//
+// NOTE: The fingerprint changes if the host name or any network interface is changed.
+// Use `Fingerprint` with more stable parts if this is an issue.
+func MachineFingerprint() (string, error) {
+
+	var parts []string
+
+	for _, file := range machineIDFiles {
+
+		if id, err := os.ReadFile(file); err == nil {
+			parts = append(parts, "id:"+strings.TrimSpace(string(id)))
+			break
+		}
+
+	}
+
+	host, err := os.Hostname()
+
+	if err != nil {
+		return "", err
+	}
+
+	parts = append(parts, "host:"+host)
+
+	interfaces, err := net.Interfaces()
+
+	if err != nil {
+		return "", err
+	}
+
+	for _, i := range interfaces {
+
+		if i.Flags&net.FlagLoopback != 0 || len(i.HardwareAddr) == 0 {
+			continue
+		}
+
+		parts = append(parts, "mac:"+i.HardwareAddr.String())
+	}
+
+	return Fingerprint(parts...), nil
+}
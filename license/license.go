@@ -3,6 +3,8 @@ package license
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
+	"time"
 )
 
 // BaseInfo is the base information block
@@ -111,13 +113,93 @@ type FeatureInfo struct {
 	Features string `json:"scope,omitempty"`
 	// FeatureMap contains name values of non standard claim features.
 	FeatureMap map[string] /*name*/ Feature `json:"features,omitempty"`
+	// Fingerprint is the, optional, machine fingerprint that the license is bound to when
+	// activated, see package _licact_.
+	Fingerprint string `json:"fpt,omitempty"`
+	// Edition is the, optional, product edition that the features where expanded from,
+	// e.g. "pro". See `EditionCatalog`.
+	Edition string `json:"edition,omitempty"`
+	// Encrypted is the, optional, encrypted sensitive claims, see `ClaimEncryptor`.
+	Encrypted *EncryptedClaims `json:"enc,omitempty"`
 }
 
-// Valid will return an error if the `FeatureInfo` is not valid
+// Valid will return an error if the `FeatureInfo` is not valid, i.e. it has expired or is
+// not yet active. Unset `BaseInfo.Expires` and `BaseInfo.NotBefore` are not checked.
 func (fi *FeatureInfo) Valid() error {
+
+	now := time.Now().Unix()
+
+	if fi.Expires != 0 && now >= fi.Expires {
+		return fmt.Errorf("license expired at %s", time.Unix(fi.Expires, 0).UTC().Format(time.RFC3339))
+	}
+
+	if fi.NotBefore != 0 && now < fi.NotBefore {
+		return fmt.Errorf("license not valid before %s", time.Unix(fi.NotBefore, 0).UTC().Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// UnmarshalJSON unmarshals the license where each feature in `FeatureInfo.FeatureMap`
+// is unmarshalled as a `FeatureImpl`.
+func (fi *FeatureInfo) UnmarshalJSON(data []byte) error {
+
+	type plain FeatureInfo
+
+	aux := struct {
+		*plain
+		FeatureMap map[string]*FeatureImpl `json:"features,omitempty"`
+	}{plain: (*plain)(fi)}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	fi.FeatureMap = nil
+
+	if aux.FeatureMap != nil {
+
+		fi.FeatureMap = make(map[string]Feature, len(aux.FeatureMap))
+
+		for name, feature := range aux.FeatureMap {
+
+			if feature == nil {
+				feature = NewFeature(name)
+			}
+
+			feature.name = name
+			fi.FeatureMap[name] = feature
+		}
+
+	}
+
 	return nil
 }
 
+// Scopes returns the features in `FeatureInfo.Features` as a slice.
+func (fi *FeatureInfo) Scopes() []string {
+	return strings.Fields(fi.Features)
+}
+
+// HasFeature returns `true` if the _name_ is granted in `FeatureInfo.Features` or has
+// details in `FeatureInfo.FeatureMap`.
+func (fi *FeatureInfo) HasFeature(name string) bool {
+
+	if _, ok := fi.FeatureMap[name]; ok {
+		return true
+	}
+
+	for _, scope := range fi.Scopes() {
+
+		if scope == name {
+			return true
+		}
+
+	}
+
+	return false
+}
+
 // Feature adds a feature
 func (fi *FeatureInfo) Feature(name string) *FeatureInfo {
 
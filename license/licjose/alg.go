@@ -0,0 +1,216 @@
+package licjose
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+
+	// register the hashes used by the algorithms
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+// Algorithm is a _JWS_ signature algorithm as registered in _RFC 7518_ and _RFC 8037_.
+//
+// Only asymmetric algorithms are supported, i.e. "none" and the _HMAC_ algorithms are
+// never accepted.
+type Algorithm string
+
+const (
+	// RS256 is _RSASSA-PKCS1-v1_5_ using _SHA-256_.
+	RS256 Algorithm = "RS256"
+	// RS384 is _RSASSA-PKCS1-v1_5_ using _SHA-384_.
+	RS384 Algorithm = "RS384"
+	// RS512 is _RSASSA-PKCS1-v1_5_ using _SHA-512_.
+	RS512 Algorithm = "RS512"
+	// PS256 is _RSASSA-PSS_ using _SHA-256_.
+	PS256 Algorithm = "PS256"
+	// PS384 is _RSASSA-PSS_ using _SHA-384_.
+	PS384 Algorithm = "PS384"
+	// PS512 is _RSASSA-PSS_ using _SHA-512_.
+	PS512 Algorithm = "PS512"
+	// ES256 is _ECDSA_ using _P-256_ and _SHA-256_.
+	ES256 Algorithm = "ES256"
+	// ES384 is _ECDSA_ using _P-384_ and _SHA-384_.
+	ES384 Algorithm = "ES384"
+	// ES512 is _ECDSA_ using _P-521_ and _SHA-512_.
+	ES512 Algorithm = "ES512"
+	// EdDSA is _Ed25519_.
+	EdDSA Algorithm = "EdDSA"
+)
+
+// RSAAlgorithms is all _RSA_ algorithms.
+var RSAAlgorithms = []Algorithm{RS256, RS384, RS512, PS256, PS384, PS512}
+
+// Algorithms is all supported algorithms.
+var Algorithms = []Algorithm{RS256, RS384, RS512, PS256, PS384, PS512, ES256, ES384, ES512, EdDSA}
+
+// algorithm describes how to sign and verify using a `Algorithm`.
+type algorithm struct {
+	hash  crypto.Hash
+	pss   bool
+	curve elliptic.Curve
+}
+
+var algorithms = map[Algorithm]algorithm{
+	RS256: {hash: crypto.SHA256},
+	RS384: {hash: crypto.SHA384},
+	RS512: {hash: crypto.SHA512},
+	PS256: {hash: crypto.SHA256, pss: true},
+	PS384: {hash: crypto.SHA384, pss: true},
+	PS512: {hash: crypto.SHA512, pss: true},
+	ES256: {hash: crypto.SHA256, curve: elliptic.P256()},
+	ES384: {hash: crypto.SHA384, curve: elliptic.P384()},
+	ES512: {hash: crypto.SHA512, curve: elliptic.P521()},
+	EdDSA: {},
+}
+
+// Supported returns `true` if the algorithm is supported.
+func (a Algorithm) Supported() bool {
+	_, ok := algorithms[a]
+	return ok
+}
+
+// sign signs the _input_ using the _key_. The key must be of the type required by the
+// algorithm.
+func (a Algorithm) sign(key crypto.Signer, input []byte) ([]byte, error) {
+
+	spec, ok := algorithms[a]
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrAlgorithmNotAllowed, a)
+	}
+
+	if err := a.checkKey(key.Public()); err != nil {
+		return nil, err
+	}
+
+	if a == EdDSA {
+		return key.Sign(rand.Reader, input, crypto.Hash(0))
+	}
+
+	h := spec.hash.New()
+	h.Write(input)
+	digest := h.Sum(nil)
+
+	if spec.pss {
+		return key.Sign(rand.Reader, digest, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: spec.hash})
+	}
+
+	sig, err := key.Sign(rand.Reader, digest, spec.hash)
+
+	if err != nil || spec.curve == nil {
+		return sig, err
+	}
+
+	// ECDSA signers returns ASN.1 DER while JOSE uses R || S
+	var rs struct{ R, S *big.Int }
+
+	if _, err := asn1.Unmarshal(sig, &rs); err != nil {
+		return nil, err
+	}
+
+	size := (spec.curve.Params().BitSize + 7) / 8
+	out := make([]byte, 2*size)
+
+	rs.R.FillBytes(out[:size])
+	rs.S.FillBytes(out[size:])
+
+	return out, nil
+}
+
+// verify verifies the _sig_ of the _input_ using the _key_.
+func (a Algorithm) verify(key crypto.PublicKey, input, sig []byte) error {
+
+	spec, ok := algorithms[a]
+
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrAlgorithmNotAllowed, a)
+	}
+
+	if err := a.checkKey(key); err != nil {
+		return err
+	}
+
+	if a == EdDSA {
+
+		if !ed25519.Verify(key.(ed25519.PublicKey), input, sig) {
+			return ErrSignatureInvalid
+		}
+
+		return nil
+	}
+
+	h := spec.hash.New()
+	h.Write(input)
+	digest := h.Sum(nil)
+
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+
+		var err error
+
+		if spec.pss {
+			err = rsa.VerifyPSS(pub, spec.hash, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		} else {
+			err = rsa.VerifyPKCS1v15(pub, spec.hash, digest, sig)
+		}
+
+		if err != nil {
+			return ErrSignatureInvalid
+		}
+
+	case *ecdsa.PublicKey:
+
+		size := (spec.curve.Params().BitSize + 7) / 8
+
+		if len(sig) != 2*size {
+			return ErrSignatureInvalid
+		}
+
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return ErrSignatureInvalid
+		}
+
+	}
+
+	return nil
+}
+
+// checkKey checks that the type, and curve, of the public _key_ matches the algorithm.
+func (a Algorithm) checkKey(key crypto.PublicKey) error {
+
+	spec := algorithms[a]
+
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+
+		if spec.curve == nil && a != EdDSA {
+			return nil
+		}
+
+	case *ecdsa.PublicKey:
+
+		if spec.curve != nil && pub.Curve.Params().Name == spec.curve.Params().Name {
+			return nil
+		}
+
+	case ed25519.PublicKey:
+
+		if a == EdDSA {
+			return nil
+		}
+
+	}
+
+	return fmt.Errorf("%w: %T cannot be used with %s", ErrKeyMismatch, key, a)
+}
@@ -0,0 +1,344 @@
+package licjose
+
+import (
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrMalformed is returned (wrapped) when a token is not a compact _JWS_.
+	ErrMalformed = errors.New("malformed token")
+	// ErrAlgorithmNotAllowed is returned (wrapped) when the algorithm of a token is not
+	// in the allow-list, or not supported at all, e.g. "none" or "HS256".
+	ErrAlgorithmNotAllowed = errors.New("algorithm not allowed")
+	// ErrKeyMismatch is returned (wrapped) when the key can not be used with the algorithm.
+	ErrKeyMismatch = errors.New("key does not match algorithm")
+	// ErrSignatureInvalid is returned (wrapped) when the signature do not verify.
+	ErrSignatureInvalid = errors.New("signature is invalid")
+	// ErrCritical is returned (wrapped) when a header listed in _crit_ is not understood.
+	ErrCritical = errors.New("critical header not understood")
+)
+
+// registeredHeaders is the headers defined in _RFC 7515_ that may not be used in _crit_.
+var registeredHeaders = map[string]bool{
+	"alg": true, "jku": true, "jwk": true, "kid": true, "x5u": true, "x5c": true,
+	"x5t": true, "x5t#S256": true, "typ": true, "cty": true, "crit": true,
+}
+
+var b64 = base64.RawURLEncoding
+
+// Header is the protected header of a _JWS_.
+type Header struct {
+	// Algorithm is the signature algorithm.
+	Algorithm Algorithm `json:"alg"`
+	// Type is the, optional, media type, e.g. "JWT".
+	Type string `json:"typ,omitempty"`
+	// KeyID is the, optional, id of the signing key.
+	KeyID string `json:"kid,omitempty"`
+	// ContentType is the, optional, content type of the payload.
+	ContentType string `json:"cty,omitempty"`
+	// Critical is the extension headers that the recipient must understand.
+	Critical []string `json:"crit,omitempty"`
+	// Extra is all other headers.
+	Extra map[string]interface{} `json:"-"`
+}
+
+// MarshalJSON renders the header including the `Header.Extra` headers.
+func (h *Header) MarshalJSON() ([]byte, error) {
+
+	type plain Header
+
+	data, err := json.Marshal((*plain)(h))
+
+	if err != nil || len(h.Extra) == 0 {
+		return data, err
+	}
+
+	merged := map[string]interface{}{}
+
+	for k, v := range h.Extra {
+		merged[k] = v
+	}
+
+	if err := json.Unmarshal(data, &merged); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(merged)
+}
+
+// UnmarshalJSON parses the header where all non registered headers are put in
+// `Header.Extra`.
+func (h *Header) UnmarshalJSON(data []byte) error {
+
+	type plain Header
+
+	if err := json.Unmarshal(data, (*plain)(h)); err != nil {
+		return err
+	}
+
+	var all map[string]interface{}
+
+	if err := json.Unmarshal(data, &all); err != nil {
+		return err
+	}
+
+	for k := range all {
+
+		if registeredHeaders[k] {
+			delete(all, k)
+		}
+
+	}
+
+	h.Extra = all
+	return nil
+}
+
+// Signer creates compact _JWS_ tokens.
+//
+// .Example Usage
+// [source,go]
+// ....
+// signer := licjose.NewSigner(licjose.ES256, ecKey).KeyID("2021-01")
+// token, err := signer.Sign(info)
+// ....
+type Signer struct {
+	alg    Algorithm
+	key    crypto.Signer
+	header Header
+}
+
+// NewSigner creates a new `Signer` that signs using the _key_, e.g. a `*rsa.PrivateKey`,
+// `*ecdsa.PrivateKey`, `ed25519.PrivateKey` or a remote signer such as a _KMS_. The _key_
+// must match the _alg_.
+func NewSigner(alg Algorithm, key crypto.Signer) *Signer {
+
+	if !alg.Supported() {
+		panic(fmt.Sprintf("unsupported algorithm %q", alg))
+	}
+
+	if key == nil {
+		panic("key must be specified")
+	}
+
+	return &Signer{
+		alg:    alg,
+		key:    key,
+		header: Header{Algorithm: alg, Type: "JWT"},
+	}
+
+}
+
+// KeyID sets the _kid_ header.
+func (s *Signer) KeyID(kid string) *Signer {
+	s.header.KeyID = kid
+	return s
+}
+
+// Critical adds an extension header and marks it as critical, i.e. a recipient that do
+// not understand the header must reject the token.
+func (s *Signer) Critical(name string, value interface{}) *Signer {
+
+	if registeredHeaders[name] {
+		panic(fmt.Sprintf("registered header %s cannot be critical", name))
+	}
+
+	if s.header.Extra == nil {
+		s.header.Extra = map[string]interface{}{}
+	}
+
+	s.header.Extra[name] = value
+	s.header.Critical = append(s.header.Critical, name)
+
+	return s
+}
+
+// Sign renders the _claims_ as _JSON_ and signs them.
+func (s *Signer) Sign(claims interface{}) (string, error) {
+
+	payload, err := json.Marshal(claims)
+
+	if err != nil {
+		return "", err
+	}
+
+	return s.SignPayload(payload)
+}
+
+// SignPayload signs the raw _payload_.
+func (s *Signer) SignPayload(payload []byte) (string, error) {
+
+	header, err := json.Marshal(&s.header)
+
+	if err != nil {
+		return "", err
+	}
+
+	input := b64.EncodeToString(header) + "." + b64.EncodeToString(payload)
+	sig, err := s.alg.sign(s.key, []byte(input))
+
+	if err != nil {
+		return "", err
+	}
+
+	return input + "." + b64.EncodeToString(sig), nil
+}
+
+// Parser verifies compact _JWS_ tokens.
+//
+// .Example Usage
+// [source,go]
+// ....
+// parser := licjose.NewParser(licjose.RS256, licjose.PS256)
+//
+// var info license.FeatureInfo
+// header, err := parser.Parse(token, publicKey, &info)
+// ....
+type Parser struct {
+	allowed    map[Algorithm]bool
+	understood map[string]bool
+}
+
+// NewParser creates a `Parser` that only accepts tokens signed with any of the
+// _allowed_ algorithms.
+func NewParser(allowed ...Algorithm) *Parser {
+
+	if len(allowed) == 0 {
+		panic("at least one algorithm must be allowed")
+	}
+
+	p := &Parser{
+		allowed:    map[Algorithm]bool{},
+		understood: map[string]bool{},
+	}
+
+	for _, alg := range allowed {
+
+		if !alg.Supported() {
+			panic(fmt.Sprintf("unsupported algorithm %q", alg))
+		}
+
+		p.allowed[alg] = true
+	}
+
+	return p
+}
+
+// Understands registers the extension headers that the caller understands and therefore
+// may be listed in _crit_.
+func (p *Parser) Understands(names ...string) *Parser {
+
+	for _, name := range names {
+		p.understood[name] = true
+	}
+
+	return p
+}
+
+// Parse verifies the _token_ using the _key_ and unmarshals the payload into _claims_.
+// The header is returned so the caller may inspect e.g. `Header.Extra`.
+//
+// NOTE: Only the signature is verified, not the claims such as expiry.
+func (p *Parser) Parse(token string, key crypto.PublicKey, claims interface{}) (*Header, error) {
+
+	header, payload, err := p.Verify(token, key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrMalformed, err)
+	}
+
+	return header, nil
+}
+
+// Verify verifies the _token_ using the _key_ and returns the header and the raw payload.
+func (p *Parser) Verify(token string, key crypto.PublicKey) (*Header, []byte, error) {
+
+	parts := strings.Split(token, ".")
+
+	if len(parts) != 3 {
+		return nil, nil, fmt.Errorf("%w: expected 3 parts got %d", ErrMalformed, len(parts))
+	}
+
+	rawHeader, err := b64.DecodeString(parts[0])
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: header: %s", ErrMalformed, err)
+	}
+
+	var header Header
+
+	if err := json.Unmarshal(rawHeader, &header); err != nil {
+		return nil, nil, fmt.Errorf("%w: header: %s", ErrMalformed, err)
+	}
+
+	if !p.allowed[header.Algorithm] {
+		return nil, nil, fmt.Errorf("%w: %q", ErrAlgorithmNotAllowed, header.Algorithm)
+	}
+
+	if err := p.checkCritical(&header, rawHeader); err != nil {
+		return nil, nil, err
+	}
+
+	sig, err := b64.DecodeString(parts[2])
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: signature: %s", ErrMalformed, err)
+	}
+
+	if err := header.Algorithm.verify(key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, nil, err
+	}
+
+	payload, err := b64.DecodeString(parts[1])
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: payload: %s", ErrMalformed, err)
+	}
+
+	return &header, payload, nil
+}
+
+// checkCritical checks the _crit_ header as specified in _RFC 7515_ section 4.1.11.
+func (p *Parser) checkCritical(header *Header, raw []byte) error {
+
+	var all map[string]json.RawMessage
+
+	if err := json.Unmarshal(raw, &all); err != nil {
+		return fmt.Errorf("%w: header: %s", ErrMalformed, err)
+	}
+
+	if _, ok := all["crit"]; !ok {
+		return nil
+	}
+
+	if len(header.Critical) == 0 {
+		return fmt.Errorf("%w: crit must not be empty", ErrCritical)
+	}
+
+	for _, name := range header.Critical {
+
+		if registeredHeaders[name] {
+			return fmt.Errorf("%w: %s is a registered header", ErrCritical, name)
+		}
+
+		if _, ok := all[name]; !ok {
+			return fmt.Errorf("%w: %s is missing", ErrCritical, name)
+		}
+
+		if !p.understood[name] {
+			return fmt.Errorf("%w: %s", ErrCritical, name)
+		}
+
+	}
+
+	return nil
+}
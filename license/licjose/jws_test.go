@@ -0,0 +1,186 @@
+package licjose
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testClaims struct {
+	Subject string `json:"sub"`
+	Scope   string `json:"scope"`
+}
+
+func testKeys(t *testing.T) map[Algorithm]crypto.Signer {
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	assert.NoError(t, err)
+
+	p521, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	assert.NoError(t, err)
+
+	_, ed, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	return map[Algorithm]crypto.Signer{
+		RS256: rsaKey, RS384: rsaKey, RS512: rsaKey,
+		PS256: rsaKey, PS384: rsaKey, PS512: rsaKey,
+		ES256: p256, ES384: p384, ES512: p521,
+		EdDSA: ed,
+	}
+}
+
+func TestSignParseAllAlgorithms(t *testing.T) {
+
+	keys := testKeys(t)
+	parser := NewParser(Algorithms...)
+
+	for _, alg := range Algorithms {
+
+		token, err := NewSigner(alg, keys[alg]).KeyID("k1").Sign(&testClaims{Subject: "nisse", Scope: "ui"})
+		assert.NoError(t, err, alg)
+
+		var claims testClaims
+		header, err := parser.Parse(token, keys[alg].Public(), &claims)
+		assert.NoError(t, err, alg)
+		assert.Equal(t, alg, header.Algorithm)
+		assert.Equal(t, "k1", header.KeyID)
+		assert.Equal(t, "nisse", claims.Subject)
+
+		// tampered payload
+		parts := strings.Split(token, ".")
+		parts[1] = b64.EncodeToString([]byte(`{"sub":"nisse","scope":"ui simulator"}`))
+
+		_, err = parser.Parse(strings.Join(parts, "."), keys[alg].Public(), &claims)
+		assert.True(t, errors.Is(err, ErrSignatureInvalid), alg)
+	}
+
+}
+
+func TestAlgorithmAllowList(t *testing.T) {
+
+	keys := testKeys(t)
+	rsaKey := keys[RS256].(*rsa.PrivateKey)
+
+	token, err := NewSigner(PS256, rsaKey).Sign(&testClaims{Subject: "nisse"})
+	assert.NoError(t, err)
+
+	var claims testClaims
+
+	_, err = NewParser(RS256).Parse(token, &rsaKey.PublicKey, &claims)
+	assert.True(t, errors.Is(err, ErrAlgorithmNotAllowed))
+
+	// alg none
+	none := b64.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`)) + "." +
+		b64.EncodeToString([]byte(`{"sub":"nisse"}`)) + "."
+
+	_, err = NewParser(Algorithms...).Parse(none, &rsaKey.PublicKey, &claims)
+	assert.True(t, errors.Is(err, ErrAlgorithmNotAllowed))
+
+	// HMAC using the public key as secret
+	pub, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	assert.NoError(t, err)
+
+	input := b64.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." +
+		b64.EncodeToString([]byte(`{"sub":"nisse"}`))
+
+	mac := hmac.New(sha256.New, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub}))
+	mac.Write([]byte(input))
+
+	_, err = NewParser(Algorithms...).Parse(input+"."+b64.EncodeToString(mac.Sum(nil)), &rsaKey.PublicKey, &claims)
+	assert.True(t, errors.Is(err, ErrAlgorithmNotAllowed))
+
+	assert.Panics(t, func() { NewParser("HS256") })
+	assert.Panics(t, func() { NewSigner("none", rsaKey) })
+}
+
+func TestKeyMustMatchAlgorithm(t *testing.T) {
+
+	keys := testKeys(t)
+
+	_, err := NewSigner(ES256, keys[RS256]).Sign(&testClaims{})
+	assert.True(t, errors.Is(err, ErrKeyMismatch))
+
+	_, err = NewSigner(ES256, keys[ES384]).Sign(&testClaims{})
+	assert.True(t, errors.Is(err, ErrKeyMismatch))
+
+	token, err := NewSigner(ES256, keys[ES256]).Sign(&testClaims{})
+	assert.NoError(t, err)
+
+	var claims testClaims
+
+	_, err = NewParser(Algorithms...).Parse(token, keys[RS256].Public(), &claims)
+	assert.True(t, errors.Is(err, ErrKeyMismatch))
+}
+
+func TestCriticalHeaders(t *testing.T) {
+
+	key := testKeys(t)[ES256]
+
+	token, err := NewSigner(ES256, key).Critical("lic", "v2").Sign(&testClaims{Subject: "nisse"})
+	assert.NoError(t, err)
+
+	var claims testClaims
+
+	_, err = NewParser(ES256).Parse(token, key.Public(), &claims)
+	assert.True(t, errors.Is(err, ErrCritical))
+
+	header, err := NewParser(ES256).Understands("lic").Parse(token, key.Public(), &claims)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"lic"}, header.Critical)
+	assert.Equal(t, "v2", header.Extra["lic"])
+
+	assert.Panics(t, func() { NewSigner(ES256, key).Critical("alg", "RS256") })
+}
+
+func TestParsePEM(t *testing.T) {
+
+	keys := testKeys(t)
+
+	for _, alg := range []Algorithm{RS256, ES256, EdDSA} {
+
+		der, err := x509.MarshalPKCS8PrivateKey(keys[alg])
+		assert.NoError(t, err)
+
+		priv, err := ParsePrivateKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+		assert.NoError(t, err, alg)
+		assert.Equal(t, keys[alg].Public(), priv.Public(), alg)
+
+		der, err = x509.MarshalPKIXPublicKey(keys[alg].Public())
+		assert.NoError(t, err)
+
+		pub, err := ParsePublicKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+		assert.NoError(t, err, alg)
+		assert.Equal(t, keys[alg].Public(), pub, alg)
+	}
+
+	rsaKey := keys[RS256].(*rsa.PrivateKey)
+
+	priv, err := ParsePrivateKeyPEM(pem.EncodeToMemory(&pem.Block{
+		Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey),
+	}))
+
+	assert.NoError(t, err)
+	assert.Equal(t, rsaKey.D, priv.(*rsa.PrivateKey).D)
+
+	_, err = ParsePublicKeyPEM([]byte("not a key"))
+	assert.True(t, errors.Is(err, ErrNotPEM))
+}
@@ -0,0 +1,71 @@
+package licjose
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+// ErrNotPEM is returned when the data is not _PEM_ encoded.
+var ErrNotPEM = errors.New("key must be PEM encoded")
+
+// ParsePrivateKeyPEM parses a _PKCS #1_, _PKCS #8_ or _SEC 1_ private key.
+func ParsePrivateKeyPEM(data []byte) (crypto.Signer, error) {
+
+	block, _ := pem.Decode(data)
+
+	if block == nil {
+		return nil, ErrNotPEM
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := key.(crypto.Signer)
+
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key %T", key)
+	}
+
+	return signer, nil
+}
+
+// ParsePublicKeyPEM parses a _PKIX_ or _PKCS #1_ public key or the public key of a
+// certificate.
+func ParsePublicKeyPEM(data []byte) (crypto.PublicKey, error) {
+
+	block, _ := pem.Decode(data)
+
+	if block == nil {
+		return nil, ErrNotPEM
+	}
+
+	if key, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return cert.PublicKey, nil
+}
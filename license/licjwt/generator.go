@@ -124,25 +124,31 @@ func (g *GeneratorJWT) SetSignerCreator(creator license.JWTSignerCreator) {
 // Create generates a new license.
 func (g *GeneratorJWT) Create(info *license.FeatureInfo) string {
 
+	ss, err := g.CreateE(info)
+
+	if err != nil {
+		g.lasterr = err
+		return ""
+	}
+
+	return ss
+
+}
+
+// CreateE is the same as `Create` but returns the error instead of setting the error state.
+func (g *GeneratorJWT) CreateE(info *license.FeatureInfo) (string, error) {
+
 	if nil == g.creator {
 
 		data, err := info.ToJSON()
 
 		if err != nil {
-			g.lasterr = err
-			return ""
+			return "", err
 		}
 
-		return string(data)
+		return string(data), nil
 	}
 
-	ss, err := g.creator.SignCreate(info)
-
-	if err != nil {
-		g.lasterr = err
-		return ""
-	}
-
-	return ss
+	return g.creator.SignCreate(info)
 
 }
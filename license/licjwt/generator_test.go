@@ -1,12 +1,22 @@
 package licjwt
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/mariotoffia/gojwtlic/license"
 	"github.com/mariotoffia/gojwtlic/license/licjwt/licbuiltin"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestToJSONIndent(t *testing.T) {
@@ -78,3 +88,224 @@ func TestGenerateSingleFeature(t *testing.T) {
 
 	fmt.Println(license)
 }
+
+func TestValidateWithKeyRing(t *testing.T) {
+
+	current := licbuiltin.NewRSAKeys(2048)
+	previous := licbuiltin.NewRSAKeys(2048)
+
+	generator := NewGeneratorBuilderWithSigner(licbuiltin.NewSignCreator(previous, "RS256")).
+		LicenseLength(time.Hour)
+
+	token := generator.Create(
+		generator.CreateFeatureInfo().
+			Feature("ui").
+			FeatureDetails(map[string]license.Feature{
+				"settings": &license.FeatureImpl{Claims: map[string]interface{}{"access": "rw"}},
+			}),
+	)
+
+	info, err := licbuiltin.NewValidator(current, previous).Validate(token)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, info.HasFeature("ui"))
+	assert.Equal(t, true, info.HasFeature("settings"))
+	assert.Equal(t, "settings", info.FeatureMap["settings"].Name())
+	assert.Equal(t, "rw", info.FeatureMap["settings"].(*license.FeatureImpl).Claims["access"])
+
+	_, err = licbuiltin.NewValidator(current).Validate(token)
+	assert.True(t, errors.Is(err, license.ErrInvalidLicense))
+
+	expired := generator.CreateFeatureInfo()
+	expired.Expires = expired.Issued - 1
+
+	_, err = licbuiltin.NewValidator(previous).Validate(generator.Create(expired))
+	assert.True(t, errors.Is(err, license.ErrInvalidLicense))
+}
+
+func TestGeneratorValidatesCatalog(t *testing.T) {
+
+	generator := NewGeneratorBuilder().
+		Catalog(license.NewFeatureCatalog().
+			Define(license.FeatureDefinition{
+				Name:     "settings",
+				Defaults: map[string]interface{}{"access": "r"},
+				Schema:   map[string]interface{}{"additionalProperties": false, "properties": map[string]interface{}{"access": map[string]interface{}{}}},
+			}))
+
+	lic := generator.Create(generator.CreateFeatureInfo().Feature("settings"))
+	assert.Equal(t, nil, generator.Error())
+	assert.Contains(t, lic, `"features":{"settings":{"claims":{"access":"r"}}}`)
+
+	lic = generator.Create(generator.CreateFeatureInfo().FeatureDetails(map[string]license.Feature{
+		"settings": &license.FeatureImpl{Claims: map[string]interface{}{"acess": "rw"}},
+	}))
+
+	assert.Equal(t, "", lic)
+	assert.True(t, errors.Is(generator.Error(), license.ErrInvalidFeatures))
+}
+
+func TestGeneratorCreateEditionInfo(t *testing.T) {
+
+	generator := NewGeneratorBuilder().
+		Editions(license.NewEditionCatalog().
+			Define(license.Edition{Name: "basic", Features: map[string]map[string]interface{}{"ui": nil}}).
+			Define(license.Edition{Name: "pro", Inherits: "basic", Features: map[string]map[string]interface{}{"simulator": nil}}))
+
+	info := generator.CreateEditionInfo(license.EditionSelection{Edition: "pro"})
+	assert.Equal(t, nil, generator.Error())
+
+	lic := generator.Create(info)
+	assert.Contains(t, lic, `"scope":"simulator ui"`)
+	assert.Contains(t, lic, `"edition":"pro"`)
+
+	generator.CreateEditionInfo(license.EditionSelection{Edition: "gold"})
+	assert.NotEqual(t, nil, generator.Error())
+}
+
+func TestEncryptedLicense(t *testing.T) {
+
+	issuer := licbuiltin.NewRSAKeys(2048)
+	product := licbuiltin.NewRSAKeys(2048)
+
+	productEC, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Equal(t, nil, err)
+
+	tests := []struct {
+		alg  string
+		pub  interface{}
+		priv interface{}
+	}{
+		{licbuiltin.KeyAlgRSAOAEP, product.PublicKey(), product.PrivateKey()},
+		{licbuiltin.KeyAlgRSAOAEP256, product.PublicKey(), product.PrivateKey()},
+		{licbuiltin.KeyAlgECDHES, &productEC.PublicKey, productEC},
+	}
+
+	for _, tt := range tests {
+
+		generator := NewGeneratorBuilderWithSigner(
+			licbuiltin.NewEncryptingSignCreator(licbuiltin.NewSignCreator(issuer, "RS256"), tt.pub, tt.alg),
+		).
+			ClientID("valmatics2.x").
+			ClientSecret("SecretFromAWSCognito").
+			LicenseLength(time.Hour)
+
+		token := generator.Create(generator.CreateFeatureInfo().Feature("ui"))
+		assert.Equal(t, nil, generator.Error(), tt.alg)
+		parts := strings.Split(token, ".")
+		assert.Equal(t, 5, len(parts), tt.alg)
+		assert.False(t, strings.HasPrefix(parts[3], "ZXlK"), tt.alg) // inner "eyJ..." is not readable
+
+		info, err := licbuiltin.NewDecryptingValidator(tt.priv, licbuiltin.NewValidator(issuer)).Validate(token)
+		assert.Equal(t, nil, err, tt.alg)
+		assert.Equal(t, "SecretFromAWSCognito", info.ClientSecret, tt.alg)
+		assert.Equal(t, true, info.HasFeature("ui"), tt.alg)
+
+		// wrong product key
+		_, err = licbuiltin.NewDecryptingValidator(licbuiltin.NewRSAKeys(2048).PrivateKey(), licbuiltin.NewValidator(issuer)).
+			Validate(token)
+		assert.True(t, errors.Is(err, license.ErrInvalidLicense), tt.alg)
+
+		// wrong issuer key
+		_, err = licbuiltin.NewDecryptingValidator(tt.priv, licbuiltin.NewValidator(product)).Validate(token)
+		assert.True(t, errors.Is(err, license.ErrInvalidLicense), tt.alg)
+	}
+
+	// a plain signed license is rejected
+	plain := NewGeneratorBuilderWithSigner(licbuiltin.NewSignCreator(issuer, "RS256"))
+
+	_, err = licbuiltin.NewDecryptingValidator(product.PrivateKey(), licbuiltin.NewValidator(issuer)).
+		Validate(plain.Create(plain.CreateFeatureInfo()))
+
+	assert.True(t, errors.Is(err, license.ErrInvalidLicense))
+}
+
+func TestGeneratorEncryptsSensitiveClaims(t *testing.T) {
+
+	keys := licbuiltin.NewRSAKeys(2048)
+	enc := license.NewClaimEncryptor("k1", []byte("0123456789abcdef0123456789abcdef")).
+		Sensitive("/client_secret", "/features/settings/claims/pin")
+
+	generator := NewGeneratorBuilderWithSigner(licbuiltin.NewSignCreator(keys, "RS256")).
+		ClientID("valmatics2.x").
+		ClientSecret("SecretFromAWSCognito").
+		LicenseLength(time.Hour).
+		EncryptClaims(enc)
+
+	fi := generator.CreateFeatureInfo().
+		Feature("settings").
+		FeatureDetails(map[string]license.Feature{
+			"settings": &license.FeatureImpl{Claims: map[string]interface{}{"access": "rw", "pin": "1234"}},
+		})
+
+	token := generator.Create(fi)
+	assert.Equal(t, nil, generator.Error())
+
+	// the info is never encrypted in place, hence it may be used again
+	assert.Nil(t, fi.Encrypted)
+	assert.Equal(t, "SecretFromAWSCognito", fi.ClientSecret)
+	assert.NotEmpty(t, generator.Create(fi))
+	assert.Equal(t, nil, generator.Error())
+
+	// readable without the key
+	info, err := licbuiltin.NewValidator(keys).Validate(token)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "settings", info.Features)
+	assert.Equal(t, "", info.ClientSecret)
+	assert.Equal(t, nil, info.FeatureMap["settings"].(*license.FeatureImpl).Claims["pin"])
+
+	info, err = enc.Validator(licbuiltin.NewValidator(keys)).Validate(token)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "SecretFromAWSCognito", info.ClientSecret)
+	assert.Equal(t, "1234", info.FeatureMap["settings"].(*license.FeatureImpl).Claims["pin"])
+	assert.Equal(t, "rw", info.FeatureMap["settings"].(*license.FeatureImpl).Claims["access"])
+}
+
+func TestGenerateWithEllipticCurveAndEdDSA(t *testing.T) {
+
+	ec, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Equal(t, nil, err)
+
+	edPub, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	assert.Equal(t, nil, err)
+
+	tests := []struct {
+		signing string
+		signer  crypto.Signer
+		pub     crypto.PublicKey
+	}{
+		{"ES256", ec, &ec.PublicKey},
+		{"EdDSA", edPriv, edPub},
+	}
+
+	for _, tt := range tests {
+
+		generator := NewGeneratorBuilderWithSigner(licbuiltin.NewCryptoSignCreator(tt.signer, tt.signing)).
+			LicenseLength(time.Hour)
+
+		token := generator.Create(generator.CreateFeatureInfo().Feature("ui"))
+		assert.Equal(t, nil, generator.Error(), tt.signing)
+
+		info, err := licbuiltin.NewPublicKeyValidator(nil, tt.pub).Validate(token)
+		assert.Equal(t, nil, err, tt.signing)
+		assert.Equal(t, true, info.HasFeature("ui"), tt.signing)
+
+		_, err = licbuiltin.NewPublicKeyValidator([]string{"RS256"}, tt.pub).Validate(token)
+		assert.True(t, errors.Is(err, license.ErrInvalidLicense), tt.signing)
+	}
+}
+
+func TestValidateWithPublicKeyOnly(t *testing.T) {
+
+	keys := licbuiltin.NewRSAKeys(2048)
+
+	pub, err := x509.MarshalPKIXPublicKey(keys.PublicKey())
+	assert.Equal(t, nil, err)
+
+	public := licbuiltin.NewRSAKeysFromBuffer(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub}), nil)
+
+	generator := NewGeneratorBuilderWithSigner(licbuiltin.NewSignCreator(keys, "PS256")).
+		LicenseLength(time.Hour)
+
+	_, err = licbuiltin.NewValidator(public).Validate(generator.Create(generator.CreateFeatureInfo()))
+	assert.Equal(t, nil, err)
+}
@@ -0,0 +1,411 @@
+package licbuiltin
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"math/big"
+	"strings"
+
+	"github.com/mariotoffia/gojwtlic/license"
+)
+
+const (
+	// KeyAlgRSAOAEP is the _RSAES OAEP_ key encryption using _SHA-1_.
+	KeyAlgRSAOAEP = "RSA-OAEP"
+	// KeyAlgRSAOAEP256 is the _RSAES OAEP_ key encryption using _SHA-256_.
+	KeyAlgRSAOAEP256 = "RSA-OAEP-256"
+	// KeyAlgECDHES is the _ECDH-ES_ direct key agreement using _Concat KDF_.
+	KeyAlgECDHES = "ECDH-ES"
+	// EncA256GCM is the _AES GCM_ content encryption using a 256 bit key.
+	EncA256GCM = "A256GCM"
+)
+
+// jweHeader is the protected header of a _JWE_.
+type jweHeader struct {
+	Alg string  `json:"alg"`
+	Enc string  `json:"enc"`
+	Cty string  `json:"cty,omitempty"`
+	Epk *jwkKey `json:"epk,omitempty"`
+}
+
+// jwkKey is a public elliptic curve _JWK_.
+type jwkKey struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+var b64 = base64.RawURLEncoding
+
+// EncryptJWE encrypts the _payload_ as a compact _JWE_ using A256GCM content encryption.
+// The _key_ is a `*rsa.PublicKey` for the _RSA-OAEP_ algorithms or a `*ecdsa.PublicKey`
+// for _ECDH-ES_. The _cty_ is the, optional, content type e.g. "JWT" for nested tokens.
+func EncryptJWE(payload []byte, key crypto.PublicKey, alg, cty string) (string, error) {
+
+	header := jweHeader{Alg: alg, Enc: EncA256GCM, Cty: cty}
+
+	var cek, encryptedKey []byte
+
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+
+		h, err := oaepHash(alg)
+
+		if err != nil {
+			return "", err
+		}
+
+		cek = make([]byte, 32)
+
+		if _, err := rand.Read(cek); err != nil {
+			return "", err
+		}
+
+		if encryptedKey, err = rsa.EncryptOAEP(h, rand.Reader, pub, cek, nil); err != nil {
+			return "", err
+		}
+
+	case *ecdsa.PublicKey:
+
+		if alg != KeyAlgECDHES {
+			return "", fmt.Errorf("algorithm %s cannot be used with an elliptic curve key", alg)
+		}
+
+		eph, err := ecdsa.GenerateKey(pub.Curve, rand.Reader)
+
+		if err != nil {
+			return "", err
+		}
+
+		header.Epk = newJWK(&eph.PublicKey)
+		cek = ecdhES(eph, pub)
+
+	default:
+		return "", fmt.Errorf("unsupported key type %T", key)
+	}
+
+	protected, err := json.Marshal(&header)
+
+	if err != nil {
+		return "", err
+	}
+
+	encodedHeader := b64.EncodeToString(protected)
+
+	gcm, err := newGCM(cek)
+
+	if err != nil {
+		return "", err
+	}
+
+	iv := make([]byte, gcm.NonceSize())
+
+	if _, err := rand.Read(iv); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nil, iv, payload, []byte(encodedHeader))
+	ciphertext, tag := sealed[:len(sealed)-gcm.Overhead()], sealed[len(sealed)-gcm.Overhead():]
+
+	return strings.Join([]string{
+		encodedHeader,
+		b64.EncodeToString(encryptedKey),
+		b64.EncodeToString(iv),
+		b64.EncodeToString(ciphertext),
+		b64.EncodeToString(tag),
+	}, "."), nil
+}
+
+// DecryptJWE decrypts a compact _JWE_ and returns the payload and the content type. The
+// _key_ is a `*rsa.PrivateKey` or a `*ecdsa.PrivateKey` and must match the algorithm
+// in the header.
+func DecryptJWE(token string, key crypto.PrivateKey) ([]byte, string, error) {
+
+	parts := strings.Split(token, ".")
+
+	if len(parts) != 5 {
+		return nil, "", fmt.Errorf("not a compact JWE, expected 5 parts got %d", len(parts))
+	}
+
+	var raw [5][]byte
+
+	for i, part := range parts {
+
+		var err error
+
+		if raw[i], err = b64.DecodeString(part); err != nil {
+			return nil, "", fmt.Errorf("JWE part %d: %w", i, err)
+		}
+
+	}
+
+	var header jweHeader
+
+	if err := json.Unmarshal(raw[0], &header); err != nil {
+		return nil, "", fmt.Errorf("JWE header: %w", err)
+	}
+
+	if header.Enc != EncA256GCM {
+		return nil, "", fmt.Errorf("unsupported content encryption %s", header.Enc)
+	}
+
+	var cek []byte
+
+	switch priv := key.(type) {
+	case *rsa.PrivateKey:
+
+		h, err := oaepHash(header.Alg)
+
+		if err != nil {
+			return nil, "", err
+		}
+
+		if cek, err = rsa.DecryptOAEP(h, rand.Reader, priv, raw[1], nil); err != nil {
+			return nil, "", err
+		}
+
+	case *ecdsa.PrivateKey:
+
+		if header.Alg != KeyAlgECDHES {
+			return nil, "", fmt.Errorf("algorithm %s cannot be used with an elliptic curve key", header.Alg)
+		}
+
+		if header.Epk == nil || len(raw[1]) != 0 {
+			return nil, "", fmt.Errorf("invalid ECDH-ES header")
+		}
+
+		epk, err := header.Epk.publicKey(priv.Curve)
+
+		if err != nil {
+			return nil, "", err
+		}
+
+		cek = ecdhES(priv, epk)
+
+	default:
+		return nil, "", fmt.Errorf("unsupported key type %T", key)
+	}
+
+	gcm, err := newGCM(cek)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(raw[2]) != gcm.NonceSize() || len(raw[4]) != gcm.Overhead() {
+		return nil, "", fmt.Errorf("invalid JWE iv or tag length")
+	}
+
+	payload, err := gcm.Open(nil, raw[2], append(raw[3], raw[4]...), []byte(parts[0]))
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	return payload, header.Cty, nil
+}
+
+// oaepHash returns the hash of the _RSA-OAEP_ algorithm _alg_.
+func oaepHash(alg string) (hash.Hash, error) {
+
+	switch alg {
+	case KeyAlgRSAOAEP:
+		return sha1.New(), nil
+	case KeyAlgRSAOAEP256:
+		return sha256.New(), nil
+	}
+
+	return nil, fmt.Errorf("algorithm %s cannot be used with a RSA key", alg)
+}
+
+func newGCM(cek []byte) (cipher.AEAD, error) {
+
+	block, err := aes.NewCipher(cek)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// ecdhES derives the A256GCM content encryption key from the shared secret of _priv_ and
+// _pub_ using the _Concat KDF_ as specified in _RFC 7518_ section 4.6.
+func ecdhES(priv *ecdsa.PrivateKey, pub *ecdsa.PublicKey) []byte {
+
+	x, _ := priv.Curve.ScalarMult(pub.X, pub.Y, priv.D.Bytes())
+
+	z := make([]byte, (priv.Curve.Params().BitSize+7)/8)
+	x.FillBytes(z)
+
+	// round 1 of SHA-256 yields the 256 bits needed
+	h := sha256.New()
+	binary.Write(h, binary.BigEndian, uint32(1))
+	h.Write(z)
+	binary.Write(h, binary.BigEndian, uint32(len(EncA256GCM)))
+	h.Write([]byte(EncA256GCM))
+	binary.Write(h, binary.BigEndian, uint32(0)) // PartyUInfo
+	binary.Write(h, binary.BigEndian, uint32(0)) // PartyVInfo
+	binary.Write(h, binary.BigEndian, uint32(256))
+
+	return h.Sum(nil)
+}
+
+// newJWK creates a public _JWK_ of _pub_.
+func newJWK(pub *ecdsa.PublicKey) *jwkKey {
+
+	size := (pub.Curve.Params().BitSize + 7) / 8
+	x, y := make([]byte, size), make([]byte, size)
+
+	pub.X.FillBytes(x)
+	pub.Y.FillBytes(y)
+
+	return &jwkKey{
+		Kty: "EC",
+		Crv: pub.Curve.Params().Name,
+		X:   b64.EncodeToString(x),
+		Y:   b64.EncodeToString(y),
+	}
+}
+
+// publicKey returns the key as a `*ecdsa.PublicKey` on the _curve_. The point is
+// verified to be on the curve.
+func (k *jwkKey) publicKey(curve elliptic.Curve) (*ecdsa.PublicKey, error) {
+
+	if k.Kty != "EC" || k.Crv != curve.Params().Name {
+		return nil, fmt.Errorf("epk must be a %s EC key", curve.Params().Name)
+	}
+
+	x, err := b64.DecodeString(k.X)
+
+	if err != nil {
+		return nil, err
+	}
+
+	y, err := b64.DecodeString(k.Y)
+
+	if err != nil {
+		return nil, err
+	}
+
+	pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+
+	if !curve.IsOnCurve(pub.X, pub.Y) {
+		return nil, fmt.Errorf("epk is not on curve %s", curve.Params().Name)
+	}
+
+	return pub, nil
+}
+
+// jwecreator implements the `license.JWTSignerCreator` interface.
+type jwecreator struct {
+	signer license.JWTSignerCreator
+	key    crypto.PublicKey
+	alg    string
+}
+
+// NewEncryptingSignCreator creates a `license.JWTSignerCreator` that signs the license
+// using _signer_ and encrypts the signed _JWT_ as a nested _JWE_ using the product
+// public _key_, i.e. `*rsa.PublicKey` or `*ecdsa.PublicKey`.
+//
+// If not specify _alg_, "RSA-OAEP" is used for _RSA_ keys and "ECDH-ES" for elliptic
+// curve keys. The content is always encrypted using "A256GCM".
+//
+// .Example Usage
+// [source,go]
+// ....
+// generator := licjwt.NewGeneratorBuilderWithSigner(
+// licbuiltin.NewEncryptingSignCreator(
+// licbuiltin.NewSignCreator(issuerKeys, "RS256"), productKeys.PublicKey(), "RSA-OAEP",
+// ),
+// )
+// ....
+func NewEncryptingSignCreator(
+	signer license.JWTSignerCreator, key crypto.PublicKey, alg string) license.JWTSignerCreator {
+
+	if signer == nil || key == nil {
+		panic("Both signer and key must be specified")
+	}
+
+	if alg == "" {
+
+		alg = KeyAlgRSAOAEP
+
+		if _, ok := key.(*ecdsa.PublicKey); ok {
+			alg = KeyAlgECDHES
+		}
+
+	}
+
+	return &jwecreator{
+		signer: signer,
+		key:    key,
+		alg:    alg,
+	}
+
+}
+
+// SignCreate will sign the _info_ and encrypt the signed _JWT_.
+func (jc *jwecreator) SignCreate(info *license.FeatureInfo) (string, error) {
+
+	signed, err := jc.signer.SignCreate(info)
+
+	if err != nil {
+		return "", err
+	}
+
+	return EncryptJWE([]byte(signed), jc.key, jc.alg, "JWT")
+}
+
+// jwevalidator implements the `license.Validator` interface.
+type jwevalidator struct {
+	key       crypto.PrivateKey
+	validator license.Validator
+}
+
+// NewDecryptingValidator creates a `license.Validator` that decrypts licenses created by
+// `NewEncryptingSignCreator` using the product embedded private _key_ and verifies the
+// inner signed _JWT_ using _validator_.
+//
+// Licenses that are not encrypted are rejected.
+func NewDecryptingValidator(key crypto.PrivateKey, validator license.Validator) license.Validator {
+
+	if key == nil || validator == nil {
+		panic("Both key and validator must be specified")
+	}
+
+	return &jwevalidator{
+		key:       key,
+		validator: validator,
+	}
+
+}
+
+// Validate decrypts the _license_ and validates the inner signed _JWT_.
+func (v *jwevalidator) Validate(lic string) (*license.FeatureInfo, error) {
+
+	payload, cty, err := DecryptJWE(lic, v.key)
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", license.ErrInvalidLicense, err)
+	}
+
+	if !strings.EqualFold(cty, "JWT") {
+		return nil, fmt.Errorf("%w: unexpected content type %q", license.ErrInvalidLicense, cty)
+	}
+
+	return v.validator.Validate(string(payload))
+}
@@ -1,13 +1,17 @@
 package licbuiltin
 
 import (
-	"github.com/dgrijalva/jwt-go"
+	"crypto"
+	"fmt"
+
 	"github.com/mariotoffia/gojwtlic/license"
+	"github.com/mariotoffia/gojwtlic/license/licjose"
 )
 
 // jwtcreator implements the `license.JWTSignerCreator` interface.
 type jwtcreator struct {
 	keys    license.RSAKeyPair
+	signer  crypto.Signer
 	signing string
 }
 
@@ -34,13 +38,46 @@ func NewSignCreator(keys license.RSAKeyPair, signing string) license.JWTSignerCr
 
 }
 
+// NewCryptoSignCreator is the same as `NewSignCreator` but signs using any _signer_, e.g.
+// a `*ecdsa.PrivateKey`, `ed25519.PrivateKey` or a _KMS_ backed `crypto.Signer`. The
+// _signing_ must match the key, e.g. "ES256" or "EdDSA".
+func NewCryptoSignCreator(signer crypto.Signer, signing string) license.JWTSignerCreator {
+
+	if signer == nil {
+		panic("No signer specified")
+	}
+
+	if !licjose.Algorithm(signing).Supported() {
+		panic(fmt.Sprintf("Unsupported signing %q", signing))
+	}
+
+	return &jwtcreator{
+		signer:  signer,
+		signing: signing,
+	}
+
+}
+
 // SignCreate will Create a _JWT_ from the _info_ parameter and sign it.
 // The returned string is a proper signed _JWT_.
 func (jc *jwtcreator) SignCreate(info *license.FeatureInfo) (string, error) {
 
-	token := jwt.NewWithClaims(jwt.GetSigningMethod(jc.signing), info)
+	signer := jc.signer
+
+	if signer == nil {
+
+		if jc.keys.PrivateKey() == nil {
+			return "", fmt.Errorf("no private key to sign with")
+		}
+
+		signer = jc.keys.PrivateKey()
+	}
+
+	if !licjose.Algorithm(jc.signing).Supported() {
+		return "", fmt.Errorf("unsupported signing %s", jc.signing)
+	}
 
-	ss, err := token.SignedString(jc.keys.PrivateKey())
+	ss, err := licjose.NewSigner(licjose.Algorithm(jc.signing), signer).Sign(info)
 
 	if err != nil {
 
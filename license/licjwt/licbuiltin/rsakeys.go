@@ -7,8 +7,8 @@ import (
 	"io/ioutil"
 	"log"
 
-	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/mariotoffia/gojwtlic/license"
+	"github.com/mariotoffia/gojwtlic/license/licjose"
 )
 
 func fatal(err error) {
@@ -146,21 +146,31 @@ func keyFromBuffer(pubKey, privKey []byte) *KeysImpl {
 	var signKey *rsa.PrivateKey
 	var verifyKey *rsa.PublicKey
 
-	var err error
-
 	if len(privKey) > 0 {
 
-		signKey, err = jwt.ParseRSAPrivateKeyFromPEM(privKey)
+		key, err := licjose.ParsePrivateKeyPEM(privKey)
 		fatal(err)
 
+		var ok bool
+
+		if signKey, ok = key.(*rsa.PrivateKey); !ok {
+			fatal(fmt.Errorf("not a RSA private key"))
+		}
+
 		verifyKey = &signKey.PublicKey
 	}
 
-	if len(pubKey) > 0 && verifyKey != nil {
+	if len(pubKey) > 0 && verifyKey == nil {
 
-		verifyKey, err = jwt.ParseRSAPublicKeyFromPEM(pubKey)
+		key, err := licjose.ParsePublicKeyPEM(pubKey)
 		fatal(err)
 
+		var ok bool
+
+		if verifyKey, ok = key.(*rsa.PublicKey); !ok {
+			fatal(fmt.Errorf("not a RSA public key"))
+		}
+
 	}
 
 	if verifyKey == nil {
@@ -0,0 +1,99 @@
+package licbuiltin
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+
+	"github.com/mariotoffia/gojwtlic/license"
+	"github.com/mariotoffia/gojwtlic/license/licjose"
+)
+
+// validator implements the `license.Validator` interface.
+type validator struct {
+	keys   []crypto.PublicKey
+	parser *licjose.Parser
+}
+
+// NewValidator creates a new `license.Validator` that verifies _RSA_ signed licenses using
+// the key ring _keys_. A license is valid if it is signed by any of the _keys_, e.g.
+// both the current and the previous key when rotating keys.
+func NewValidator(keys ...license.RSAKeyPair) license.Validator {
+
+	if len(keys) == 0 {
+		panic("No keys specified")
+	}
+
+	pubs := make([]crypto.PublicKey, 0, len(keys))
+
+	for _, key := range keys {
+		pubs = append(pubs, key.PublicKey())
+	}
+
+	return &validator{
+		keys:   pubs,
+		parser: licjose.NewParser(licjose.RSAAlgorithms...),
+	}
+
+}
+
+// NewPublicKeyValidator is the same as `NewValidator` but accepts any _RSA_, _ECDSA_
+// or _Ed25519_ public keys. Only the algorithms in _allowed_ are accepted, if omitted
+// all supported algorithms are allowed. The algorithm must still match the key type.
+func NewPublicKeyValidator(allowed []string, keys ...crypto.PublicKey) license.Validator {
+
+	if len(keys) == 0 {
+		panic("No keys specified")
+	}
+
+	algs := licjose.Algorithms
+
+	if len(allowed) > 0 {
+
+		algs = make([]licjose.Algorithm, 0, len(allowed))
+
+		for _, alg := range allowed {
+			algs = append(algs, licjose.Algorithm(alg))
+		}
+
+	}
+
+	return &validator{
+		keys:   keys,
+		parser: licjose.NewParser(algs...),
+	}
+
+}
+
+// Validate verifies the signature and the time constraints of the _license_ and
+// returns the license information.
+func (v *validator) Validate(lic string) (*license.FeatureInfo, error) {
+
+	var lasterr error
+
+	for _, key := range v.keys {
+
+		info := &license.FeatureInfo{}
+
+		_, err := v.parser.Parse(lic, key, info)
+
+		if err == nil {
+
+			if err := info.Valid(); err != nil {
+				return nil, fmt.Errorf("%w: %s", license.ErrInvalidLicense, err)
+			}
+
+			return info, nil
+		}
+
+		lasterr = err
+
+		if !errors.Is(err, licjose.ErrSignatureInvalid) && !errors.Is(err, licjose.ErrKeyMismatch) {
+			break // only a signature or key mismatch is worth trying the next key with
+		}
+
+	}
+
+	return nil, fmt.Errorf("%w: %s", license.ErrInvalidLicense, lasterr)
+
+}
@@ -0,0 +1,681 @@
+package licpol
+
+import (
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/util"
+)
+
+var (
+	astValueType      = reflect.TypeOf((*ast.Value)(nil)).Elem()
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// startDetectingCyclesAfter is the pointer depth, same as in `encoding/json`, after
+// which each pointer, map and slice is checked for cycles.
+const startDetectingCyclesAfter = 1000
+
+// structFieldPlan is how to convert a single struct field into a object
+// key/value pair.
+type structFieldPlan struct {
+	index     []int
+	key       *ast.Term
+	omitEmpty bool
+	quoted    bool
+}
+
+// structPlan is the cached conversion plan for a struct type.
+type structPlan struct {
+	fields []structFieldPlan
+}
+
+// structPlans is the per type cache of `*structPlan`.
+var structPlans sync.Map
+
+// valueState is the state of a single `ToValue` conversion.
+type valueState struct {
+	ptrLevel uint
+	ptrSeen  map[ptrKey]struct{}
+}
+
+// ptrKey identifies a pointer, map or slice when detecting cycles.
+type ptrKey struct {
+	ptr uintptr
+	len int
+}
+
+// ToValue converts _x_ into a `ast.Value` without doing a _JSON_ round trip.
+//
+// It follows the `encoding/json` rules, i.e. `json` tags including the _omitempty_ and
+// _string_ options are respected, unexported fields are skipped, embedded structs are
+// flattened using the same dominance rules and types that implements
+// `encoding.TextMarshaler` are rendered as strings. The field layout of a struct type is
+// only resolved once and then cached. Types that implements `json.Marshaler` are
+// marshalled and then converted and hence does a round trip.
+//
+// Cyclic values are detected and returned as an error.
+func ToValue(x interface{}) (ast.Value, error) {
+
+	if v, ok := x.(ast.Value); ok {
+		return v, nil
+	}
+
+	return (&valueState{}).valueOf(reflect.ValueOf(x))
+}
+
+// MustToValue is same as `ToValue` but panics on error.
+func MustToValue(x interface{}) ast.Value {
+
+	v, err := ToValue(x)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return v
+}
+
+// jsonValueOf does a _JSON_ round trip of _m_.
+func jsonValueOf(m json.Marshaler) (ast.Value, error) {
+
+	data, err := m.MarshalJSON()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var x interface{}
+
+	if err := util.UnmarshalJSON(data, &x); err != nil {
+		return nil, err
+	}
+
+	return ast.InterfaceToValue(x)
+}
+
+// marshalerOf returns the `json.Marshaler`, or `encoding.TextMarshaler`, of _v_ in the
+// same manner as `encoding/json`, i.e. pointer receivers are used when addressable.
+func marshalerOf(v reflect.Value, marshaler reflect.Type) (interface{}, bool) {
+
+	t := v.Type()
+
+	if t.Kind() != reflect.Ptr && v.CanAddr() && reflect.PtrTo(t).Implements(marshaler) {
+		v = v.Addr()
+	} else if !t.Implements(marshaler) {
+		return nil, false
+	}
+
+	if !v.CanInterface() {
+		return nil, false
+	}
+
+	return v.Interface(), true
+}
+
+func (s *valueState) valueOf(v reflect.Value) (ast.Value, error) {
+
+	if !v.IsValid() {
+		return ast.Null{}, nil
+	}
+
+	t := v.Type()
+
+	if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+		return ast.Null{}, nil
+	}
+
+	if t.Implements(astValueType) && v.CanInterface() {
+		return v.Interface().(ast.Value), nil
+	}
+
+	if m, ok := marshalerOf(v, jsonMarshalerType); ok {
+		return jsonValueOf(m.(json.Marshaler))
+	}
+
+	if m, ok := marshalerOf(v, textMarshalerType); ok {
+
+		b, err := m.(encoding.TextMarshaler).MarshalText()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return ast.String(b), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		return ast.Boolean(v.Bool()), nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return ast.Number(strconv.FormatInt(v.Int(), 10)), nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return ast.Number(strconv.FormatUint(v.Uint(), 10)), nil
+
+	case reflect.Float32, reflect.Float64:
+
+		f := v.Float()
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, fmt.Errorf("unsupported float value %v", f)
+		}
+
+		return ast.Number(strconv.FormatFloat(f, 'g', -1, t.Bits())), nil
+
+	case reflect.String:
+		return ast.String(v.String()), nil
+
+	case reflect.Interface:
+		return s.valueOf(v.Elem())
+
+	case reflect.Ptr:
+
+		leave, err := s.enter(v, ptrKey{ptr: v.Pointer()})
+
+		if err != nil {
+			return nil, err
+		}
+
+		defer leave()
+
+		return s.valueOf(v.Elem())
+
+	case reflect.Slice:
+
+		if v.IsNil() {
+			return ast.Null{}, nil
+		}
+
+		if t.Elem().Kind() == reflect.Uint8 {
+			return ast.String(base64.StdEncoding.EncodeToString(v.Bytes())), nil
+		}
+
+		leave, err := s.enter(v, ptrKey{ptr: v.Pointer(), len: v.Len()})
+
+		if err != nil {
+			return nil, err
+		}
+
+		defer leave()
+
+		return s.arrayOf(v)
+
+	case reflect.Array:
+		return s.arrayOf(v)
+
+	case reflect.Map:
+
+		if v.IsNil() {
+			return ast.Null{}, nil
+		}
+
+		leave, err := s.enter(v, ptrKey{ptr: v.Pointer()})
+
+		if err != nil {
+			return nil, err
+		}
+
+		defer leave()
+
+		return s.objectOf(v)
+
+	case reflect.Struct:
+		return s.structOf(v)
+	}
+
+	return nil, fmt.Errorf("unsupported type %s", t)
+}
+
+// enter increases the pointer depth and, when deep enough, checks that _key_ is not
+// already being converted. The returned function must be invoked when done.
+func (s *valueState) enter(v reflect.Value, key ptrKey) (func(), error) {
+
+	s.ptrLevel++
+
+	if s.ptrLevel <= startDetectingCyclesAfter {
+		return func() { s.ptrLevel-- }, nil
+	}
+
+	if s.ptrSeen == nil {
+		s.ptrSeen = map[ptrKey]struct{}{}
+	}
+
+	if _, ok := s.ptrSeen[key]; ok {
+		s.ptrLevel--
+		return nil, fmt.Errorf("encountered a cycle via %s", v.Type())
+	}
+
+	s.ptrSeen[key] = struct{}{}
+
+	return func() {
+		delete(s.ptrSeen, key)
+		s.ptrLevel--
+	}, nil
+}
+
+func (s *valueState) arrayOf(v reflect.Value) (ast.Value, error) {
+
+	terms := make([]*ast.Term, v.Len())
+
+	for i := range terms {
+
+		ev, err := s.valueOf(v.Index(i))
+		if err != nil {
+			return nil, err
+		}
+
+		terms[i] = ast.NewTerm(ev)
+	}
+
+	return ast.NewArray(terms...), nil
+}
+
+func (s *valueState) objectOf(v reflect.Value) (ast.Value, error) {
+
+	obj := ast.NewObject()
+	iter := v.MapRange()
+
+	for iter.Next() {
+
+		key, err := mapKeyOf(iter.Key())
+		if err != nil {
+			return nil, err
+		}
+
+		ev, err := s.valueOf(iter.Value())
+		if err != nil {
+			return nil, err
+		}
+
+		obj.Insert(ast.StringTerm(key), ast.NewTerm(ev))
+	}
+
+	return obj, nil
+}
+
+// mapKeyOf resolves the object key in the same manner as `encoding/json`.
+func mapKeyOf(k reflect.Value) (string, error) {
+
+	if k.Kind() == reflect.String {
+		return k.String(), nil
+	}
+
+	if k.Type().Implements(textMarshalerType) {
+
+		if k.Kind() == reflect.Ptr && k.IsNil() {
+			return "", nil
+		}
+
+		b, err := k.Interface().(encoding.TextMarshaler).MarshalText()
+		return string(b), err
+
+	}
+
+	switch k.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(k.Uint(), 10), nil
+	}
+
+	return "", fmt.Errorf("unsupported map key type %s", k.Type())
+}
+
+func (s *valueState) structOf(v reflect.Value) (ast.Value, error) {
+
+	plan := planOf(v.Type())
+	obj := ast.NewObject()
+
+	for i := range plan.fields {
+
+		fp := &plan.fields[i]
+
+		fv, ok := fieldByIndex(v, fp.index)
+		if !ok {
+			continue
+		}
+
+		if fp.omitEmpty && isEmptyValue(fv) {
+			continue
+		}
+
+		var ev ast.Value
+		var err error
+
+		if fp.quoted {
+			ev, err = quotedValueOf(fv)
+		} else {
+			ev, err = s.valueOf(fv)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		obj.Insert(fp.key, ast.NewTerm(ev))
+	}
+
+	return obj, nil
+}
+
+// quotedValueOf renders a scalar field, tagged with the _string_ option, as a string
+// containing the _JSON_ value.
+func quotedValueOf(v reflect.Value) (ast.Value, error) {
+
+	if v.Kind() == reflect.Ptr {
+
+		if v.IsNil() {
+			return ast.Null{}, nil
+		}
+
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+
+		b, err := json.Marshal(v.String())
+
+		if err != nil {
+			return nil, err
+		}
+
+		return ast.String(b), nil
+
+	case reflect.Float32, reflect.Float64:
+
+		f := v.Float()
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, fmt.Errorf("unsupported float value %v", f)
+		}
+
+		return ast.String(strconv.FormatFloat(f, 'g', -1, v.Type().Bits())), nil
+	}
+
+	value, err := (&valueState{}).valueOf(v)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ast.String(value.String()), nil
+}
+
+// fieldByIndex is same as `reflect.Value.FieldByIndex` but returns `false` instead of
+// panic when traversing a nil embedded pointer.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+
+	for i, x := range index {
+
+		if i > 0 && v.Kind() == reflect.Ptr {
+
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+
+			v = v.Elem()
+		}
+
+		v = v.Field(x)
+	}
+
+	return v, true
+}
+
+// planOf gets the cached plan for _t_ or creates a new one.
+func planOf(t reflect.Type) *structPlan {
+
+	if plan, ok := structPlans.Load(t); ok {
+		return plan.(*structPlan)
+	}
+
+	actual, _ := structPlans.LoadOrStore(t, buildPlan(t))
+	return actual.(*structPlan)
+}
+
+// planField is a candidate field when building a `structPlan`.
+type planField struct {
+	name      string
+	tagged    bool
+	index     []int
+	typ       reflect.Type
+	omitEmpty bool
+	quoted    bool
+}
+
+// buildPlan resolves the fields of _t_ in the same manner as `encoding/json`. Embedded
+// structs are flattened breadth first where each struct type is only visited once. When
+// names collide the shallowest field wins, then the tagged one, otherwise all are
+// dropped.
+func buildPlan(t reflect.Type) *structPlan {
+
+	var current []planField
+	next := []planField{{typ: t}}
+
+	var count, nextCount map[reflect.Type]int
+	visited := map[reflect.Type]bool{}
+
+	var fields []planField
+
+	for len(next) > 0 {
+
+		current, next = next, current[:0]
+		count, nextCount = nextCount, map[reflect.Type]int{}
+
+		for _, f := range current {
+
+			if visited[f.typ] {
+				continue
+			}
+
+			visited[f.typ] = true
+
+			for i := 0; i < f.typ.NumField(); i++ {
+
+				sf := f.typ.Field(i)
+
+				if sf.Anonymous {
+
+					ft := sf.Type
+					if ft.Kind() == reflect.Ptr {
+						ft = ft.Elem()
+					}
+
+					if !sf.IsExported() && ft.Kind() != reflect.Struct {
+						continue
+					}
+
+				} else if !sf.IsExported() {
+					continue
+				}
+
+				tag := sf.Tag.Get("json")
+
+				if tag == "-" {
+					continue
+				}
+
+				name, opts := parseTag(tag)
+				index := append(append([]int{}, f.index...), i)
+
+				ft := sf.Type
+				if ft.Name() == "" && ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+
+				if name != "" || !sf.Anonymous || ft.Kind() != reflect.Struct {
+
+					field := planField{
+						name:      name,
+						tagged:    name != "",
+						index:     index,
+						typ:       ft,
+						omitEmpty: hasTagOption(opts, "omitempty"),
+						quoted:    hasTagOption(opts, "string") && isQuotable(ft),
+					}
+
+					if field.name == "" {
+						field.name = sf.Name
+					}
+
+					fields = append(fields, field)
+
+					if count[f.typ] > 1 {
+						// embedded more than once at this depth, annihilates itself
+						fields = append(fields, field)
+					}
+
+					continue
+				}
+
+				nextCount[ft]++
+
+				if nextCount[ft] == 1 {
+					next = append(next, planField{name: ft.Name(), index: index, typ: ft})
+				}
+
+			}
+
+		}
+
+	}
+
+	sort.Slice(fields, func(i, j int) bool {
+
+		x := fields
+
+		if x[i].name != x[j].name {
+			return x[i].name < x[j].name
+		}
+
+		if len(x[i].index) != len(x[j].index) {
+			return len(x[i].index) < len(x[j].index)
+		}
+
+		if x[i].tagged != x[j].tagged {
+			return x[i].tagged
+		}
+
+		return indexLess(x[i].index, x[j].index)
+	})
+
+	plan := &structPlan{}
+
+	for i := 0; i < len(fields); {
+
+		j := i + 1
+		for j < len(fields) && fields[j].name == fields[i].name {
+			j++
+		}
+
+		group := fields[i:j]
+		i = j
+
+		if len(group) > 1 &&
+			len(group[0].index) == len(group[1].index) &&
+			group[0].tagged == group[1].tagged {
+			continue // no dominant field
+		}
+
+		plan.fields = append(plan.fields, structFieldPlan{
+			index:     group[0].index,
+			key:       ast.StringTerm(group[0].name),
+			omitEmpty: group[0].omitEmpty,
+			quoted:    group[0].quoted,
+		})
+	}
+
+	sort.Slice(plan.fields, func(i, j int) bool {
+		return indexLess(plan.fields[i].index, plan.fields[j].index)
+	})
+
+	return plan
+}
+
+// indexLess orders field index sequences.
+func indexLess(a, b []int) bool {
+
+	for k, x := range a {
+
+		if k >= len(b) {
+			return false
+		}
+
+		if x != b[k] {
+			return x < b[k]
+		}
+
+	}
+
+	return len(a) < len(b)
+}
+
+// isQuotable is `true` if the _string_ tag option applies to _t_.
+func isQuotable(t reflect.Type) bool {
+
+	switch t.Kind() {
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64,
+		reflect.String:
+		return true
+	}
+
+	return false
+}
+
+func parseTag(tag string) (string, string) {
+
+	if idx := strings.Index(tag, ","); idx != -1 {
+		return tag[:idx], tag[idx+1:]
+	}
+
+	return tag, ""
+}
+
+// hasTagOption is `true` if _option_ is one of the comma separated _opts_.
+func hasTagOption(opts, option string) bool {
+
+	for _, o := range strings.Split(opts, ",") {
+
+		if o == option {
+			return true
+		}
+
+	}
+
+	return false
+}
+
+// isEmptyValue is the same definition of empty as in `encoding/json`.
+func isEmptyValue(v reflect.Value) bool {
+
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+
+	return false
+}
@@ -0,0 +1,270 @@
+package licpol
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mariotoffia/gojwtlic/license"
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/util"
+	"github.com/stretchr/testify/assert"
+)
+
+const agePolicy = `
+package licpol.test
+
+default allow = false
+
+allow {
+	input.method == ["path", "to", "MyFunc2"]
+	input.body.ms.Age >= 18
+}`
+
+func TestToValueSkipsUnexported(t *testing.T) {
+
+	v, err := ToValue(MyStruct{Name: "Nisse", Age: 17, secret: "shh"})
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, ast.MustParseTerm(`{"Name": "Nisse", "Age": 17}`).Value.Compare(v))
+}
+
+func TestToValueSameAsJSONRoundTrip(t *testing.T) {
+
+	fi := &license.FeatureInfo{
+		BaseInfo: license.BaseInfo{
+			Audience:  "https://api.valmatics.se",
+			Subject:   "hobbe.nisse@azcam.net",
+			Expires:   1927735782,
+			LicenseID: "fcd2174b-664a-11eb-afe1-1629c910062f",
+		},
+		OauthInfo: license.OauthInfo{
+			ClientID: "valmatics2.x",
+		},
+		Features: "simulator settings",
+		FeatureMap: map[string]license.Feature{
+			"settings": &license.FeatureImpl{
+				Claims: map[string]interface{}{
+					"access": "rw",
+					"ao":     true,
+					"ratio":  0.5,
+				},
+			},
+		},
+	}
+
+	data, err := json.Marshal(fi)
+	assert.Equal(t, nil, err)
+
+	var doc interface{}
+	assert.Equal(t, nil, util.UnmarshalJSON(data, &doc))
+
+	expected, err := ast.InterfaceToValue(doc)
+	assert.Equal(t, nil, err)
+
+	v, err := ToValue(fi)
+	assert.Equal(t, nil, err)
+
+	assert.Equal(t, 0, expected.Compare(v), "expected: %v got: %v", expected, v)
+}
+
+func TestToValueJSONMarshaler(t *testing.T) {
+
+	now := time.Date(2021, 2, 3, 4, 5, 6, 0, time.UTC)
+
+	v, err := ToValue(map[string]interface{}{"when": now, "raw": json.RawMessage(`[1,2]`)})
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, ast.MustParseTerm(`{"when": "2021-02-03T04:05:06Z", "raw": [1, 2]}`).Value.Compare(v))
+}
+
+// jsonRoundTripValue is the value `encoding/json` produces for _x_.
+func jsonRoundTripValue(t *testing.T, x interface{}) ast.Value {
+
+	data, err := json.Marshal(x)
+	assert.Equal(t, nil, err)
+
+	var doc interface{}
+	assert.Equal(t, nil, util.UnmarshalJSON(data, &doc))
+
+	v, err := ast.InterfaceToValue(doc)
+	assert.Equal(t, nil, err)
+
+	return v
+}
+
+type shadowInner struct {
+	Name  string
+	Email string `json:"email"`
+	Age   int
+}
+
+type shadowOther struct {
+	Age int
+}
+
+type shadowOuter struct {
+	shadowInner
+	shadowOther
+	Name  string
+	Mail  string  `json:"email"`
+	Count int     `json:"count,string"`
+	Ratio float64 `json:",string"`
+	Quote string  `json:"quote,string"`
+	Addr  net.IP
+}
+
+type selfRef struct {
+	*selfRef
+	Name string
+	Next *selfRef `json:"next,omitempty"`
+}
+
+func TestToValueFollowsEncodingJSON(t *testing.T) {
+
+	for _, x := range []interface{}{
+		net.ParseIP("10.0.0.1"),
+		map[string]interface{}{"ip": net.ParseIP("::1")},
+		shadowOuter{
+			shadowInner: shadowInner{Name: "inner", Email: "inner@hult.se", Age: 17},
+			shadowOther: shadowOther{Age: 18},
+			Name:        "outer",
+			Mail:        "outer@hult.se",
+			Count:       42,
+			Ratio:       0.5,
+			Quote:       "nisse",
+			Addr:        net.ParseIP("10.0.0.1"),
+		},
+		&selfRef{Name: "a", selfRef: &selfRef{Name: "b"}, Next: &selfRef{Name: "c"}},
+	} {
+
+		v, err := ToValue(x)
+		assert.Equal(t, nil, err)
+
+		expected := jsonRoundTripValue(t, x)
+		assert.Equal(t, 0, expected.Compare(v), "expected: %v got: %v", expected, v)
+	}
+
+	v, err := ToValue(net.ParseIP("10.0.0.1"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, ast.String("10.0.0.1"), v)
+}
+
+func TestToValueCycle(t *testing.T) {
+
+	cyclic := &selfRef{Name: "a"}
+	cyclic.Next = cyclic
+
+	_, err := ToValue(cyclic)
+	assert.NotEqual(t, nil, err)
+
+	m := map[string]interface{}{}
+	m["self"] = m
+
+	_, err = ToValue(m)
+	assert.NotEqual(t, nil, err)
+}
+
+func TestPEPDecision(t *testing.T) {
+
+	pctx := New().
+		RegisterModule("licpol.test", agePolicy).
+		CompileModuleSet("age", "licpol.test")
+
+	pep := NewPolicyEnforcementPoint(
+		map[string]PEPRegistration{
+			"path/to/MyFunc2": {
+				Function:   MyFunc2,
+				Parameters: []string{"ms"},
+				Returns:    []string{"output"},
+			},
+		}).UsePolicyContext("data.licpol.test.allow", pctx, "age", nil)
+
+	invoke := pep.CheckInvoke("path/to/MyFunc2", MyStruct{Name: "Nisse", Age: 18})
+	assert.Equal(t, true, invoke.Allowed())
+	assert.Equal(t, nil, invoke.Error())
+
+	invoke = pep.CheckInvoke("path/to/MyFunc2", MyStruct{Name: "Nisse", Age: 17})
+	assert.Equal(t, false, invoke.Allowed())
+	assert.True(t, errors.Is(invoke.Error(), ErrDenied))
+
+	f := func(ms MyStruct) (string, error) { return ms.Name, nil }
+
+	pep = NewPolicyEnforcementPoint(
+		map[string]PEPRegistration{
+			"path/to/MyFunc2": {
+				Function:   f,
+				Parameters: []string{"ms"},
+				Returns:    []string{"output", "err"},
+			},
+		}).UsePolicyContext("data.licpol.test.allow", pctx, "age", nil)
+
+	_, err := Wrap1E[MyStruct, string](pep, "path/to/MyFunc2")(MyStruct{Name: "Nisse", Age: 17})
+	assert.True(t, errors.Is(err, ErrDenied))
+}
+
+func BenchmarkToValue(t *testing.B) {
+
+	prm := MyStruct{Name: "Nisse", Age: 17, secret: "shh"}
+
+	t.ReportAllocs()
+	t.ResetTimer()
+
+	for i := 0; i < t.N; i++ {
+
+		if _, err := ToValue(prm); err != nil {
+			panic(err)
+		}
+
+	}
+}
+
+func BenchmarkJSONRoundTripValue(t *testing.B) {
+
+	prm := MyStruct{Name: "Nisse", Age: 17, secret: "shh"}
+
+	t.ReportAllocs()
+	t.ResetTimer()
+
+	for i := 0; i < t.N; i++ {
+
+		if _, err := ast.InterfaceToValue(prm); err != nil {
+			panic(err)
+		}
+
+	}
+}
+
+func BenchmarkPEPDecision(t *testing.B) {
+
+	pctx := New().
+		RegisterModule("licpol.test", agePolicy).
+		CompileModuleSet("age", "licpol.test")
+
+	f := func(ms MyStruct) (string, error) { return "", nil }
+
+	pep := NewPolicyEnforcementPoint(
+		map[string]PEPRegistration{
+			"path/to/MyFunc2": {
+				Function:   f,
+				Parameters: []string{"ms"},
+				Returns:    []string{"output", "err"},
+			},
+		}).UsePolicyContext("data.licpol.test.allow", pctx, "age", nil)
+
+	wrapped := Wrap1E[MyStruct, string](pep, "path/to/MyFunc2")
+	prm := MyStruct{Name: "Nisse", Age: 18}
+
+	t.ReportAllocs()
+	t.ResetTimer()
+
+	for i := 0; i < t.N; i++ {
+
+		if _, err := wrapped(prm); err != nil {
+			panic(err)
+		}
+
+	}
+}
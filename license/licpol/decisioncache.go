@@ -0,0 +1,276 @@
+package licpol
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/storage"
+)
+
+// DecisionCacheStats is a snapshot of the `DecisionCache` counters.
+type DecisionCacheStats struct {
+	// Hits is the number of decisions served from the cache.
+	Hits uint64 `json:"hits"`
+	// Misses is the number of lookups that had to be evaluated by the _PDP_.
+	Misses uint64 `json:"misses"`
+	// Evictions is the number of entries removed due to size or _TTL_.
+	Evictions uint64 `json:"evictions"`
+	// Invalidations is the number of times the whole cache has been invalidated.
+	Invalidations uint64 `json:"invalidations"`
+	// Size is the current number of entries in the cache.
+	Size int `json:"size"`
+}
+
+// decisionKey is the key for a single cached decision. It covers the complete _PDP_
+// input document since the input type is always "invoke".
+type decisionKey struct {
+	method string
+	sc     [sha256.Size]byte
+	body   [sha256.Size]byte
+}
+
+type decisionEntry struct {
+	key      decisionKey
+	decision Decision
+	expires  time.Time
+}
+
+// DecisionCache is a bounded _LRU_ cache of _PDP_ decisions keyed on method path and
+// a canonical hash of the security context and the body, i.e. the complete _PDP_ input.
+//
+// The cache is safe for concurrent use. Use `PEP.UseDecisionCache` to enable it on a
+// _PEP_.
+type DecisionCache struct {
+	mu            sync.Mutex
+	size          int
+	ttl           time.Duration
+	generation    uint64
+	entries       map[decisionKey]*list.Element
+	lru           *list.List
+	hits          uint64
+	misses        uint64
+	evictions     uint64
+	invalidations uint64
+}
+
+// NewDecisionCache creates a new `DecisionCache` that holds at most _size_ decisions.
+//
+// If _ttl_ is greater than zero, a decision is only valid for _ttl_ after it has been
+// cached.
+func NewDecisionCache(size int, ttl time.Duration) *DecisionCache {
+
+	if size <= 0 {
+		panic("decision cache size must be greater than zero")
+	}
+
+	return &DecisionCache{
+		size:    size,
+		ttl:     ttl,
+		entries: map[decisionKey]*list.Element{},
+		lru:     list.New(),
+	}
+
+}
+
+// Stats returns a snapshot of the cache counters.
+func (dc *DecisionCache) Stats() DecisionCacheStats {
+
+	dc.mu.Lock()
+	size := dc.lru.Len()
+	dc.mu.Unlock()
+
+	return DecisionCacheStats{
+		Hits:          atomic.LoadUint64(&dc.hits),
+		Misses:        atomic.LoadUint64(&dc.misses),
+		Evictions:     atomic.LoadUint64(&dc.evictions),
+		Invalidations: atomic.LoadUint64(&dc.invalidations),
+		Size:          size,
+	}
+}
+
+// Invalidate removes all cached decisions.
+//
+// Decisions that are evaluated while invalidating will not be cached.
+func (dc *DecisionCache) Invalidate() {
+
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	dc.generation++
+	dc.entries = map[decisionKey]*list.Element{}
+	dc.lru.Init()
+
+	atomic.AddUint64(&dc.invalidations, 1)
+}
+
+// OnPRPChange is a `PolicyRetrievalPointChangeFunc` that invalidates the cache.
+func (dc *DecisionCache) OnPRPChange(p PolicyRetrievalPoint, module string, change PolicyRetrievalPointChange) {
+	dc.Invalidate()
+}
+
+// OnPolicyContextChange is a `PolicyContextChangeFunc` that invalidates the cache.
+func (dc *DecisionCache) OnPolicyContextChange(pc PolicyContext, compiled string) {
+	dc.Invalidate()
+}
+
+// OnStoreChange is a `StoreChangeFunc` that invalidates the cache.
+func (dc *DecisionCache) OnStoreChange(sm *StoreManager, paths []storage.Path) {
+	dc.Invalidate()
+}
+
+// get looks up a decision. The returned generation is to be passed to `put` in
+// order not to cache a decision that was evaluated during a invalidation.
+func (dc *DecisionCache) get(key decisionKey) (decision Decision, ok bool, generation uint64) {
+
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	generation = dc.generation
+
+	if elem, found := dc.entries[key]; found {
+
+		entry := elem.Value.(*decisionEntry)
+
+		if dc.ttl <= 0 || time.Now().Before(entry.expires) {
+
+			dc.lru.MoveToFront(elem)
+			atomic.AddUint64(&dc.hits, 1)
+
+			return entry.decision, true, generation
+
+		}
+
+		dc.remove(elem)
+	}
+
+	atomic.AddUint64(&dc.misses, 1)
+	return Decision{}, false, generation
+}
+
+func (dc *DecisionCache) put(key decisionKey, decision Decision, generation uint64) {
+
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	if generation != dc.generation {
+		return
+	}
+
+	entry := &decisionEntry{key: key, decision: decision}
+
+	if dc.ttl > 0 {
+		entry.expires = time.Now().Add(dc.ttl)
+	}
+
+	if elem, found := dc.entries[key]; found {
+
+		elem.Value = entry
+		dc.lru.MoveToFront(elem)
+		return
+
+	}
+
+	dc.entries[key] = dc.lru.PushFront(entry)
+
+	for dc.lru.Len() > dc.size {
+		dc.remove(dc.lru.Back())
+	}
+}
+
+func (dc *DecisionCache) remove(elem *list.Element) {
+
+	dc.lru.Remove(elem)
+	delete(dc.entries, elem.Value.(*decisionEntry).key)
+
+	atomic.AddUint64(&dc.evictions, 1)
+}
+
+// canonicalHash creates a hash of _v_ that is independent of the order of object
+// keys and set members.
+func canonicalHash(v ast.Value) [sha256.Size]byte {
+
+	h := sha256.New()
+	hashValue(h, v)
+
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+
+	return sum
+}
+
+func hashValue(h hash.Hash, v ast.Value) {
+
+	var buf [8]byte
+
+	writeString := func(tag byte, s string) {
+		binary.BigEndian.PutUint64(buf[:], uint64(len(s)))
+		h.Write([]byte{tag})
+		h.Write(buf[:])
+		h.Write([]byte(s))
+	}
+
+	switch x := v.(type) {
+	case ast.Null:
+		h.Write([]byte{'n'})
+	case ast.Boolean:
+		if x {
+			h.Write([]byte{'t'})
+		} else {
+			h.Write([]byte{'f'})
+		}
+	case ast.Number:
+		writeString('#', string(x))
+	case ast.String:
+		writeString('s', string(x))
+	case *ast.Array:
+		binary.BigEndian.PutUint64(buf[:], uint64(x.Len()))
+		h.Write([]byte{'['})
+		h.Write(buf[:])
+
+		for i := 0; i < x.Len(); i++ {
+			hashValue(h, x.Elem(i).Value)
+		}
+	case ast.Set:
+		hashSorted(h, '<', x.Slice(), nil)
+	case ast.Object:
+		keys := x.Keys()
+		hashSorted(h, '{', keys, x)
+	default:
+		writeString('?', v.String())
+	}
+}
+
+// hashSorted hashes _terms_ in sorted order. If _obj_ is non nil, the _terms_ are
+// keys and the values are hashed after each key.
+func hashSorted(h hash.Hash, tag byte, terms []*ast.Term, obj ast.Object) {
+
+	sorted := make([]*ast.Term, len(terms))
+	copy(sorted, terms)
+
+	sort.Slice(sorted, func(i, j int) bool {
+		return ast.Compare(sorted[i].Value, sorted[j].Value) < 0
+	})
+
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(len(sorted)))
+
+	h.Write([]byte{tag})
+	h.Write(buf[:])
+
+	for _, t := range sorted {
+
+		hashValue(h, t.Value)
+
+		if obj != nil {
+			hashValue(h, obj.Get(t).Value)
+		}
+
+	}
+}
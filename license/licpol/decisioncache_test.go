@@ -0,0 +1,132 @@
+package licpol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecisionCacheEvictsLeastRecentlyUsed(t *testing.T) {
+
+	dc := NewDecisionCache(2, 0)
+
+	a := decisionKey{method: "a"}
+	b := decisionKey{method: "b"}
+	c := decisionKey{method: "c"}
+
+	dc.put(a, Decision{Allowed: true}, 0)
+	dc.put(b, Decision{Allowed: true}, 0)
+
+	_, ok, _ := dc.get(a) // a is now most recently used
+	assert.True(t, ok)
+
+	dc.put(c, Decision{Allowed: true}, 0)
+
+	_, ok, _ = dc.get(b)
+	assert.False(t, ok)
+
+	stats := dc.Stats()
+	assert.Equal(t, uint64(1), stats.Hits)
+	assert.Equal(t, uint64(1), stats.Misses)
+	assert.Equal(t, uint64(1), stats.Evictions)
+	assert.Equal(t, 2, stats.Size)
+}
+
+func TestDecisionCacheTTL(t *testing.T) {
+
+	dc := NewDecisionCache(10, time.Millisecond)
+	key := decisionKey{method: "a"}
+
+	dc.put(key, Decision{Allowed: true}, 0)
+	time.Sleep(5 * time.Millisecond)
+
+	_, ok, _ := dc.get(key)
+	assert.False(t, ok)
+	assert.Equal(t, 0, dc.Stats().Size)
+}
+
+func TestDecisionCacheInvalidateDropsInflight(t *testing.T) {
+
+	dc := NewDecisionCache(10, 0)
+	key := decisionKey{method: "a"}
+
+	_, _, generation := dc.get(key)
+
+	dc.Invalidate()
+	dc.put(key, Decision{Allowed: true}, generation)
+
+	_, ok, _ := dc.get(key)
+	assert.False(t, ok)
+	assert.Equal(t, uint64(1), dc.Stats().Invalidations)
+}
+
+func TestCanonicalHashIsOrderIndependent(t *testing.T) {
+
+	a := ast.MustParseTerm(`{"a": 1, "b": {"x": [1, 2], "y": {"q", "p"}}}`).Value
+	b := ast.MustParseTerm(`{"b": {"y": {"p", "q"}, "x": [1, 2]}, "a": 1}`).Value
+	c := ast.MustParseTerm(`{"b": {"y": {"p", "q"}, "x": [2, 1]}, "a": 1}`).Value
+
+	assert.Equal(t, canonicalHash(a), canonicalHash(b))
+	assert.NotEqual(t, canonicalHash(a), canonicalHash(c))
+}
+
+func TestPEPDecisionCache(t *testing.T) {
+
+	pctx := New().
+		RegisterModule("licpol.test", agePolicy)
+
+	dc := NewDecisionCache(100, time.Minute)
+
+	pctx.OnChange(dc.OnPolicyContextChange)
+	pctx.CompileModuleSet("age", "licpol.test")
+
+	assert.Equal(t, uint64(1), dc.Stats().Invalidations)
+
+	pep := NewPolicyEnforcementPoint(
+		map[string]PEPRegistration{
+			"path/to/MyFunc2": {
+				Function:   MyFunc2,
+				Parameters: []string{"ms"},
+				Returns:    []string{"output"},
+			},
+		}).
+		UsePolicyContext("data.licpol.test.allow", pctx, "age", nil).
+		UseDecisionCache(dc)
+
+	assert.Equal(t, nil, pep.SetSecurityContext(map[string]interface{}{
+		"oidc": map[string]interface{}{"jti": "fcd2174b-664a-11eb-afe1-1629c910062f"},
+	}))
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, true, pep.CheckInvoke("path/to/MyFunc2", MyStruct{Name: "Nisse", Age: 18}).Allowed())
+		assert.Equal(t, false, pep.CheckInvoke("path/to/MyFunc2", MyStruct{Name: "Nisse", Age: 17}).Allowed())
+	}
+
+	stats := dc.Stats()
+	assert.Equal(t, uint64(4), stats.Hits)
+	assert.Equal(t, uint64(2), stats.Misses)
+	assert.Equal(t, 2, stats.Size)
+
+	assert.Equal(t, nil, pep.SetSecurityContext(map[string]interface{}{
+		"oidc": map[string]interface{}{"jti": "another-license"},
+	}))
+
+	pep.CheckInvoke("path/to/MyFunc2", MyStruct{Name: "Nisse", Age: 18})
+	assert.Equal(t, uint64(3), dc.Stats().Misses)
+
+	// same license id but other claims must not be served from the cache
+	assert.Equal(t, nil, pep.SetSecurityContext(map[string]interface{}{
+		"oidc": map[string]interface{}{"jti": "another-license", "scope": "simulator"},
+	}))
+
+	pep.CheckInvoke("path/to/MyFunc2", MyStruct{Name: "Nisse", Age: 18})
+	assert.Equal(t, uint64(4), dc.Stats().Misses)
+
+	// no license id at all
+	assert.Equal(t, nil, pep.SetSecurityContext(map[string]interface{}{}))
+
+	pep.CheckInvoke("path/to/MyFunc2", MyStruct{Name: "Nisse", Age: 18})
+	assert.Equal(t, uint64(5), dc.Stats().Misses)
+}
@@ -0,0 +1,421 @@
+package licpol
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+// DecisionLicense is the license that a decision was made for.
+type DecisionLicense struct {
+	// ID is the license id (_jti_).
+	ID string `json:"jti,omitempty"`
+	// Subject is the license subject (_sub_).
+	Subject string `json:"sub,omitempty"`
+}
+
+// DecisionEvent is a single entry in the decision log.
+//
+// It is compatible in shape with the _OPA_ decision log format such that existing
+// tooling can ingest it. The _method_, _license_, _input_hash_ and _reasons_ are
+// extensions to the format.
+type DecisionEvent struct {
+	Labels     map[string]string      `json:"labels,omitempty"`
+	DecisionID string                 `json:"decision_id"`
+	Revision   string                 `json:"revision,omitempty"`
+	Path       string                 `json:"path"`
+	Query      string                 `json:"query,omitempty"`
+	Method     string                 `json:"method"`
+	License    *DecisionLicense       `json:"license,omitempty"`
+	Input      *interface{}           `json:"input,omitempty"`
+	InputHash  string                 `json:"input_hash,omitempty"`
+	Result     *interface{}           `json:"result,omitempty"`
+	Reasons    []string               `json:"reasons,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+	Erased     []string               `json:"erased,omitempty"`
+	Timestamp  time.Time              `json:"timestamp"`
+	Metrics    map[string]interface{} `json:"metrics,omitempty"`
+}
+
+// DecisionLogSink receives decision events from a `DecisionLogger`.
+type DecisionLogSink interface {
+	// Log is invoked once for each decision.
+	Log(event *DecisionEvent) error
+}
+
+// DecisionLogFunc is a callback `DecisionLogSink`.
+type DecisionLogFunc func(event *DecisionEvent) error
+
+// Log invokes the function itself.
+func (f DecisionLogFunc) Log(event *DecisionEvent) error {
+	return f(event)
+}
+
+// DecisionLogger records decisions made by a `PDP` or a `PEP` to one or more sinks.
+//
+// Fields in the input may be masked, i.e. removed before logged and hashed, using
+// `Mask`.
+type DecisionLogger struct {
+	sinks        []DecisionLogSink
+	labels       map[string]string
+	maskPaths    [][]string
+	maskKeys     map[string]bool
+	includeInput bool
+	onerror      func(err error)
+}
+
+// NewDecisionLogger creates a new logger that writes to all _sinks_.
+func NewDecisionLogger(sinks ...DecisionLogSink) *DecisionLogger {
+
+	return &DecisionLogger{
+		sinks:    sinks,
+		maskKeys: map[string]bool{},
+	}
+
+}
+
+// Labels sets the labels, e.g. "app", "id" and "version" that is attached to each event.
+func (dl *DecisionLogger) Labels(labels map[string]string) *DecisionLogger {
+	dl.labels = labels
+	return dl
+}
+
+// Mask removes fields in the input before it is hashed and logged.
+//
+// A _path_ beginning with a '/' is a absolute path into the input, e.g.
+// "/sc/oidc/client_secret". Otherwise it is a key that is removed regardless of where
+// it appears, e.g. "client_secret".
+func (dl *DecisionLogger) Mask(paths ...string) *DecisionLogger {
+
+	for _, p := range paths {
+
+		if strings.HasPrefix(p, "/") {
+			dl.maskPaths = append(dl.maskPaths, strings.Split(strings.TrimPrefix(p, "/"), "/"))
+		} else {
+			dl.maskKeys[p] = true
+		}
+
+	}
+
+	return dl
+}
+
+// IncludeInput makes the, masked, input part of each event. By default, only the
+// hash of the input is logged.
+func (dl *DecisionLogger) IncludeInput(include bool) *DecisionLogger {
+	dl.includeInput = include
+	return dl
+}
+
+// OnError sets a function that is invoked when a sink fails. Since logging never fails
+// the decision itself, errors are otherwise dropped.
+func (dl *DecisionLogger) OnError(f func(err error)) *DecisionLogger {
+	dl.onerror = f
+	return dl
+}
+
+// log creates a event and writes it to all sinks.
+func (dl *DecisionLogger) log(
+	method, query, revision string,
+	input ast.Value,
+	decision Decision,
+	err error,
+	started time.Time,
+	metrics map[string]interface{}) {
+
+	event := &DecisionEvent{
+		Labels:     dl.labels,
+		DecisionID: decision.ID,
+		Revision:   revision,
+		Path:       queryPath(query),
+		Query:      query,
+		Method:     method,
+		Reasons:    decision.Reasons,
+		Timestamp:  started.UTC(),
+		Metrics:    metrics,
+	}
+
+	if event.Metrics == nil {
+		event.Metrics = map[string]interface{}{}
+	}
+
+	event.Metrics["timer_decision_ns"] = time.Since(started).Nanoseconds()
+
+	if err != nil {
+		event.Error = err.Error()
+	} else {
+		var result interface{} = decision.Allowed
+		event.Result = &result
+	}
+
+	if input != nil {
+		dl.addInput(event, input)
+	}
+
+	for _, sink := range dl.sinks {
+
+		if err := sink.Log(event); err != nil && dl.onerror != nil {
+			dl.onerror(err)
+		}
+
+	}
+}
+
+// addInput masks the input, hashes it and resolves the license.
+func (dl *DecisionLogger) addInput(event *DecisionEvent, input ast.Value) {
+
+	x, err := ast.JSON(input)
+
+	if err != nil {
+		event.Error = fmt.Sprintf("input: %s", err)
+		return
+	}
+
+	event.License = licenseFromInput(x)
+
+	for _, path := range dl.maskPaths {
+
+		if erase(x, path) {
+			event.Erased = append(event.Erased, "/input/"+strings.Join(path, "/"))
+		}
+
+	}
+
+	if len(dl.maskKeys) > 0 {
+		event.Erased = append(event.Erased, eraseKeys(x, dl.maskKeys, "/input")...)
+	}
+
+	data, err := json.Marshal(x) // maps are marshalled with sorted keys
+
+	if err != nil {
+		event.Error = fmt.Sprintf("input: %s", err)
+		return
+	}
+
+	sum := sha256.Sum256(data)
+	event.InputHash = hex.EncodeToString(sum[:])
+
+	if dl.includeInput {
+		event.Input = &x
+	}
+}
+
+// licenseFromInput resolves the license id and subject from the security context.
+func licenseFromInput(x interface{}) *DecisionLicense {
+
+	input, _ := x.(map[string]interface{})
+	sc, _ := input["sc"].(map[string]interface{})
+
+	if sc == nil {
+		return nil
+	}
+
+	candidates := []map[string]interface{}{sc}
+
+	for _, v := range sc {
+
+		if m, ok := v.(map[string]interface{}); ok {
+			candidates = append(candidates, m)
+		}
+
+	}
+
+	for _, m := range candidates {
+
+		jti, _ := m["jti"].(string)
+		sub, _ := m["sub"].(string)
+
+		if jti != "" || sub != "" {
+			return &DecisionLicense{ID: jti, Subject: sub}
+		}
+
+	}
+
+	return nil
+}
+
+// erase removes the _path_ in _x_. It returns `true` if removed.
+func erase(x interface{}, path []string) bool {
+
+	m, ok := x.(map[string]interface{})
+
+	if !ok || len(path) == 0 {
+		return false
+	}
+
+	if len(path) == 1 {
+
+		if _, ok := m[path[0]]; ok {
+			delete(m, path[0])
+			return true
+		}
+
+		return false
+	}
+
+	return erase(m[path[0]], path[1:])
+}
+
+// eraseKeys removes all _keys_ regardless of depth and returns the removed paths.
+func eraseKeys(x interface{}, keys map[string]bool, prefix string) []string {
+
+	var erased []string
+
+	switch v := x.(type) {
+	case map[string]interface{}:
+
+		for k, child := range v {
+
+			if keys[k] {
+				delete(v, k)
+				erased = append(erased, prefix+"/"+k)
+				continue
+			}
+
+			erased = append(erased, eraseKeys(child, keys, prefix+"/"+k)...)
+		}
+
+	case []interface{}:
+
+		for i, child := range v {
+			erased = append(erased, eraseKeys(child, keys, fmt.Sprintf("%s/%d", prefix, i))...)
+		}
+
+	}
+
+	return erased
+}
+
+// queryPath converts a query such as "data.licpol.allow" to the _OPA_ decision
+// log path format "licpol/allow".
+func queryPath(query string) string {
+
+	ref, err := ast.ParseRef(query)
+
+	if err != nil || len(ref) == 0 || !ref[0].Equal(ast.DefaultRootDocument) {
+		return query
+	}
+
+	parts := make([]string, 0, len(ref)-1)
+
+	for _, t := range ref[1:] {
+
+		s, ok := t.Value.(ast.String)
+
+		if !ok {
+			return query
+		}
+
+		parts = append(parts, string(s))
+	}
+
+	return strings.Join(parts, "/")
+}
+
+// JSONLinesSink writes each event as a single line of _JSON_.
+type JSONLinesSink struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+// NewJSONLinesSink creates a sink that writes to _w_.
+func NewJSONLinesSink(w io.Writer) *JSONLinesSink {
+	return &JSONLinesSink{w: w}
+}
+
+// NewJSONLinesFileSink creates a sink that appends to the file at _path_. The
+// file is created if it do not exist.
+func NewJSONLinesFileSink(path string) (*JSONLinesSink, error) {
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &JSONLinesSink{w: f, closer: f}, nil
+}
+
+// Log writes the _event_ as a single line.
+func (s *JSONLinesSink) Log(event *DecisionEvent) error {
+
+	data, err := json.Marshal(event)
+
+	if err != nil {
+		return err
+	}
+
+	data = append(data, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = s.w.Write(data)
+	return err
+}
+
+// Close closes the underlying file, if opened by `NewJSONLinesFileSink`.
+func (s *JSONLinesSink) Close() error {
+
+	if s.closer == nil {
+		return nil
+	}
+
+	return s.closer.Close()
+}
+
+// RingSink keeps the last _n_ events in memory.
+type RingSink struct {
+	mu     sync.Mutex
+	events []DecisionEvent
+	next   int
+	full   bool
+}
+
+// NewRingSink creates a in-memory sink that keeps the last _size_ events.
+func NewRingSink(size int) *RingSink {
+
+	if size <= 0 {
+		panic("ring sink size must be greater than zero")
+	}
+
+	return &RingSink{events: make([]DecisionEvent, size)}
+}
+
+// Log stores the _event_ and overwrites the oldest if full.
+func (s *RingSink) Log(event *DecisionEvent) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.events[s.next] = *event
+	s.next = (s.next + 1) % len(s.events)
+
+	if s.next == 0 {
+		s.full = true
+	}
+
+	return nil
+}
+
+// Events returns the stored events, oldest first.
+func (s *RingSink) Events() []DecisionEvent {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.full {
+		return append([]DecisionEvent{}, s.events[:s.next]...)
+	}
+
+	return append(append([]DecisionEvent{}, s.events[s.next:]...), s.events[:s.next]...)
+}
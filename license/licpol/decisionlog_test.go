@@ -0,0 +1,110 @@
+package licpol
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPDPDecisionLog(t *testing.T) {
+
+	ring := NewRingSink(2)
+	var buf bytes.Buffer
+
+	pdp := newScopePDP(t).
+		SetRevision("rev-1").
+		UseDecisionLogger(
+			NewDecisionLogger(ring, NewJSONLinesSink(&buf)).
+				Labels(map[string]string{"app": "licpol-test"}).
+				Mask("client_secret", "/body/ms/Age").
+				IncludeInput(true),
+		)
+
+	msg := scopeMessage("simulator")
+	msg.SecurityContext["oidc"].(map[string]interface{})["jti"] = "fcd2174b-664a-11eb-afe1-1629c910062f"
+	msg.SecurityContext["oidc"].(map[string]interface{})["sub"] = "hobbe.nisse@azcam.net"
+	msg.SecurityContext["oidc"].(map[string]interface{})["client_secret"] = "SecretFromAWSCognito"
+
+	decision, err := pdp.Decide(context.Background(), msg)
+	assert.Equal(t, nil, err)
+
+	events := ring.Events()
+	assert.Equal(t, 1, len(events))
+
+	event := events[0]
+	assert.Equal(t, decision.ID, event.DecisionID)
+	assert.Equal(t, "rev-1", event.Revision)
+	assert.Equal(t, "licpol/scope/decision", event.Path)
+	assert.Equal(t, "path/to/MyFunc2", event.Method)
+	assert.Equal(t, "licpol-test", event.Labels["app"])
+	assert.Equal(t, &DecisionLicense{
+		ID:      "fcd2174b-664a-11eb-afe1-1629c910062f",
+		Subject: "hobbe.nisse@azcam.net",
+	}, event.License)
+	assert.Equal(t, true, *event.Result)
+	assert.ElementsMatch(t, []string{"/input/body/ms/Age", "/input/sc/oidc/client_secret"}, event.Erased)
+	assert.NotEqual(t, "", event.InputHash)
+	assert.False(t, strings.Contains(buf.String(), "SecretFromAWSCognito"))
+	assert.True(t, strings.HasSuffix(buf.String(), "\n"))
+
+	var logged map[string]interface{}
+	assert.Equal(t, nil, json.Unmarshal(buf.Bytes(), &logged))
+	assert.Equal(t, decision.ID, logged["decision_id"])
+
+	// the hash is computed over the masked input, hence a different secret gives the same hash
+	msg.SecurityContext["oidc"].(map[string]interface{})["client_secret"] = "AnotherSecret"
+
+	_, err = pdp.Decide(context.Background(), msg)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, event.InputHash, ring.Events()[1].InputHash)
+}
+
+func TestPEPDecisionLogIncludesCacheHits(t *testing.T) {
+
+	ring := NewRingSink(10)
+
+	pctx := New().
+		RegisterModule("licpol.test", agePolicy).
+		CompileModuleSet("age", "licpol.test")
+
+	pep := NewPolicyEnforcementPoint(
+		map[string]PEPRegistration{
+			"path/to/MyFunc2": {
+				Function:   MyFunc2,
+				Parameters: []string{"ms"},
+				Returns:    []string{"output"},
+			},
+		}).
+		UsePolicyContext("data.licpol.test.allow", pctx, "age", nil).
+		UseDecisionCache(NewDecisionCache(10, time.Minute)).
+		UseDecisionLogger(NewDecisionLogger(ring))
+
+	pep.CheckInvoke("path/to/MyFunc2", MyStruct{Name: "Nisse", Age: 17})
+	pep.CheckInvoke("path/to/MyFunc2", MyStruct{Name: "Nisse", Age: 17})
+
+	events := ring.Events()
+	assert.Equal(t, 2, len(events))
+	assert.Equal(t, false, events[0].Metrics["cache_hit"])
+	assert.Equal(t, true, events[1].Metrics["cache_hit"])
+	assert.Equal(t, false, *events[1].Result)
+	assert.NotEqual(t, events[0].DecisionID, events[1].DecisionID)
+	assert.Equal(t, events[0].InputHash, events[1].InputHash)
+}
+
+func TestRingSinkWraps(t *testing.T) {
+
+	ring := NewRingSink(2)
+
+	for _, id := range []string{"a", "b", "c"} {
+		ring.Log(&DecisionEvent{DecisionID: id})
+	}
+
+	events := ring.Events()
+	assert.Equal(t, "b", events[0].DecisionID)
+	assert.Equal(t, "c", events[1].DecisionID)
+}
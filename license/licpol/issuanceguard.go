@@ -0,0 +1,144 @@
+package licpol
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/mariotoffia/gojwtlic/license"
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/util"
+)
+
+var licenseDataRef = ast.MustParseRef("data.license")
+
+// IssuanceGuard is a `license.IssuanceGuard` that evaluates a decision query in a
+// `PolicyContext` to decide if a caller may issue a license.
+//
+// The input is the same as in _rego/input.json_, i.e.
+// `{"method": "POST", "path": ["license", "generate", <sub>], "claims": <caller>}`
+// and _data.license_ is the license to be issued. The query may evaluate to a boolean
+// or to a object such as `{"allow": false, "reasons": ["scope simulator missing"]}`.
+type IssuanceGuard struct {
+	pctx     PolicyContext
+	compiled string
+	query    string
+}
+
+// NewIssuanceGuard creates a new `IssuanceGuard` that evaluates the _query_ using the
+// _compiled_ module set in _pctx_.
+//
+// .Example Usage
+// [source,go]
+// ....
+// guard := NewIssuanceGuard(pctx, "issue", "data.licpol.testing.allow_create")
+//
+// generator.Guard(guard)
+// ....
+func NewIssuanceGuard(pctx PolicyContext, compiled, query string) *IssuanceGuard {
+
+	if pctx == nil {
+		panic("policy context must be specified")
+	}
+
+	return &IssuanceGuard{
+		pctx:     pctx,
+		compiled: compiled,
+		query:    query,
+	}
+
+}
+
+// AllowIssue evaluates the query and returns a `*license.IssuanceDeniedError` if the
+// _caller_ may not issue the license _info_.
+func (g *IssuanceGuard) AllowIssue(caller map[string]interface{}, info *license.FeatureInfo) error {
+
+	decision, err := g.Decide(context.Background(), caller, info)
+
+	if err != nil {
+		return err
+	}
+
+	if !decision.Allowed {
+		return &license.IssuanceDeniedError{Reasons: decision.Reasons}
+	}
+
+	return nil
+}
+
+// Decide evaluates the query and returns the decision.
+func (g *IssuanceGuard) Decide(
+	c context.Context,
+	caller map[string]interface{},
+	info *license.FeatureInfo) (Decision, error) {
+
+	compiler, err := g.pctx.Policy(g.compiled)
+
+	if err != nil {
+		return Decision{}, err
+	}
+
+	lic, err := licenseValue(info)
+
+	if err != nil {
+		return Decision{}, err
+	}
+
+	body, err := ast.ParseBody(g.query)
+
+	if err != nil {
+		return Decision{}, err
+	}
+
+	for i, expr := range body {
+		body[i] = expr.IncludeWith(ast.NewTerm(licenseDataRef), ast.NewTerm(lic))
+	}
+
+	if caller == nil {
+		caller = map[string]interface{}{}
+	}
+
+	r, err := g.pctx.NewEval(
+		rego.Compiler(compiler),
+		rego.ParsedQuery(body),
+		rego.Input(map[string]interface{}{
+			"method": "POST",
+			"path":   []interface{}{"license", "generate", info.Subject},
+			"claims": caller,
+		}),
+	)
+
+	if err != nil {
+		return Decision{}, fmt.Errorf("cannot evaluate %s: %w", g.query, err)
+	}
+
+	rs, err := r.Eval(c)
+
+	if err != nil {
+		return Decision{}, err
+	}
+
+	decision, err := decisionFromResultSet(rs)
+	decision.ID = newDecisionID()
+
+	return decision, err
+}
+
+// licenseValue converts the _info_ to a `ast.Value` as it is rendered in _JSON_.
+func licenseValue(info *license.FeatureInfo) (ast.Value, error) {
+
+	data, err := json.Marshal(info)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var v interface{}
+
+	if err := util.UnmarshalJSON(data, &v); err != nil {
+		return nil, err
+	}
+
+	return ast.InterfaceToValue(v)
+}
@@ -0,0 +1,42 @@
+package licpol
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/mariotoffia/gojwtlic/license"
+	"github.com/mariotoffia/gojwtlic/license/licjwt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIssuanceGuard(t *testing.T) {
+
+	prp, err := NewFilesystemPRP("../../rego", 0)
+	assert.Equal(t, nil, err)
+
+	pctx := New().
+		RegisterPRP(context.Background(), prp).
+		CompileModuleSet("issue", "test.rego")
+
+	assert.Equal(t, nil, pctx.Error())
+
+	generator := licjwt.NewGeneratorBuilder().
+		Guard(NewIssuanceGuard(pctx, "issue", "data.licpol.testing.allow_create"))
+
+	caller := map[string]interface{}{"scope": "simulator regulate ui settings"}
+
+	lic := generator.CreateFor(caller, generator.CreateFeatureInfo().Feature("simulator").Feature("ui"))
+	assert.Equal(t, nil, generator.Error())
+	assert.Contains(t, lic, `"scope":"simulator ui"`)
+
+	lic = generator.CreateFor(caller, generator.CreateFeatureInfo().Feature("simulator").Feature("admin"))
+	assert.Equal(t, "", lic)
+	assert.True(t, errors.Is(generator.Error(), license.ErrIssuanceDenied))
+
+	generator.ClearError()
+
+	lic = generator.Create(generator.CreateFeatureInfo().Feature("ui"))
+	assert.Equal(t, "", lic)
+	assert.True(t, errors.Is(generator.Error(), license.ErrIssuanceDenied))
+}
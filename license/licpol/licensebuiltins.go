@@ -0,0 +1,222 @@
+package licpol
+
+import (
+	"strings"
+
+	"github.com/mariotoffia/gojwtlic/license"
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/types"
+)
+
+var (
+	licenseScopeKey    = ast.StringTerm("scope")
+	licenseFeaturesKey = ast.StringTerm("features")
+	licenseClaimsKey   = ast.StringTerm("claims")
+	licenseClaimsType  = types.NewObject(nil, types.NewDynamicProperty(types.S, types.A))
+)
+
+// LicenseBuiltins returns the license built-in functions that verifies licenses using
+// _validator_. Register them using `PolicyContext.RegisterBuiltins`.
+//
+// The `license.verify(token)` verifies the _token_ and returns the claims. It is
+// undefined if the token is not valid.
+//
+// The `license.has_feature(claims, name)` is `true` if _name_ is granted in the scope or
+// has feature claims.
+//
+// The `license.feature_claim(claims, feature, key)` returns the _key_ claim of the
+// _feature_. It is undefined if not present.
+//
+// The `license.scopes(claims)` returns the scope as a set. The _claims_ may also be a
+// scope string, i.e. it replaces the _scopes_to_set_ rule.
+//
+// .Example Usage
+// [source,go]
+// ....
+// pctx := New()
+// err := pctx.RegisterBuiltins(LicenseBuiltins(licbuiltin.NewValidator(keys))...)
+//
+// pctx.RegisterModule("licpol.lic", `package licpol.lic
+// allow {
+// claims := license.verify(input.token)
+// license.has_feature(claims, "ui")
+// }`)
+// ....
+func LicenseBuiltins(validator license.Validator) []PolicyBuiltin {
+
+	if validator == nil {
+		panic("validator must be specified")
+	}
+
+	return []PolicyBuiltin{
+		{
+			Decl: &rego.Function{
+				Name: "license.verify",
+				Decl: types.NewFunction(types.Args(types.S), licenseClaimsType),
+			},
+			Impl: func(bctx rego.BuiltinContext, terms []*ast.Term) (*ast.Term, error) {
+				return licenseVerify(validator, terms[0])
+			},
+		},
+		{
+			Decl: &rego.Function{
+				Name: "license.has_feature",
+				Decl: types.NewFunction(types.Args(licenseClaimsType, types.S), types.B),
+			},
+			Impl: licenseHasFeature,
+		},
+		{
+			Decl: &rego.Function{
+				Name: "license.feature_claim",
+				Decl: types.NewFunction(types.Args(licenseClaimsType, types.S, types.S), types.A),
+			},
+			Impl: licenseFeatureClaim,
+		},
+		{
+			Decl: &rego.Function{
+				Name: "license.scopes",
+				Decl: types.NewFunction(types.Args(types.NewAny(licenseClaimsType, types.S)), types.NewSet(types.S)),
+			},
+			Impl: licenseScopes,
+		},
+	}
+}
+
+func licenseVerify(validator license.Validator, token *ast.Term) (*ast.Term, error) {
+
+	s, ok := token.Value.(ast.String)
+
+	if !ok {
+		return nil, nil
+	}
+
+	info, err := validator.Validate(string(s))
+
+	if err != nil {
+		// a invalid license is undefined, not a error
+		return nil, nil
+	}
+
+	v, err := licenseValue(info)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ast.NewTerm(v), nil
+}
+
+func licenseHasFeature(bctx rego.BuiltinContext, terms []*ast.Term) (*ast.Term, error) {
+
+	claims, ok := terms[0].Value.(ast.Object)
+	name, ok2 := terms[1].Value.(ast.String)
+
+	if !ok || !ok2 {
+		return nil, nil
+	}
+
+	if features, ok := claimsFeatures(claims); ok && features.Get(ast.NewTerm(name)) != nil {
+		return ast.BooleanTerm(true), nil
+	}
+
+	for _, scope := range claimsScopes(claims) {
+
+		if scope == string(name) {
+			return ast.BooleanTerm(true), nil
+		}
+
+	}
+
+	return ast.BooleanTerm(false), nil
+}
+
+func licenseFeatureClaim(bctx rego.BuiltinContext, terms []*ast.Term) (*ast.Term, error) {
+
+	claims, ok := terms[0].Value.(ast.Object)
+
+	if !ok {
+		return nil, nil
+	}
+
+	features, ok := claimsFeatures(claims)
+
+	if !ok {
+		return nil, nil
+	}
+
+	feature := features.Get(terms[1])
+
+	if feature == nil {
+		return nil, nil
+	}
+
+	obj, ok := feature.Value.(ast.Object)
+
+	if !ok {
+		return nil, nil
+	}
+
+	fclaims := obj.Get(licenseClaimsKey)
+
+	if fclaims == nil {
+		return nil, nil
+	}
+
+	if obj, ok = fclaims.Value.(ast.Object); !ok {
+		return nil, nil
+	}
+
+	return obj.Get(terms[2]), nil
+}
+
+func licenseScopes(bctx rego.BuiltinContext, terms []*ast.Term) (*ast.Term, error) {
+
+	var scopes []string
+
+	switch x := terms[0].Value.(type) {
+	case ast.String:
+		scopes = strings.Fields(string(x))
+	case ast.Object:
+		scopes = claimsScopes(x)
+	default:
+		return nil, nil
+	}
+
+	set := make([]*ast.Term, 0, len(scopes))
+
+	for _, scope := range scopes {
+		set = append(set, ast.StringTerm(scope))
+	}
+
+	return ast.SetTerm(set...), nil
+}
+
+// claimsScopes returns the space separated scopes in the scope claim.
+func claimsScopes(claims ast.Object) []string {
+
+	scope := claims.Get(licenseScopeKey)
+
+	if scope == nil {
+		return nil
+	}
+
+	if s, ok := scope.Value.(ast.String); ok {
+		return strings.Fields(string(s))
+	}
+
+	return nil
+}
+
+// claimsFeatures returns the features claim.
+func claimsFeatures(claims ast.Object) (ast.Object, bool) {
+
+	features := claims.Get(licenseFeaturesKey)
+
+	if features == nil {
+		return nil, false
+	}
+
+	obj, ok := features.Value.(ast.Object)
+	return obj, ok
+}
@@ -0,0 +1,83 @@
+package licpol
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mariotoffia/gojwtlic/license"
+	"github.com/mariotoffia/gojwtlic/license/licjwt"
+	"github.com/mariotoffia/gojwtlic/license/licjwt/licbuiltin"
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/stretchr/testify/assert"
+)
+
+const licenseBuiltinsPolicy = `
+package licpol.verify
+
+claims = license.verify(input.token)
+
+ui = license.has_feature(claims, "ui")
+
+access = license.feature_claim(claims, "settings", "access")
+
+scopes = license.scopes(claims)
+
+same = license.scopes("simulator ui") == license.scopes(claims)`
+
+func TestLicenseBuiltins(t *testing.T) {
+
+	keys := licbuiltin.NewRSAKeys(2048)
+
+	generator := licjwt.NewGeneratorBuilderWithSigner(licbuiltin.NewSignCreator(keys, "RS256")).
+		LicenseLength(time.Hour)
+
+	token := generator.Create(
+		generator.CreateFeatureInfo().
+			Feature("simulator").
+			Feature("ui").
+			FeatureDetails(map[string]license.Feature{
+				"settings": &license.FeatureImpl{Claims: map[string]interface{}{"access": "rw"}},
+			}),
+	)
+
+	assert.Equal(t, nil, generator.Error())
+
+	pctx := New()
+
+	assert.Equal(t, nil, pctx.RegisterBuiltins(
+		LicenseBuiltins(licbuiltin.NewValidator(licbuiltin.NewRSAKeys(2048), keys))...,
+	))
+
+	pctx.RegisterModule("licpol.verify", licenseBuiltinsPolicy).
+		CompileModuleSet("verify", "licpol.verify")
+
+	assert.Equal(t, nil, pctx.Error())
+
+	rs, err := pctx.Evaluate(context.Background(), "verify", "data.licpol.verify",
+		map[string]interface{}{"token": token})
+
+	assert.Equal(t, nil, err)
+
+	result := rs[0].Expressions[0].Value.(map[string]interface{})
+	assert.Equal(t, true, result["ui"])
+	assert.Equal(t, "rw", result["access"])
+	assert.Equal(t, []interface{}{"simulator", "ui"}, result["scopes"])
+	assert.Equal(t, true, result["same"])
+
+	rs, err = pctx.Evaluate(context.Background(), "verify", "data.licpol.verify.claims",
+		map[string]interface{}{"token": token + "x"})
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(rs))
+}
+
+func TestLicenseVerifyInvalidIsUndefined(t *testing.T) {
+
+	validator := licbuiltin.NewValidator(licbuiltin.NewRSAKeys(2048))
+
+	term, err := licenseVerify(validator, ast.StringTerm("not-a-license"))
+
+	assert.Equal(t, nil, err)
+	assert.Nil(t, term)
+}
@@ -108,9 +108,15 @@ func TestMultipleDataSources(t *testing.T) {
 		RegisterModule("licpol.testing", module).
 		CompileModuleSet("test-module", "licpol.testing")
 
-	rs, err := pctx.NewEval(
+	compiler, err := pctx.Policy("test-module")
+
+	if err != nil {
+		panic(err)
+	}
+
+	r, err := pctx.NewEval(
 		rego.Query("[data.example.allow_create_a,data.example.allow_create_b]"),
-		rego.Compiler(pctx.Policy("test-module")),
+		rego.Compiler(compiler),
 		JSONInput(input),
 		rego.Store(
 			NewInMemStoreBuilder(
@@ -119,8 +125,13 @@ func TestMultipleDataSources(t *testing.T) {
 				AddJSON("b", data2).
 				Build(),
 		),
-	).
-		Eval(context.Background())
+	)
+
+	if err != nil {
+		panic(err)
+	}
+
+	rs, err := r.Eval(context.Background())
 
 	if err != nil {
 		panic(err)
@@ -243,21 +254,30 @@ func BenchmarkMultipleDataSources(t *testing.B) {
 		AddJSON("b", data2).
 		Build()
 
+	compiler, err := pctx.Policy("test-module")
+
+	if err != nil {
+		panic(err)
+	}
+
 	t.ResetTimer()
 
 	for i := 0; i < t.N; i++ {
 
-		_, err := pctx.NewEval(
+		r, err := pctx.NewEval(
 			rego.Query("[data.example.allow_create_a,data.example.allow_create_b]"),
-			rego.Compiler(pctx.Policy("test-module")),
+			rego.Compiler(compiler),
 			JSONInput(input),
 			rego.Store(store),
-		).
-			Eval(context.Background())
+		)
 
 		if err != nil {
 			panic(err)
 		}
+
+		if _, err := r.Eval(context.Background()); err != nil {
+			panic(err)
+		}
 	}
 
 }
@@ -1,11 +1,27 @@
 package licpol
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"reflect"
-	"strconv"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/metrics"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/storage"
 )
 
+// ErrUnknownMethod is returned (wrapped) when a decision is requested for a method
+// that has not been registered in the _PDP_.
+var ErrUnknownMethod = errors.New("method not registered")
+
 // PDPMessage is a standar PDP message.
 //
 // .Example Invocation
@@ -44,37 +60,402 @@ type PDPMessage struct {
 	Body            map[string]interface{} `json:"body,omitempty"`
 }
 
-// PDP is the Policy Decision Point implementation
+// Decision is the outcome of a single _PDP_ evaluation.
+//
+// The decision query may either evaluate to a boolean or to a object such as
+// `{"allow": false, "reasons": ["scope simulator missing"]}`.
+type Decision struct {
+	// ID is a unique id for this decision.
+	ID string `json:"decision_id"`
+	// Allowed is `true` if the invocation is allowed.
+	Allowed bool `json:"allowed"`
+	// Reasons is the, optional, reasons that the policy gave for it's decision.
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+// Decider is able to answer decisions using the `PDPMessage` format. Both the
+// in-process `PDP` and the `RemotePDP` implements this interface.
+type Decider interface {
+	// Decide evaluates the _msg_ and returns the decision.
+	Decide(c context.Context, msg PDPMessage) (Decision, error)
+}
+
+// PDPParameter describes a single parameter or return value of a registered method.
+type PDPParameter struct {
+	// Name is the name of the parameter as it appears in the `PDPMessage.Body`.
+	Name string `json:"name"`
+	// Type is the go type of the parameter.
+	Type string `json:"type,omitempty"`
+	// Schema is a _JSON Schema_ of the parameter when rendered as _JSON_.
+	Schema map[string]interface{} `json:"schema,omitempty"`
+}
+
+// PDPMethod is the registration metadata for a single method.
+type PDPMethod struct {
+	// Method is the path to the method e.g. `["path", "to", "MyFunc"]`.
+	Method []string `json:"method"`
+	// Query is the decision query for this method.
+	Query string `json:"query"`
+	// Variadic is `true` if the last parameter is variadic.
+	Variadic bool `json:"variadic,omitempty"`
+	// Parameters describes the in-parameters in order.
+	Parameters []PDPParameter `json:"parameters"`
+	// Returns describes the return values in order.
+	Returns []PDPParameter `json:"returns"`
+}
+
+// PDP is the Policy Decision Point implementation.
+//
+// It owns the compiled policies and the data store and answers decisions for
+// registered methods. It may be used in-process by the `PEP` or be mounted as
+// a `http.Handler` to be used by a remote _PEP_ via `RemotePDP`.
 type PDP struct {
+	mu       sync.RWMutex
+	query    string
+	methods  map[string]*PDPMethod
+	queries  *preparedQueries
+	onchange []func()
+	logger   *DecisionLogger
+	revision atomic.Value
 }
 
-func (pdp *PDP) Register(f interface{}) {
+// NewPDP creates a new _PDP_ that evaluates decisions using the _compiled_ module set
+// in the _pctx_ and the, optional, _store_. The _query_ is the default decision query for
+// methods that do not specify one.
+//
+// Whenever the _compiled_ module set is replaced in the _pctx_, all queries are
+// re-prepared.
+//
+// It panics if the _compiled_ module set do not exist in _pctx_.
+func NewPDP(query string, pctx PolicyContext, compiled string, store storage.Store) *PDP {
 
-	rf := reflect.TypeOf(f)
+	compiler, err := pctx.Policy(compiled)
 
-	if rf.Kind() != reflect.Func {
-		panic("expects a function")
+	if err != nil {
+		panic(err)
 	}
 
-	numIn := rf.NumIn()   //Count inbound parameters
-	numOut := rf.NumOut() //Count outbounding parameters
+	pdp := NewPDPWithCompiler(query, compiler, store)
 
-	fmt.Println("Method:", rf.String())
-	fmt.Println("Variadic:", rf.IsVariadic()) // Used (<type> ...) ?
-	fmt.Println("Package:", rf.PkgPath())
+	pctx.OnChange(func(pc PolicyContext, name string) {
 
-	for i := 0; i < numIn; i++ {
+		if name != compiled {
+			return
+		}
+
+		if compiler, err := pc.Policy(compiled); err == nil {
+			pdp.queries.reset(compiler)
+			pdp.notify()
+		}
+
+	})
+
+	return pdp
+}
 
-		inV := rf.In(i)
-		in_Kind := inV.Kind()
-		fmt.Println(inV)
-		fmt.Printf("\nParameter IN: "+strconv.Itoa(i)+"\nKind: %v\nName: %v\n-----------", in_Kind, inV.Name())
+// NewPDPWithCompiler is same as `NewPDP` but uses the _compiler_ directly.
+func NewPDPWithCompiler(query string, compiler *ast.Compiler, store storage.Store) *PDP {
+
+	return &PDP{
+		query:   query,
+		methods: map[string]*PDPMethod{},
+		queries: newPreparedQueries(compiler, store),
 	}
-	for o := 0; o < numOut; o++ {
 
-		returnV := rf.Out(0)
-		return_Kind := returnV.Kind()
-		fmt.Printf("\nParameter OUT: "+strconv.Itoa(o)+"\nKind: %v\nName: %v\n", return_Kind, returnV.Name())
+}
+
+// OnChange registers a function that is invoked when the underlying policy has
+// changed and hence earlier decisions may be stale.
+func (pdp *PDP) OnChange(f func()) *PDP {
+
+	pdp.mu.Lock()
+	defer pdp.mu.Unlock()
+
+	pdp.onchange = append(pdp.onchange, f)
+	return pdp
+}
+
+// Register registers the _method_ with it's metadata. The `PEPRegistration.Function` is
+// optional, but if present, it is used to describe the parameters and return values.
+//
+// It returns a error if already registered or the registration is inconsistent.
+func (pdp *PDP) Register(method string, registration PEPRegistration) error {
+
+	m := &PDPMethod{
+		Method: strings.Split(method, "/"),
+		Query:  registration.Query,
+	}
+
+	if m.Query == "" {
+		m.Query = pdp.query
+	}
+
+	if m.Query == "" {
+		return fmt.Errorf("no decision query for method: %s", method)
+	}
+
+	var rf reflect.Type
+
+	if registration.Function != nil {
+
+		rf = reflect.TypeOf(registration.Function)
+
+		if rf.Kind() != reflect.Func {
+			return fmt.Errorf("expects a function, got %T for method: %s", registration.Function, method)
+		}
+
+		if rf.NumIn() != len(registration.Parameters) || rf.NumOut() != len(registration.Returns) {
+			return fmt.Errorf("number of parameters and number of named parameters mismatch, method: %s", method)
+		}
+
+		m.Variadic = rf.IsVariadic()
+
+	}
+
+	for i, name := range registration.Parameters {
+
+		prm := PDPParameter{Name: name}
+
+		if rf != nil {
+			prm.Type = rf.In(i).String()
+			prm.Schema = schemaOf(rf.In(i))
+		}
+
+		m.Parameters = append(m.Parameters, prm)
+	}
+
+	for i, name := range registration.Returns {
+
+		prm := PDPParameter{Name: name}
+
+		if rf != nil {
+			prm.Type = rf.Out(i).String()
+			prm.Schema = schemaOf(rf.Out(i))
+		}
+
+		m.Returns = append(m.Returns, prm)
+	}
+
+	pdp.mu.Lock()
+	defer pdp.mu.Unlock()
+
+	if _, ok := pdp.methods[method]; ok {
+		return fmt.Errorf("method %s already registered", method)
+	}
+
+	pdp.methods[method] = m
+	return nil
+}
+
+// hasMethod returns `true` if the _method_ is registered.
+func (pdp *PDP) hasMethod(method string) bool {
+
+	pdp.mu.RLock()
+	defer pdp.mu.RUnlock()
+
+	_, ok := pdp.methods[method]
+	return ok
+}
+
+// Methods returns all registered methods sorted by method path.
+func (pdp *PDP) Methods() []PDPMethod {
+
+	pdp.mu.RLock()
+	defer pdp.mu.RUnlock()
+
+	methods := make([]PDPMethod, 0, len(pdp.methods))
+
+	for _, m := range pdp.methods {
+		methods = append(methods, *m)
+	}
+
+	sort.Slice(methods, func(i, j int) bool {
+		return strings.Join(methods[i].Method, "/") < strings.Join(methods[j].Method, "/")
+	})
+
+	return methods
+}
+
+// Decide evaluates the _msg_ against the query of the registered method.
+func (pdp *PDP) Decide(c context.Context, msg PDPMessage) (Decision, error) {
+
+	input, err := ToValue(&msg)
+
+	if err != nil {
+		return Decision{}, err
+	}
+
+	return pdp.DecideValue(c, strings.Join(msg.Method, "/"), input)
+}
+
+// DecideValue is the fast path of `Decide` where the _input_ already is in the
+// `PDPMessage` format but as a `ast.Value`. The _method_ is the '/' separated path.
+func (pdp *PDP) DecideValue(c context.Context, method string, input ast.Value) (Decision, error) {
+
+	pdp.mu.RLock()
+	m, ok := pdp.methods[method]
+	logger := pdp.logger
+	pdp.mu.RUnlock()
+
+	if !ok {
+		return Decision{}, fmt.Errorf("%w: %s", ErrUnknownMethod, method)
+	}
+
+	if logger == nil {
+		return pdp.eval(c, method, m.Query, input, nil)
+	}
+
+	started := time.Now()
+	m2 := metrics.New()
+
+	decision, err := pdp.eval(c, method, m.Query, input, m2)
+
+	logger.log(method, m.Query, pdp.Revision(), input, decision, err, started, m2.All())
+	return decision, err
+}
+
+// eval evaluates the prepared query for the _method_. The _m_ is optional.
+func (pdp *PDP) eval(
+	c context.Context,
+	method, query string,
+	input ast.Value,
+	m metrics.Metrics) (Decision, error) {
+
+	id := newDecisionID()
+
+	pq, err := pdp.queries.get(c, method, query)
+
+	if err != nil {
+		return Decision{ID: id}, err
+	}
+
+	options := []rego.EvalOption{rego.EvalParsedInput(input)}
+
+	if m != nil {
+		options = append(options, rego.EvalMetrics(m))
+	}
+
+	rs, err := pq.Eval(c, options...)
+
+	if err != nil {
+		return Decision{ID: id}, err
+	}
+
+	decision, err := decisionFromResultSet(rs)
+	decision.ID = id
+
+	return decision, err
+}
+
+// UseDecisionLogger makes the _PDP_ log all decisions it evaluates.
+func (pdp *PDP) UseDecisionLogger(logger *DecisionLogger) *PDP {
+
+	pdp.mu.Lock()
+	defer pdp.mu.Unlock()
+
+	pdp.logger = logger
+	return pdp
+}
+
+// SetRevision sets the policy revision, e.g. bundle revision, that is recorded in
+// the decision log.
+func (pdp *PDP) SetRevision(revision string) *PDP {
+
+	pdp.revision.Store(revision)
+	return pdp
+}
+
+// Revision returns the policy revision set by `SetRevision`.
+func (pdp *PDP) Revision() string {
+
+	revision, _ := pdp.revision.Load().(string)
+	return revision
+}
+
+// queryOf returns the decision query for the _method_ or an empty string if not registered.
+func (pdp *PDP) queryOf(method string) string {
+
+	pdp.mu.RLock()
+	defer pdp.mu.RUnlock()
+
+	if m, ok := pdp.methods[method]; ok {
+		return m.Query
+	}
+
+	return ""
+}
+
+// newDecisionID creates a new unique decision id.
+func newDecisionID() string {
+	return uuid.New().String()
+}
+
+// notify invokes all change listeners.
+func (pdp *PDP) notify() {
+
+	pdp.mu.RLock()
+	onchange := pdp.onchange
+	pdp.mu.RUnlock()
+
+	for _, f := range onchange {
+		f()
+	}
+}
+
+// schemaOf creates a _JSON Schema_ for _t_ when rendered using `ToValue`.
+func schemaOf(t reflect.Type) map[string]interface{} {
+	return schemaOfSeen(t, map[reflect.Type]bool{})
+}
+
+func schemaOfSeen(t reflect.Type, seen map[reflect.Type]bool) map[string]interface{} {
+
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t.Implements(jsonMarshalerType) || reflect.PtrTo(t).Implements(jsonMarshalerType) {
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "contentEncoding": "base64"}
+		}
+
+		return map[string]interface{}{"type": "array", "items": schemaOfSeen(t.Elem(), seen)}
+
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaOfSeen(t.Elem(), seen)}
+
+	case reflect.Struct:
+
+		if seen[t] {
+			return map[string]interface{}{"type": "object"}
+		}
+
+		seen[t] = true
+		defer delete(seen, t)
+
+		properties := map[string]interface{}{}
+		plan := planOf(t)
+
+		for _, fp := range plan.fields {
+			properties[string(fp.key.Value.(ast.String))] = schemaOfSeen(t.FieldByIndex(fp.index).Type, seen)
+		}
+
+		return map[string]interface{}{"type": "object", "properties": properties}
 	}
 
+	return map[string]interface{}{}
 }
@@ -0,0 +1,177 @@
+package licpol
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/stretchr/testify/assert"
+)
+
+const scopePolicy = `
+package licpol.scope
+
+default decision = {"allow": false, "reasons": ["scope simulator missing"]}
+
+decision = {"allow": true} {
+	scopes := split(input.sc.oidc.scope, " ")
+	scopes[_] == "simulator"
+}`
+
+func newScopePDP(t *testing.T) *PDP {
+
+	pctx := New().
+		RegisterModule("licpol.scope", scopePolicy).
+		CompileModuleSet("scope", "licpol.scope")
+
+	pdp := NewPDP("data.licpol.scope.decision", pctx, "scope", nil)
+
+	assert.Equal(t, nil, pdp.Register("path/to/MyFunc2", PEPRegistration{
+		Function:   MyFunc2,
+		Parameters: []string{"ms"},
+		Returns:    []string{"output"},
+	}))
+
+	return pdp
+}
+
+func scopeMessage(scope string) PDPMessage {
+
+	return PDPMessage{
+		Type:   "invoke",
+		Method: []string{"path", "to", "MyFunc2"},
+		SecurityContext: map[string]interface{}{
+			"oidc": map[string]interface{}{"scope": scope},
+		},
+		Body: map[string]interface{}{
+			"ms": map[string]interface{}{"Name": "Nisse", "Age": 17},
+		},
+	}
+}
+
+func TestPDPDecide(t *testing.T) {
+
+	pdp := newScopePDP(t)
+
+	decision, err := pdp.Decide(context.Background(), scopeMessage("ui simulator"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, decision.Allowed)
+	assert.NotEqual(t, "", decision.ID)
+
+	decision, err = pdp.Decide(context.Background(), scopeMessage("ui"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, decision.Allowed)
+	assert.Equal(t, []string{"scope simulator missing"}, decision.Reasons)
+
+	msg := scopeMessage("ui")
+	msg.Method = []string{"not", "registered"}
+
+	_, err = pdp.Decide(context.Background(), msg)
+	assert.True(t, errors.Is(err, ErrUnknownMethod))
+}
+
+func TestPDPRegistrationMetadata(t *testing.T) {
+
+	pdp := newScopePDP(t)
+
+	methods := pdp.Methods()
+	assert.Equal(t, 1, len(methods))
+	assert.Equal(t, []string{"path", "to", "MyFunc2"}, methods[0].Method)
+	assert.Equal(t, "data.licpol.scope.decision", methods[0].Query)
+	assert.Equal(t, "licpol.MyStruct", methods[0].Parameters[0].Type)
+	assert.Equal(t, map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"Name": map[string]interface{}{"type": "string"},
+			"Age":  map[string]interface{}{"type": "integer"},
+		},
+	}, methods[0].Parameters[0].Schema)
+
+	assert.NotEqual(t, nil, pdp.Register("path/to/MyFunc2", PEPRegistration{Parameters: []string{"ms"}}))
+}
+
+func TestPEPUsePDPHooksOnce(t *testing.T) {
+
+	pdp := newScopePDP(t)
+	remote := NewRemotePDP("http://localhost", nil)
+
+	pep := NewPolicyEnforcementPoint(
+		map[string]PEPRegistration{
+			"path/to/MyFunc2": {
+				Function:   MyFunc2,
+				Parameters: []string{"ms"},
+				Returns:    []string{"output"},
+			},
+			"path/to/MyFunc": {
+				Function:   MyFunc,
+				Parameters: []string{"name", "dir"},
+				Returns:    []string{"output"},
+			},
+		})
+
+	hooks := len(pdp.onchange)
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, nil, pep.UsePDP(pdp))
+		assert.Equal(t, nil, pep.UsePDP(remote))
+	}
+
+	assert.Equal(t, hooks+1, len(pdp.onchange))
+
+	// no query to register the functions with
+	empty := NewPDPWithCompiler("", ast.NewCompiler(), nil)
+
+	assert.NotEqual(t, nil, pep.UsePDP(empty))
+	assert.Equal(t, remote, pep.decider)
+}
+
+func TestPDPOverHTTP(t *testing.T) {
+
+	server := httptest.NewServer(newScopePDP(t))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	assert.Equal(t, nil, err)
+
+	var methods []PDPMethod
+	assert.Equal(t, nil, json.NewDecoder(resp.Body).Decode(&methods))
+	resp.Body.Close()
+
+	assert.Equal(t, 1, len(methods))
+
+	remote := NewRemotePDP(server.URL, nil)
+
+	decision, err := remote.Decide(context.Background(), scopeMessage("simulator"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, decision.Allowed)
+
+	pep := NewPolicyEnforcementPoint(
+		map[string]PEPRegistration{
+			"path/to/MyFunc2": {
+				Function:   MyFunc2,
+				Parameters: []string{"ms"},
+				Returns:    []string{"output"},
+			},
+		})
+
+	assert.Equal(t, nil, pep.UsePDP(remote))
+
+	assert.Equal(t, nil, pep.SetSecurityContext(map[string]interface{}{
+		"oidc": map[string]interface{}{"scope": "ui"},
+	}))
+
+	invoke := pep.CheckInvoke("path/to/MyFunc2", MyStruct{Name: "Nisse", Age: 17})
+	assert.Equal(t, false, invoke.Allowed())
+	assert.True(t, errors.Is(invoke.Error(), ErrDenied))
+	assert.True(t, strings.Contains(invoke.Error().Error(), "scope simulator missing"))
+
+	resp, err = http.Post(server.URL, "application/json", strings.NewReader(`{"type": "invoke", "method": ["nope"]}`))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp.Body.Close()
+}
@@ -0,0 +1,133 @@
+package licpol
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// maxPDPMessageSize is the maximum size of a `PDPMessage` accepted over _HTTP_.
+const maxPDPMessageSize = 1 << 20
+
+// ServeHTTP makes the `PDP` mountable as a `http.Handler`.
+//
+// A _POST_ with a `PDPMessage` as _JSON_ body is answered with a `Decision` as _JSON_.
+// A _GET_ returns all registered methods as a _JSON_ array of `PDPMethod`.
+//
+// .Example Usage
+// [source,go]
+// ....
+// http.Handle("/v1/pdp", pdp)
+// ....
+func (pdp *PDP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, pdp.Methods())
+
+	case http.MethodPost:
+
+		var msg PDPMessage
+
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxPDPMessageSize)).Decode(&msg); err != nil {
+			http.Error(w, fmt.Sprintf("invalid pdp message: %s", err), http.StatusBadRequest)
+			return
+		}
+
+		decision, err := pdp.Decide(r.Context(), msg)
+
+		if errors.Is(err, ErrUnknownMethod) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, decision)
+
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	json.NewEncoder(w).Encode(v)
+}
+
+// RemotePDP is a `Decider` that calls a `PDP` mounted behind a _HTTP_ endpoint.
+type RemotePDP struct {
+	url    string
+	client *http.Client
+}
+
+// NewRemotePDP creates a `Decider` that _POSTs_ to the _url_. If _client_ is `nil`,
+// the `http.DefaultClient` is used.
+func NewRemotePDP(url string, client *http.Client) *RemotePDP {
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &RemotePDP{
+		url:    url,
+		client: client,
+	}
+}
+
+// Decide posts the _msg_ to the remote _PDP_ and returns it's decision.
+func (rp *RemotePDP) Decide(c context.Context, msg PDPMessage) (Decision, error) {
+
+	data, err := json.Marshal(&msg)
+
+	if err != nil {
+		return Decision{}, err
+	}
+
+	req, err := http.NewRequestWithContext(c, http.MethodPost, rp.url, bytes.NewReader(data))
+
+	if err != nil {
+		return Decision{}, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := rp.client.Do(req)
+
+	if err != nil {
+		return Decision{}, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		err := fmt.Errorf("remote pdp returned %s: %s", resp.Status, bytes.TrimSpace(body))
+
+		if resp.StatusCode == http.StatusNotFound {
+			err = fmt.Errorf("%w: %s", ErrUnknownMethod, err)
+		}
+
+		return Decision{}, err
+	}
+
+	var decision Decision
+
+	if err := json.NewDecoder(resp.Body).Decode(&decision); err != nil {
+		return Decision{}, err
+	}
+
+	return decision, nil
+}
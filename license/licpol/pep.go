@@ -1,16 +1,40 @@
 package licpol
 
 import (
-	"encoding/json"
+	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"reflect"
 	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/storage"
+)
+
+// ErrDenied is returned (wrapped) when the _PDP_ did not allow the invocation.
+var ErrDenied = errors.New("denied by policy")
+
+var (
+	inputTypeKey   = ast.StringTerm("type")
+	inputMethodKey = ast.StringTerm("method")
+	inputSCKey     = ast.StringTerm("sc")
+	inputBodyKey   = ast.StringTerm("body")
+	inputInvoke    = ast.StringTerm("invoke")
 )
 
 type PEPInvoke interface {
 	GetParams() []interface{}
 	GetMethod() []string
 	GetFunction() reflect.Value
+	// Allowed returns `true` if the _PDP_ allowed the invocation.
+	Allowed() bool
+	// GetDecision returns the _PDP_ decision.
+	GetDecision() Decision
+	// Error returns a error if the decision failed or if not allowed (`ErrDenied`).
+	Error() error
 }
 
 type PEPReturn interface {
@@ -26,8 +50,14 @@ type PEPRegistration struct {
 	Parameters []string
 	Returns    []string
 	Function   interface{}
+	// Query is the _rego_ query that decides if the function may be invoked, e.g.
+	// "data.licpol.allow". If omitted, the query set in `PEP.UsePolicy` is used.
+	Query      string
 	v          reflect.Value
 	method     []string
+	path       string
+	methodTerm *ast.Term
+	paramTerms []*ast.Term
 	wrapper    interface{}
 }
 
@@ -36,9 +66,11 @@ type PEPRegistration struct {
 // In this way the implementation do not need to instantiate two structs
 // when invoke + return.
 type pepmsg struct {
-	reg    *PEPRegistration
-	params []interface{}
-	ret    []interface{}
+	reg      *PEPRegistration
+	params   []interface{}
+	ret      []interface{}
+	decision Decision
+	err      error
 }
 
 func (pmsg *pepmsg) GetParams() []interface{} {
@@ -53,6 +85,15 @@ func (pmsg *pepmsg) GetFunction() reflect.Value {
 func (pmsg *pepmsg) GetReturn() []interface{} {
 	return pmsg.ret
 }
+func (pmsg *pepmsg) Allowed() bool {
+	return pmsg.decision.Allowed
+}
+func (pmsg *pepmsg) GetDecision() Decision {
+	return pmsg.decision
+}
+func (pmsg *pepmsg) Error() error {
+	return pmsg.err
+}
 
 type PolicyEnforcementPoint interface {
 	// CheckInvoke will check if it can be invoked or not.
@@ -65,12 +106,26 @@ type PolicyEnforcementPoint interface {
 	// all PEP processing.
 	//
 	// CAUTION: This function uses reflection and is *much slower* than invoking
-	// the function directly!
+	// the function directly! Use the typed wrappers such as `Wrap2` instead.
 	Wrapper(method string) interface{}
 }
 
 type PEP struct {
-	funcs map[string]PEPRegistration
+	funcs   map[string]PEPRegistration
+	pdp     *PDP
+	decider Decider
+	cache   *DecisionCache
+	logger  *DecisionLogger
+	sc      atomic.Value
+	hooked  map[*PDP]bool
+}
+
+// securityContext is the converted security context and it's canonical hash
+// that is used when caching decisions.
+type securityContext struct {
+	sc   map[string]interface{}
+	term *ast.Term
+	hash [sha256.Size]byte
 }
 
 // NewPolicyEnforcementPoint creates a new _PEP_ which supports the provided functions.
@@ -86,7 +141,8 @@ func NewPolicyEnforcementPointWithWrapper(
 	createWrapper bool) *PEP {
 
 	pep := &PEP{
-		funcs: map[string]PEPRegistration{},
+		funcs:  map[string]PEPRegistration{},
+		hooked: map[*PDP]bool{},
 	}
 
 	for method, registration := range functions {
@@ -121,8 +177,22 @@ func NewPolicyEnforcementPointWithWrapper(
 
 		}
 
+		registration.path = method
 		registration.method = strings.Split(method, "/")
 
+		segments := make([]*ast.Term, len(registration.method))
+
+		for i, segment := range registration.method {
+			segments[i] = ast.StringTerm(segment)
+		}
+
+		registration.methodTerm = ast.ArrayTerm(segments...)
+		registration.paramTerms = make([]*ast.Term, len(registration.Parameters))
+
+		for i, name := range registration.Parameters {
+			registration.paramTerms[i] = ast.StringTerm(name)
+		}
+
 		if createWrapper {
 
 			registration.wrapper = reflect.MakeFunc(rf, func(in []reflect.Value) []reflect.Value {
@@ -135,7 +205,11 @@ func NewPolicyEnforcementPointWithWrapper(
 
 				result := pep.CheckInvoke(method, prm...)
 
-				// TODO: handle rejection or filtering of parameters
+				if err := result.Error(); err != nil {
+					panic(err)
+				}
+
+				// TODO: handle filtering of parameters
 
 				// TODO: If partial resolved policy, the function need to accept
 				// TODO: PEPInvoke as second param (CbContext as first param).
@@ -158,8 +232,9 @@ func NewPolicyEnforcementPointWithWrapper(
 				return out
 			}).Interface()
 
-			pep.funcs[method] = registration
 		}
+
+		pep.funcs[method] = registration
 	}
 
 	return pep
@@ -183,34 +258,306 @@ func (pep *PEP) CheckInvoke(method string, prm ...interface{}) PEPInvoke {
 		panic(fmt.Sprintf("not part of this PEP, method: %s", method))
 	}
 
-	pmsg := &pepmsg{
-		reg:    &registration,
-		params: prm,
-		ret:    nil,
+	return pep.checkInvoke(&registration, prm)
+
+}
+
+// UsePolicy makes the _PEP_ evaluate a decision for each invocation using the _compiler_
+// and _store_. The _query_ is used for all registrations that do not specify a
+// `PEPRegistration.Query`. The _store_ is optional.
+//
+// This is the same as `UsePDP(NewPDPWithCompiler(query, compiler, store))` but panics if
+// the functions can not be registered, e.g. when no _query_ is set.
+func (pep *PEP) UsePolicy(query string, compiler *ast.Compiler, store storage.Store) *PEP {
+
+	if err := pep.UsePDP(NewPDPWithCompiler(query, compiler, store)); err != nil {
+		panic(err)
 	}
 
-	msg := PDPMessage{
-		Type:   "invoke",
-		Method: pmsg.reg.method,
-		Body:   map[string]interface{}{},
+	return pep
+}
+
+// UsePolicyContext is same as `UsePolicy` but resolves the _compiled_ module set from the
+// _pctx_. Whenever the compiled module set is replaced in _pctx_, all prepared queries
+// are re-prepared and the decision cache, if any, is invalidated.
+//
+// This is the same as `UsePDP(NewPDP(query, pctx, compiled, store))` but panics if the
+// functions can not be registered, e.g. when no _query_ is set.
+func (pep *PEP) UsePolicyContext(
+	query string,
+	pctx PolicyContext,
+	compiled string,
+	store storage.Store) *PEP {
+
+	if err := pep.UsePDP(NewPDP(query, pctx, compiled, store)); err != nil {
+		panic(err)
 	}
 
-	for i, name := range registration.Parameters {
-		msg.Body[name] = prm[i]
+	return pep
+}
+
+// UsePDP makes the _PEP_ ask the _decider_ for a decision on each invocation.
+//
+// If the _decider_ is a in-process `*PDP`, all functions in this _PEP_ that are not
+// yet registered in the _PDP_ are registered and the decisions are evaluated without
+// creating any `PDPMessage`. Otherwise, e.g. `RemotePDP`, a `PDPMessage` is created
+// for each decision.
+//
+// If a function can not be registered in the `*PDP`, the error is returned and the
+// current decider is kept. The decider may be replaced, but not while the _PEP_ is in use.
+func (pep *PEP) UsePDP(decider Decider) error {
+
+	pdp, ok := decider.(*PDP)
+
+	if !ok {
+		pep.decider, pep.pdp = decider, nil
+		return nil
+	}
+
+	for method, registration := range pep.funcs {
+
+		if pdp.hasMethod(method) {
+			continue
+		}
+
+		if err := pdp.Register(method, registration); err != nil {
+			return err
+		}
+
+	}
+
+	if !pep.hooked[pdp] {
+
+		pep.hooked[pdp] = true
+
+		pdp.OnChange(func() {
+
+			if pep.cache != nil && pep.pdp == pdp {
+				pep.cache.Invalidate()
+			}
+
+		})
+
 	}
 
-	json, err := json.Marshal(&msg)
+	pep.decider, pep.pdp = pdp, pdp
+	return nil
+}
+
+// UseDecisionCache enables caching of decisions. Only successful decisions are cached.
+//
+// Set the cache before the _PEP_ is in use.
+func (pep *PEP) UseDecisionCache(cache *DecisionCache) *PEP {
+
+	pep.cache = cache
+	return pep
+}
+
+// UseDecisionLogger makes the _PEP_ log all decisions it enforces, including those
+// served from the decision cache.
+//
+// Set the logger before the _PEP_ is in use.
+func (pep *PEP) UseDecisionLogger(logger *DecisionLogger) *PEP {
+
+	pep.logger = logger
+	return pep
+}
+
+// SetSecurityContext sets the security context, e.g. the license claims, that is
+// passed as "sc" to the _PDP_ on each invocation. It is converted and hashed once
+// and may be replaced at any time.
+func (pep *PEP) SetSecurityContext(sc map[string]interface{}) error {
+
+	v, err := ToValue(sc)
+
 	if err != nil {
-		panic(err)
+		return err
+	}
+
+	pep.sc.Store(&securityContext{
+		sc:   sc,
+		term: ast.NewTerm(v),
+		hash: canonicalHash(v),
+	})
+
+	return nil
+}
+
+// checkInvoke is the `CheckInvoke` implementation when the registration
+// already has been resolved, e.g. by a typed wrapper.
+func (pep *PEP) checkInvoke(registration *PEPRegistration, prm []interface{}) PEPInvoke {
+
+	pmsg := &pepmsg{
+		reg:      registration,
+		params:   prm,
+		ret:      nil,
+		decision: Decision{Allowed: true},
+	}
+
+	if pep.decider == nil {
+		return pmsg
+	}
+
+	sc, _ := pep.sc.Load().(*securityContext)
+	body, err := pep.body(registration, prm)
+
+	if err != nil {
+		pmsg.decision, pmsg.err = Decision{}, err
+		return pmsg
 	}
 
-	fmt.Println("Feeding JSON to PDP")
-	fmt.Println(string(json))
+	var key decisionKey
+	var generation uint64
+	var started time.Time
+
+	if pep.logger != nil {
+		started = time.Now()
+	}
+
+	if pep.cache != nil {
+
+		key = decisionKey{method: registration.path, body: canonicalHash(body)}
+
+		if sc != nil {
+			key.sc = sc.hash
+		}
+
+		var hit bool
+		if pmsg.decision, hit, generation = pep.cache.get(key); hit {
+
+			if pep.logger != nil {
+
+				pmsg.decision.ID = newDecisionID()
+				pep.log(registration, sc, body, pmsg, started, true)
 
-	// TODO: feed to PDP and process the result into pmsg
+			}
+
+			return pep.denied(pmsg)
+		}
+
+	}
+
+	pmsg.decision, pmsg.err = pep.decide(context.Background(), registration, sc, body, prm)
+
+	if pmsg.err == nil && pep.cache != nil {
+		pep.cache.put(key, pmsg.decision, generation)
+	}
+
+	if pep.logger != nil {
+		pep.log(registration, sc, body, pmsg, started, false)
+	}
+
+	return pep.denied(pmsg)
+
+}
+
+// log writes the decision to the decision logger.
+func (pep *PEP) log(
+	registration *PEPRegistration,
+	sc *securityContext,
+	body ast.Object,
+	pmsg *pepmsg,
+	started time.Time,
+	cached bool) {
+
+	query, revision := registration.Query, ""
+
+	if pep.pdp != nil {
+		query, revision = pep.pdp.queryOf(registration.path), pep.pdp.Revision()
+	}
+
+	pep.logger.log(
+		registration.path, query, revision,
+		pep.input(registration, sc, body),
+		pmsg.decision, pmsg.err, started,
+		map[string]interface{}{"cache_hit": cached},
+	)
+}
+
+// denied sets the `ErrDenied` error if the decision did not allow the invocation.
+func (pep *PEP) denied(pmsg *pepmsg) *pepmsg {
+
+	if pmsg.err != nil || pmsg.decision.Allowed {
+		return pmsg
+	}
+
+	if len(pmsg.decision.Reasons) > 0 {
+
+		pmsg.err = fmt.Errorf(
+			"%w, method: %s reasons: %s",
+			ErrDenied, pmsg.reg.path, strings.Join(pmsg.decision.Reasons, ", "),
+		)
+
+	} else {
+		pmsg.err = fmt.Errorf("%w, method: %s", ErrDenied, pmsg.reg.path)
+	}
 
 	return pmsg
+}
+
+// body converts the parameters into the _PDP_ body.
+func (pep *PEP) body(registration *PEPRegistration, prm []interface{}) (ast.Object, error) {
+
+	body := ast.NewObject()
+
+	for i, key := range registration.paramTerms {
+
+		v, err := ToValue(prm[i])
+
+		if err != nil {
+			return nil, fmt.Errorf("parameter %s: %w", registration.Parameters[i], err)
+		}
+
+		body.Insert(key, ast.NewTerm(v))
+	}
+
+	return body, nil
+}
+
+// input creates the _PDP_ input, i.e. the `PDPMessage` but as a `ast.Value`.
+func (pep *PEP) input(registration *PEPRegistration, sc *securityContext, body ast.Object) ast.Value {
+
+	input := ast.NewObject(
+		[2]*ast.Term{inputTypeKey, inputInvoke},
+		[2]*ast.Term{inputMethodKey, registration.methodTerm},
+		[2]*ast.Term{inputBodyKey, ast.NewTerm(body)},
+	)
+
+	if sc != nil {
+		input.Insert(inputSCKey, sc.term)
+	}
+
+	return input
+}
+
+// decide asks the _PDP_ for a decision. The in-process _PDP_ gets the input as
+// `ast.Value` whereas other `Decider` gets a `PDPMessage`.
+func (pep *PEP) decide(
+	c context.Context,
+	registration *PEPRegistration,
+	sc *securityContext,
+	body ast.Object,
+	prm []interface{}) (Decision, error) {
+
+	if pep.pdp != nil {
+		return pep.pdp.DecideValue(c, registration.path, pep.input(registration, sc, body))
+	}
+
+	msg := PDPMessage{
+		Type:   "invoke",
+		Method: registration.method,
+		Body:   make(map[string]interface{}, len(prm)),
+	}
+
+	if sc != nil {
+		msg.SecurityContext = sc.sc
+	}
+
+	for i, name := range registration.Parameters {
+		msg.Body[name] = prm[i]
+	}
 
+	return pep.decider.Decide(c, msg)
 }
 
 func (pep *PEP) CheckReturn(invoke PEPInvoke, out ...interface{}) PEPReturn {
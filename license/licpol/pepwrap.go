@@ -0,0 +1,222 @@
+package licpol
+
+import (
+	"fmt"
+)
+
+// typedRegistration resolves the registration for _method_ and asserts that the
+// registered function is of type _F_. The registered function is returned and is the
+// function that the wrapper invokes.
+//
+// This is only done once, when the wrapper is created, hence no reflection is
+// done when the wrapper is invoked.
+func typedRegistration[F any](pep *PEP, method string) (*PEPRegistration, F) {
+
+	registration, ok := pep.funcs[method]
+
+	if !ok {
+		panic(fmt.Sprintf("not part of this PEP, method: %s", method))
+	}
+
+	f, ok := registration.Function.(F)
+
+	if !ok {
+
+		var wrapper F
+
+		panic(
+			fmt.Sprintf(
+				"function signature mismatch, method: %s registered: %T wrapper: %T",
+				method, registration.Function, wrapper,
+			),
+		)
+
+	}
+
+	return &registration, f
+}
+
+// Wrap0 creates a typed wrapper around a registered function that takes no parameters.
+//
+// The wrapper do all PEP processing, as `PolicyEnforcementPoint.Wrapper`, but without
+// any reflection when invoked. It panics if the _method_ is not registered or the
+// registered function is not of the wrapper type.
+//
+// If the _PDP_ do not allow the invocation, the wrapper panics with a error wrapping
+// `ErrDenied`. Use the `E` variants, e.g. `Wrap0E`, to get the error returned instead.
+func Wrap0[R any](pep *PEP, method string) func() R {
+
+	registration, f := typedRegistration[func() R](pep, method)
+
+	return func() R {
+
+		invoke := pep.checkInvoke(registration, nil)
+
+		if err := invoke.Error(); err != nil {
+			panic(err)
+		}
+
+		r := f()
+
+		pep.CheckReturn(invoke, r)
+		return r
+	}
+}
+
+// Wrap1 creates a typed wrapper around a registered function that takes one parameter.
+//
+// .Example Usage
+// [source,go]
+// ....
+// fn := licpol.Wrap1[MyStruct, string](pep, "path/to/MyFunc2") // <1>
+// res := fn(MyStruct{Name: "Nisse", Age: 17}) // <2>
+// ....
+// <1> Resolved and verified once
+// <2> Invoked through the _PEP_ without any reflection
+func Wrap1[A, R any](pep *PEP, method string) func(A) R {
+
+	registration, f := typedRegistration[func(A) R](pep, method)
+
+	return func(a A) R {
+
+		invoke := pep.checkInvoke(registration, []interface{}{a})
+
+		if err := invoke.Error(); err != nil {
+			panic(err)
+		}
+
+		r := f(a)
+
+		pep.CheckReturn(invoke, r)
+		return r
+	}
+}
+
+// Wrap2 creates a typed wrapper around a registered function that takes two parameters.
+func Wrap2[A, B, R any](pep *PEP, method string) func(A, B) R {
+
+	registration, f := typedRegistration[func(A, B) R](pep, method)
+
+	return func(a A, b B) R {
+
+		invoke := pep.checkInvoke(registration, []interface{}{a, b})
+
+		if err := invoke.Error(); err != nil {
+			panic(err)
+		}
+
+		r := f(a, b)
+
+		pep.CheckReturn(invoke, r)
+		return r
+	}
+}
+
+// Wrap3 creates a typed wrapper around a registered function that takes three parameters.
+func Wrap3[A, B, C, R any](pep *PEP, method string) func(A, B, C) R {
+
+	registration, f := typedRegistration[func(A, B, C) R](pep, method)
+
+	return func(a A, b B, c C) R {
+
+		invoke := pep.checkInvoke(registration, []interface{}{a, b, c})
+
+		if err := invoke.Error(); err != nil {
+			panic(err)
+		}
+
+		r := f(a, b, c)
+
+		pep.CheckReturn(invoke, r)
+		return r
+	}
+}
+
+// Wrap0E is same as `Wrap0` but for functions that do return an `error` as
+// the last return value. If the _PDP_ do not allow the invocation, the error
+// is returned and the registered function is never invoked.
+func Wrap0E[R any](pep *PEP, method string) func() (R, error) {
+
+	registration, f := typedRegistration[func() (R, error)](pep, method)
+
+	return func() (R, error) {
+
+		invoke := pep.checkInvoke(registration, nil)
+
+		if err := invoke.Error(); err != nil {
+			var r R
+			return r, err
+		}
+
+		r, err := f()
+
+		pep.CheckReturn(invoke, r, err)
+		return r, err
+	}
+}
+
+// Wrap1E is same as `Wrap1` but for functions that do return an `error` as
+// the last return value.
+func Wrap1E[A, R any](pep *PEP, method string) func(A) (R, error) {
+
+	registration, f := typedRegistration[func(A) (R, error)](pep, method)
+
+	return func(a A) (R, error) {
+
+		invoke := pep.checkInvoke(registration, []interface{}{a})
+
+		if err := invoke.Error(); err != nil {
+			var r R
+			return r, err
+		}
+
+		r, err := f(a)
+
+		pep.CheckReturn(invoke, r, err)
+		return r, err
+	}
+}
+
+// Wrap2E is same as `Wrap2` but for functions that do return an `error` as
+// the last return value.
+func Wrap2E[A, B, R any](pep *PEP, method string) func(A, B) (R, error) {
+
+	registration, f := typedRegistration[func(A, B) (R, error)](pep, method)
+
+	return func(a A, b B) (R, error) {
+
+		invoke := pep.checkInvoke(registration, []interface{}{a, b})
+
+		if err := invoke.Error(); err != nil {
+			var r R
+			return r, err
+		}
+
+		r, err := f(a, b)
+
+		pep.CheckReturn(invoke, r, err)
+		return r, err
+	}
+}
+
+// Wrap3E is same as `Wrap3` but for functions that do return an `error` as
+// the last return value.
+func Wrap3E[A, B, C, R any](pep *PEP, method string) func(A, B, C) (R, error) {
+
+	registration, f := typedRegistration[func(A, B, C) (R, error)](pep, method)
+
+	return func(a A, b B, c C) (R, error) {
+
+		invoke := pep.checkInvoke(registration, []interface{}{a, b, c})
+
+		if err := invoke.Error(); err != nil {
+			var r R
+			return r, err
+		}
+
+		r, err := f(a, b, c)
+
+		pep.CheckReturn(invoke, r, err)
+		return r, err
+	}
+}
@@ -0,0 +1,112 @@
+package licpol
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTypedPrimitiveParams(t *testing.T) {
+
+	pep := NewPolicyEnforcementPoint(
+		map[string]PEPRegistration{
+			"path/to/MyFunc": {
+				Function:   MyFunc,
+				Parameters: []string{"name", "dir"},
+				Returns:    []string{"output"},
+			},
+		})
+
+	res := Wrap2[string, string, string](pep, "path/to/MyFunc")("kalle", "kobra")
+
+	assert.Equal(t, "kalle-kobra", res)
+}
+
+func TestTypedStructParam(t *testing.T) {
+
+	pep := NewPolicyEnforcementPoint(
+		map[string]PEPRegistration{
+			"path/to/MyFunc2": {
+				Function:   MyFunc2,
+				Parameters: []string{"ms"},
+				Returns:    []string{"output"},
+			},
+		})
+
+	res := Wrap1[MyStruct, string](pep, "path/to/MyFunc2")(
+		MyStruct{
+			Name:   "Nisse",
+			Age:    17,
+			secret: "shh"},
+	)
+
+	assert.Equal(t, "Nisse, 17 (shh)", res)
+}
+
+func TestTypedErrorReturn(t *testing.T) {
+
+	f := func(name string) (string, error) {
+		return "", fmt.Errorf("no such name %s", name)
+	}
+
+	pep := NewPolicyEnforcementPoint(
+		map[string]PEPRegistration{
+			"path/to/f": {
+				Function:   f,
+				Parameters: []string{"name"},
+				Returns:    []string{"output", "err"},
+			},
+		})
+
+	_, err := Wrap1E[string, string](pep, "path/to/f")("kalle")
+
+	assert.EqualError(t, err, "no such name kalle")
+}
+
+func TestTypedSignatureMismatchPanics(t *testing.T) {
+
+	pep := NewPolicyEnforcementPoint(
+		map[string]PEPRegistration{
+			"path/to/MyFunc": {
+				Function:   MyFunc,
+				Parameters: []string{"name", "dir"},
+				Returns:    []string{"output"},
+			},
+		})
+
+	assert.Panics(t, func() {
+		Wrap1[string, string](pep, "path/to/MyFunc")
+	})
+
+	assert.Panics(t, func() {
+		Wrap2E[string, string, string](pep, "path/to/MyFunc")
+	})
+
+	assert.Panics(t, func() {
+		Wrap2[string, string, string](pep, "path/to/NotRegistered")
+	})
+}
+
+func BenchmarkTypedWrapInvoke(t *testing.B) {
+
+	f := func(ms MyStruct) string { return "" }
+
+	pep := NewPolicyEnforcementPoint(
+		map[string]PEPRegistration{
+			"path/to/MyFunc2": {
+				Function:   f,
+				Parameters: []string{"ms"},
+				Returns:    []string{"output"},
+			},
+		})
+
+	prm := MyStruct{Name: "Nisse", Age: 17, secret: "shh"}
+	wrapped := Wrap1[MyStruct, string](pep, "path/to/MyFunc2")
+
+	t.ResetTimer()
+
+	for i := 0; i < t.N; i++ {
+		wrapped(prm)
+	}
+}
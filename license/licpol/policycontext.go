@@ -3,9 +3,14 @@ package licpol
 import (
 	"context"
 	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
 
+	"github.com/mariotoffia/gojwtlic/license"
 	"github.com/open-policy-agent/opa/ast"
 	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/storage"
 )
 
 // tag::prp[]
@@ -18,6 +23,11 @@ const (
 	PolicyRetrievalPointChangeModuleAdded PolicyRetrievalPointChange = 1
 	// PolicyRetrievalPointChangeModuleRemovedis is set when a module has been removed from the set.
 	PolicyRetrievalPointChangeModuleRemoved PolicyRetrievalPointChange = 2
+	// PolicyRetrievalPointChangeModuleUpdated is set when the contents of a module has changed.
+	PolicyRetrievalPointChangeModuleUpdated PolicyRetrievalPointChange = 3
+	// PolicyRetrievalPointChangeDataUpdated is set when the data documents of a
+	// `PolicyDataRetrievalPoint` has changed. The module name is empty.
+	PolicyRetrievalPointChangeDataUpdated PolicyRetrievalPointChange = 4
 )
 
 // PolicyRetrievalPointChangeFunc is invoked by a _PRP_ when a underlying change has occurred.
@@ -84,14 +94,63 @@ type PolicyRetrievalPoint interface {
 	Process(c context.Context)
 }
 
+// PolicyDataRetrievalPoint is a `PolicyRetrievalPoint` that also serves data documents
+// along with the policies, e.g. a _OPA_ bundle.
+type PolicyDataRetrievalPoint interface {
+	PolicyRetrievalPoint
+
+	// GetData returns the data documents, rooted at _data_. The returned map must not be
+	// mutated.
+	GetData(c context.Context) map[string]interface{}
+}
+
 // end::prp[]
 // tag::policy-context[]
 
+// PolicyModuleOrigin is where a module in the effective module set of a `PolicyContext`
+// is resolved from.
+type PolicyModuleOrigin int
+
+const (
+	// PolicyModuleOriginLocal is set when the module is registered in the context itself.
+	PolicyModuleOriginLocal PolicyModuleOrigin = 1
+	// PolicyModuleOriginParent is set when the module is registered in a parent context.
+	PolicyModuleOriginParent PolicyModuleOrigin = 2
+	// PolicyModuleOriginPRP is set when the module is served by a `PolicyRetrievalPoint`.
+	PolicyModuleOriginPRP PolicyModuleOrigin = 3
+)
+
+// PolicyModuleInfo describes a module in the effective module set of a `PolicyContext`.
+type PolicyModuleInfo struct {
+	// Name is the name of the module.
+	Name string
+	// Origin is where the module is resolved from.
+	Origin PolicyModuleOrigin
+	// Depth is the number of parent contexts traversed to find the module, i.e. zero
+	// for this context.
+	Depth int
+	// PRP is the `PolicyRetrievalPoint` serving the module when `PolicyModuleOriginPRP`.
+	PRP PolicyRetrievalPoint
+	// Shadows is `true` when a module with the same name exists further up the parent
+	// chain or in a _PRP_ but is overridden by this one.
+	Shadows bool
+}
+
+// PolicyContextChangeFunc is invoked by a `PolicyContext` when a compiled module set
+// has been created or replaced.
+type PolicyContextChangeFunc func(pc PolicyContext, compiled string)
+
 // PolicyContext is a context where polices operates under. It supports
 // the notion of sub-context and hence local overrides in e.g policies may
 // be registered. However, it will traverse the through parent until e.g.
 // a policy that is searched for and hence all policies are reachable even
 // on leafs.
+//
+// A `PolicyContext` is safe for concurrent use. The registration functions keeps the
+// builder style error state, whereas `Policy` and `Process`, that are used while serving,
+// returns the error per call. A compiled module set is replaced atomically, hence a
+// `Policy` caller always gets a consistent compilation even if a _PRP_ reloads modules
+// concurrently.
 type PolicyContext interface {
 	// ClearError will clear any error state.
 	ClearError() PolicyContext
@@ -102,8 +161,18 @@ type PolicyContext interface {
 	// If the current instance is set to error state, it will return the current instance
 	// without creating a sub-context.
 	CreateSubContext() PolicyContext
+	// Release detaches this sub-context from its parent such that the parent no longer
+	// recompiles, invalidates nor notifies it. Use it when a sub-context, e.g. per tenant,
+	// is discarded. It is a no-op on a root context or when already released.
+	Release()
 	// Parent is the parent context. If root context it will return `nil`
 	Parent() PolicyContext
+	// Modules returns the effective module set, sorted by name, and where each module is
+	// resolved from when compiled in this context.
+	//
+	// A module registered in this context shadows a module with the same name in any parent
+	// context, that in turn shadows modules served by _PRPs_.
+	Modules(c context.Context) []PolicyModuleInfo
 	// RegisterModules will register modules that may be used to create compiles.
 	//
 	// If a already module is registered it will skip it an register the rest and put
@@ -117,8 +186,21 @@ type PolicyContext interface {
 	// RegisterPRP registers one or more `PolicyRetrievalPoint`.
 	//
 	// All `PolicyRetrievalPoint.Initialize` function will be invoked and must not been invoked earlier!
+	//
+	// Modules that are not registered in this context, nor any parent context, are looked up
+	// in the _PRPs_ in registration order. When a _PRP_ notifies a change, all compiled module
+	// sets that uses the module are recompiled by the notifying _PRP_, i.e. not by any
+	// `Policy` caller.
 	RegisterPRP(c context.Context, p ...PolicyRetrievalPoint) PolicyContext
 
+	// Process invokes `PolicyRetrievalPoint.Process` on all registered _PRPs_ such that _PRPs_ that
+	// cannot run in background gets a chance to detect changes. Any compiled module set that
+	// failed to recompile earlier is retried.
+	//
+	// If any compiled module set fails to recompile, the previous compilation is kept and the
+	// error is returned.
+	Process(c context.Context) error
+
 	// CompleModuleSet will lookup modules that has been earlier registered using
 	// `RegisterModules` and create a single compilation of it. Since it is named,
 	// it will set the `PolicyContext` to error state if already compiled. The compiles may
@@ -127,79 +209,253 @@ type PolicyContext interface {
 	// If it is a sub policy and the _name_ do not exist in the sub-policy, it will register
 	// it there and hence _override_ the parent compile. If any of the modules specified do not
 	// exist, it will not compile and set the context into error state.
+	//
+	// The modules are resolved as in `Modules`, hence a sub-context may override a module
+	// in the parent, e.g. a tenant specific license policy, and compile it using the same
+	// module set as the parent.
 	CompileModuleSet(name string, module ...string) PolicyContext
-	// Policy will return the policy earlier compiled using `CompileModuleSet`. If it fails
-	// is will return nil and set an error state.
-	Policy(name string) *ast.Compiler
-	// NewEval is *exactly* the same as `rego.New()` but gives the `PolicyContext` the ability to
-	// do custom processing.
-	NewEval(options ...func(r *rego.Rego)) *rego.Rego
+	// Policy will return the policy earlier compiled using `CompileModuleSet`. If not compiled
+	// in this context, the parent chain is searched. If not found an error is returned. It
+	// never alters the error state of the context.
+	Policy(name string) (*ast.Compiler, error)
+	// NewEval is the same as `rego.New()` but attaches the store and all custom built-in
+	// functions of the context before the _options_. Hence an option, e.g. `rego.Store`, overrides
+	// the one attached by the context.
+	//
+	// Use `Evaluate` to attach the compiled module set as well. It never alters the error
+	// state of the context.
+	NewEval(options ...func(r *rego.Rego)) (*rego.Rego, error)
+	// Evaluate evaluates the _query_ using the _compiled_ module set, the store, the custom
+	// built-in functions and the license data of this context. If _input_ is a `ast.Value`
+	// it is used as is.
+	//
+	// .Example Usage
+	// [source,go]
+	// ....
+	// rs, err := pctx.Evaluate(ctx, "test-module", "data.licpol.testing.allow_create", input)
+	// ....
+	Evaluate(c context.Context, compiled, query string, input interface{}) (rego.ResultSet, error)
+	// UseStore sets the data store used when evaluating. If not set in this, or any parent
+	// context, a in-memory store is created from `Data` and the license.
+	//
+	// The store is shared with all sub-contexts that do not set a store of their own.
+	UseStore(store storage.Store) PolicyContext
+	// RegisterBuiltins registers custom built-in functions that are declared when compiling
+	// and attached when evaluating in this and all sub-contexts. Hence register them before
+	// `CompileModuleSet`.
+	//
+	// If any of the _builtins_ is already registered, none is registered and an error is
+	// returned. It never alters the error state of the context.
+	RegisterBuiltins(builtins ...PolicyBuiltin) error
+	// SetLicense makes the license available as _data.license_ in this and all sub-contexts
+	// that do not set a license of their own.
+	//
+	// If a store is set using `UseStore` in this context, the license is written to the
+	// store. Otherwise it is layered on the store shared by a parent context, hence
+	// sub-contexts sharing a store may have different licenses.
+	SetLicense(c context.Context, info *license.FeatureInfo) error
+	// RunTests runs all _test__ rules in the effective module set, see `Modules`, using the
+	// store and license data of the context. See `RunPolicyTests`.
+	//
+	// An error is returned if a module name is served by more than one _PRP_ in the same
+	// context, since only the first would be tested.
+	RunTests(c context.Context, coverage bool) (*PolicyTestReport, error)
+	// Data merges the data documents of all registered `PolicyDataRetrievalPoint`, parent
+	// context first. A later _PRP_ overrides top level keys of earlier ones.
+	Data(c context.Context) map[string]interface{}
+	// OnChange registers a function that is invoked each time a compiled module set is
+	// created or replaced in this context, or in a parent context when not overridden
+	// by this context.
+	OnChange(f PolicyContextChangeFunc) PolicyContext
 }
 
 // end::policy-context[]
 
 // policyContext implements the `PolicyContext` interface.
+//
+// The _mu_ guards all fields but _compiled_ that is a copy-on-write map, i.e. it is
+// never mutated once stored, hence readers never lock. All compilations are serialized
+// using _compileMu_ but is done without holding _mu_.
 type policyContext struct {
-	err      error
-	parent   *policyContext
-	modules  map[string]string
-	compiled map[string]*ast.Compiler
-	prp      []PolicyRetrievalPoint
+	mu        sync.RWMutex
+	compileMu sync.Mutex
+	err       error
+	parent    *policyContext
+	modules   map[string]string
+	compiled  atomic.Value // map[string]*ast.Compiler
+	prp       []PolicyRetrievalPoint
+	onchange  []PolicyContextChangeFunc
+	sources   map[string][]string
+	dirty     map[string]bool
+	children  []*policyContext
+	store     storage.Store
+	builtins  map[string]PolicyBuiltin
+	license   map[string]interface{}
+
+	defaultStore storage.Store
+	storeGen     uint64
 }
 
 // New creates a new `PolicyContext` compatible instance.
 func New() PolicyContext {
+	return newPolicyContext(nil)
+}
 
-	return &policyContext{
+// newPolicyContext creates a new, fully initialized, context with _parent_.
+func newPolicyContext(parent *policyContext) *policyContext {
+
+	pc := &policyContext{
+		parent:   parent,
 		modules:  map[string]string{},
-		compiled: map[string]*ast.Compiler{},
+		sources:  map[string][]string{},
+		dirty:    map[string]bool{},
+		builtins: map[string]PolicyBuiltin{},
 	}
+
+	pc.compiled.Store(map[string]*ast.Compiler{})
+	return pc
 }
 
+// RegisterPRP registers one or more `PolicyRetrievalPoint` and initializes them.
 func (pc *policyContext) RegisterPRP(c context.Context, p ...PolicyRetrievalPoint) PolicyContext {
 
 	for i := range p {
 
-		p[i].Initialize(c, nil)
+		p[i].Initialize(c, pc.prpChanged)
 
 	}
 
+	pc.mu.Lock()
 	pc.prp = append(pc.prp, p...)
+	pc.mu.Unlock()
+
 	return pc
 }
 
+// Process invokes `PolicyRetrievalPoint.Process` on all registered _PRPs_ and retries
+// all dirty compiled module sets.
+func (pc *policyContext) Process(c context.Context) error {
+
+	for _, p := range pc.prps() {
+		p.Process(c)
+	}
+
+	return pc.recompileDirty()
+}
+
 // ClearError will clear any error state.
 func (pc *policyContext) ClearError() PolicyContext {
+
+	pc.mu.Lock()
 	pc.err = nil
+	pc.mu.Unlock()
+
 	return pc
 }
 
 // Error returns the error state in this `PolicyContext`, if any.
 func (pc *policyContext) Error() error {
+
+	pc.mu.RLock()
+	defer pc.mu.RUnlock()
+
 	return pc.err
 }
 
 // CreateSubContext will create a sub-context and puts the current as Parent.
 func (pc *policyContext) CreateSubContext() PolicyContext {
 
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
 	if pc.err != nil {
 		return pc
 	}
 
-	return &policyContext{
-		parent: pc,
+	sub := newPolicyContext(pc)
+	pc.children = append(pc.children, sub)
+	return sub
+
+}
+
+// Release detaches this sub-context from its parent.
+func (pc *policyContext) Release() {
+
+	if pc.parent == nil {
+		return
 	}
 
+	pc.parent.mu.Lock()
+	defer pc.parent.mu.Unlock()
+
+	for i, child := range pc.parent.children {
+
+		if child == pc {
+			pc.parent.children = append(pc.parent.children[:i], pc.parent.children[i+1:]...)
+			break
+		}
+
+	}
 }
 
 // Parent is the parent context. If root context it will return `nil`
 func (pc *policyContext) Parent() PolicyContext {
+
+	if pc.parent == nil {
+		return nil
+	}
+
 	return pc.parent
 }
 
+// Modules returns the effective module set and where each module is resolved from.
+func (pc *policyContext) Modules(c context.Context) []PolicyModuleInfo {
+
+	names := map[string]bool{}
+
+	for ctx := pc; ctx != nil; ctx = ctx.parent {
+
+		ctx.mu.RLock()
+
+		for name := range ctx.modules {
+			names[name] = true
+		}
+
+		ctx.mu.RUnlock()
+
+		for _, p := range ctx.prps() {
+
+			for _, name := range p.GetModuleNames(c, false) {
+				names[name] = true
+			}
+
+		}
+
+	}
+
+	modules := make([]PolicyModuleInfo, 0, len(names))
+
+	for name := range names {
+
+		if _, info, ok := pc.resolveModule(c, name); ok {
+			modules = append(modules, info)
+		}
+
+	}
+
+	sort.Slice(modules, func(i, j int) bool {
+		return modules[i].Name < modules[j].Name
+	})
+
+	return modules
+}
+
 // Registers modules that may be used to create compiles.
 func (pc *policyContext) RegisterModules(modules map[string]string) PolicyContext {
 
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
 	if pc.err != nil {
 		return pc
 	}
@@ -220,6 +476,9 @@ func (pc *policyContext) RegisterModules(modules map[string]string) PolicyContex
 // Registers modules that may be used to create compiles.
 func (pc *policyContext) RegisterModule(name, module string) PolicyContext {
 
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
 	if pc.err != nil {
 		return pc
 	}
@@ -242,85 +501,359 @@ func (pc *policyContext) RegisterModule(name, module string) PolicyContext {
 // it there and hence _override_ the parent compile.
 func (pc *policyContext) CompileModuleSet(name string, module ...string) PolicyContext {
 
-	if pc.err != nil {
+	if pc.Error() != nil {
 		return pc
 	}
 
-	if _, ok := pc.compiled[name]; ok {
-		pc.err = fmt.Errorf("compiled policy %s already present", name)
+	if _, ok := pc.compiledSets()[name]; ok {
+		pc.setError(fmt.Errorf("compiled policy %s already present", name))
+		return pc
 	}
 
-	m := map[string]string{}
-	for _, mod := range module {
+	if err := pc.compile(name, module); err != nil {
+		pc.setError(err)
+	}
 
-		if v, ok := pc.modules[mod]; ok {
-			m[mod] = v
-		} else {
+	return pc
+}
 
-			v := pc.getModuleFromParent(mod)
+// Policy will return the policy earlier compiled using `CompileModuleSet` in this or
+// any parent context.
+func (pc *policyContext) Policy(name string) (*ast.Compiler, error) {
 
-			if v != "" {
-				m[mod] = v
-			} else {
-				pc.err = fmt.Errorf("could not find module %s while compiling", mod)
-				return nil
-			}
+	for ctx := pc; ctx != nil; ctx = ctx.parent {
 
+		if comp, ok := ctx.compiledSets()[name]; ok {
+			return comp, nil
 		}
 
 	}
 
-	comp, err := ast.CompileModules(m)
+	return nil, fmt.Errorf("compiled policy %s do not exist", name)
+}
+
+// NewEval is the same as `rego.New()` but attaches the store and all custom built-in
+// functions before the _options_.
+func (pc *policyContext) NewEval(options ...func(r *rego.Rego)) (*rego.Rego, error) {
+
+	opts, err := pc.evalOptions(context.Background())
 
 	if err != nil {
-		pc.err = err
-	} else {
-		pc.compiled[name] = comp
+		return nil, err
 	}
 
+	return rego.New(append(opts, options...)...), nil
+}
+
+// Data merges the data documents of all registered `PolicyDataRetrievalPoint`, parent
+// context first. A later _PRP_ overrides top level keys of earlier ones.
+func (pc *policyContext) Data(c context.Context) map[string]interface{} {
+
+	data := map[string]interface{}{}
+
+	if pc.parent != nil {
+		data = pc.parent.Data(c)
+	}
+
+	for _, p := range pc.prps() {
+
+		if dp, ok := p.(PolicyDataRetrievalPoint); ok {
+
+			for k, v := range dp.GetData(c) {
+				data[k] = v
+			}
+
+		}
+
+	}
+
+	return data
+}
+
+// OnChange registers a function that is invoked each time a compiled module set is
+// created or replaced in this context.
+func (pc *policyContext) OnChange(f PolicyContextChangeFunc) PolicyContext {
+
+	pc.mu.Lock()
+	pc.onchange = append(pc.onchange, f)
+	pc.mu.Unlock()
+
 	return pc
 }
 
-// Policy will return the policy earlier compiled using `CompileModuleSet`. If it fails
-// is will return nil and set an error state.
-func (pc *policyContext) Policy(name string) *ast.Compiler {
+// setError sets the error state.
+func (pc *policyContext) setError(err error) {
 
-	if pc.err != nil {
-		return nil
+	pc.mu.Lock()
+	pc.err = err
+	pc.mu.Unlock()
+}
+
+// compiledSets returns the current, immutable, compiled sets.
+func (pc *policyContext) compiledSets() map[string]*ast.Compiler {
+
+	compiled, _ := pc.compiled.Load().(map[string]*ast.Compiler)
+	return compiled
+}
+
+// prps returns a snapshot of the registered _PRPs_.
+func (pc *policyContext) prps() []PolicyRetrievalPoint {
+
+	pc.mu.RLock()
+	defer pc.mu.RUnlock()
+
+	return append([]PolicyRetrievalPoint{}, pc.prp...)
+}
+
+// compile resolves the _modules_ using `lookupModule` and compiles them into the
+// _name_ set. On success, the set is swapped in, no longer dirty and all `OnChange`
+// functions are notified.
+func (pc *policyContext) compile(name string, modules []string) error {
+
+	pc.compileMu.Lock()
+
+	m := map[string]string{}
+
+	for _, mod := range modules {
+
+		v := pc.lookupModule(context.Background(), mod)
+
+		if v == "" {
+			pc.compileMu.Unlock()
+			return fmt.Errorf("could not find module %s while compiling", mod)
+		}
+
+		m[mod] = v
 	}
 
-	if comp, ok := pc.compiled[name]; ok {
-		return comp
+	comp, err := pc.compileModules(m)
+
+	if err != nil {
+		pc.compileMu.Unlock()
+		return err
 	}
 
-	pc.err = fmt.Errorf("compiled policy %s do not exist", name)
+	old := pc.compiledSets()
+	compiled := make(map[string]*ast.Compiler, len(old)+1)
+
+	for k, v := range old {
+		compiled[k] = v
+	}
+
+	compiled[name] = comp
+	pc.compiled.Store(compiled)
+
+	pc.mu.Lock()
+	pc.sources[name] = modules
+	delete(pc.dirty, name)
+	pc.mu.Unlock()
+
+	pc.compileMu.Unlock()
+
+	pc.notify(name)
 	return nil
+}
+
+// lookupModule resolves a module as in `resolveModule`. If not found an empty string
+// is returned.
+func (pc *policyContext) lookupModule(c context.Context, module string) string {
 
+	v, _, _ := pc.resolveModule(c, module)
+	return v
 }
 
-// NewEval is *exactly* the same as `rego.New()`.
-func (pc *policyContext) NewEval(options ...func(r *rego.Rego)) *rego.Rego {
+// resolveModule resolves a module from the local modules, the parent chain and lastly
+// from the _PRPs_ in registration order, starting with this context and then the
+// parent chain.
+func (pc *policyContext) resolveModule(c context.Context, module string) (string, PolicyModuleInfo, bool) {
+
+	info := PolicyModuleInfo{Name: module}
+	found := ""
+
+	for ctx, depth := pc, 0; ctx != nil; ctx, depth = ctx.parent, depth+1 {
+
+		ctx.mu.RLock()
+		v, ok := ctx.modules[module]
+		ctx.mu.RUnlock()
+
+		if !ok {
+			continue
+		}
+
+		if info.Origin != 0 {
+			info.Shadows = true
+			break
+		}
+
+		found, info.Depth, info.Origin = v, depth, PolicyModuleOriginParent
+
+		if depth == 0 {
+			info.Origin = PolicyModuleOriginLocal
+		}
 
-	if pc.err != nil {
-		return nil
 	}
 
-	return rego.New(options...)
+	for ctx, depth := pc, 0; ctx != nil && !info.Shadows; ctx, depth = ctx.parent, depth+1 {
+
+		for _, p := range ctx.prps() {
+
+			v := p.GetModule(c, module)
+
+			if v == "" {
+				continue
+			}
+
+			if info.Origin != 0 {
+				info.Shadows = true
+				break
+			}
+
+			found, info.Depth, info.Origin, info.PRP = v, depth, PolicyModuleOriginPRP, p
+		}
+
+	}
+
+	return found, info, info.Origin != 0
 }
 
-// getModuleFromParent searches the whole parent chain for a module.
+// prpChanged is the `PolicyRetrievalPointChangeFunc` given to all registered _PRPs_.
 //
-// If not found an empty string is returned.
-func (pc *policyContext) getModuleFromParent(module string) string {
+// All compiled module sets, in this and all sub-contexts, that uses the _module_ are
+// recompiled. A set that fails to recompile stays dirty and is retried on next change
+// or `Process`. A change in data documents do not need a recompile but all compiled
+// sets are notified since earlier decisions may be stale.
+func (pc *policyContext) prpChanged(p PolicyRetrievalPoint, module string, change PolicyRetrievalPointChange) {
+
+	if change == PolicyRetrievalPointChangeDataUpdated {
+		pc.invalidateStore()
+		pc.notifyAll()
+		return
+	}
+
+	pc.markDirty(module)
+	pc.recompileDirty()
+}
+
+// markDirty marks all compiled sets that uses _module_ as dirty in this and all sub-contexts.
+func (pc *policyContext) markDirty(module string) {
 
-	parent := pc.parent
-	if parent == nil {
-		return ""
+	pc.mu.Lock()
+
+	for name, modules := range pc.sources {
+
+		for _, m := range modules {
+
+			if m == module {
+				pc.dirty[name] = true
+				break
+			}
+
+		}
+
+	}
+
+	children := append([]*policyContext{}, pc.children...)
+	pc.mu.Unlock()
+
+	for _, child := range children {
+		child.markDirty(module)
+	}
+}
+
+// recompileDirty recompiles all dirty compiled sets in this and all sub-contexts. A set
+// that fails to compile keeps the previous compilation and stays dirty. The first error
+// is returned.
+func (pc *policyContext) recompileDirty() error {
+
+	pc.mu.RLock()
+
+	dirty := map[string][]string{}
+
+	for name := range pc.dirty {
+		dirty[name] = pc.sources[name]
+	}
+
+	children := append([]*policyContext{}, pc.children...)
+	pc.mu.RUnlock()
+
+	var first error
+
+	for name, modules := range dirty {
+
+		if err := pc.compile(name, modules); err != nil && first == nil {
+			first = fmt.Errorf("recompile of %s failed: %w", name, err)
+		}
+
+	}
+
+	for _, child := range children {
+
+		if err := child.recompileDirty(); err != nil && first == nil {
+			first = err
+		}
+
+	}
+
+	return first
+}
+
+// notify invokes all registered `PolicyContextChangeFunc` for the _compiled_ set.
+func (pc *policyContext) notify(compiled string) {
+
+	pc.mu.RLock()
+	onchange := append([]PolicyContextChangeFunc{}, pc.onchange...)
+	children := append([]*policyContext{}, pc.children...)
+	pc.mu.RUnlock()
+
+	for _, f := range onchange {
+		f(pc, compiled)
 	}
 
-	if v, ok := parent.modules[module]; ok {
-		return v
+	for _, child := range children {
+
+		if _, ok := child.compiledSets()[compiled]; !ok {
+			child.notify(compiled)
+		}
+
 	}
 
-	return parent.getModuleFromParent(module)
+}
+
+// notifyAll notifies all compiled sets, including the inherited ones, in this and all
+// sub-contexts. It walks the tree once and hands the set names down to each child, i.e.
+// unlike `notify` it never recurses through `notify`.
+func (pc *policyContext) notifyAll() {
+	pc.notifySets(nil)
+}
+
+// notifySets invokes all registered `PolicyContextChangeFunc` for the _inherited_ and the
+// local compiled sets and then does the same for all sub-contexts.
+func (pc *policyContext) notifySets(inherited map[string]bool) {
+
+	names := map[string]bool{}
+
+	for name := range inherited {
+		names[name] = true
+	}
+
+	for name := range pc.compiledSets() {
+		names[name] = true
+	}
+
+	pc.mu.RLock()
+	onchange := append([]PolicyContextChangeFunc{}, pc.onchange...)
+	children := append([]*policyContext{}, pc.children...)
+	pc.mu.RUnlock()
+
+	for name := range names {
+
+		for _, f := range onchange {
+			f(pc, name)
+		}
+
+	}
+
+	for _, child := range children {
+		child.notifySets(names)
+	}
 }
@@ -0,0 +1,302 @@
+package licpol
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicyContextResolvesModulesFromPRP(t *testing.T) {
+
+	root := t.TempDir()
+	writeModule(t, root, "licpol/test.rego", agePolicy, time.Now().Add(-time.Hour))
+
+	prp, err := NewFilesystemPRP(root, 0)
+	assert.Equal(t, nil, err)
+
+	pctx := New().
+		RegisterModule("local.rego", "package local").
+		RegisterPRP(context.Background(), prp).
+		CompileModuleSet("age", "licpol/test.rego", "local.rego")
+
+	assert.Equal(t, nil, pctx.Error())
+
+	compiler, err := pctx.Policy("age")
+	assert.Equal(t, nil, err)
+	assert.NotNil(t, compiler)
+
+	_, err = pctx.Policy("nope")
+	assert.NotEqual(t, nil, err)
+	assert.Equal(t, nil, pctx.Error())
+
+	pctx.CompileModuleSet("missing", "licpol/missing.rego")
+	assert.NotEqual(t, nil, pctx.Error())
+}
+
+func TestPolicyContextRecompilesOnPRPChange(t *testing.T) {
+
+	root := t.TempDir()
+	writeModule(t, root, "licpol/test.rego", agePolicy, time.Now().Add(-time.Hour))
+
+	prp, err := NewFilesystemPRP(root, 0)
+	assert.Equal(t, nil, err)
+
+	pctx := New().
+		RegisterPRP(context.Background(), prp).
+		CompileModuleSet("age", "licpol/test.rego")
+
+	var changed []string
+	pctx.OnChange(func(pc PolicyContext, compiled string) {
+		changed = append(changed, compiled)
+	})
+
+	pep := NewPolicyEnforcementPoint(
+		map[string]PEPRegistration{
+			"path/to/MyFunc2": {
+				Function:   MyFunc2,
+				Parameters: []string{"ms"},
+				Returns:    []string{"output"},
+			},
+		}).
+		UsePolicyContext("data.licpol.test.allow", pctx, "age", nil).
+		UseDecisionCache(NewDecisionCache(10, time.Minute))
+
+	assert.Equal(t, false, pep.CheckInvoke("path/to/MyFunc2", MyStruct{Name: "Nisse", Age: 17}).Allowed())
+
+	// nothing changed
+	assert.Equal(t, nil, pctx.Process(context.Background()))
+	assert.Equal(t, 0, len(changed))
+
+	writeModule(t, root, "licpol/test.rego", strings.Replace(agePolicy, ">= 18", ">= 16", 1), time.Now())
+
+	assert.Equal(t, nil, pctx.Process(context.Background()))
+	assert.Equal(t, []string{"age"}, changed)
+	assert.Equal(t, true, pep.CheckInvoke("path/to/MyFunc2", MyStruct{Name: "Nisse", Age: 17}).Allowed())
+
+	// a broken module keeps the last good compilation
+	writeModule(t, root, "licpol/test.rego", "package licpol.test\n\nallow {", time.Now().Add(time.Minute))
+
+	assert.NotEqual(t, nil, pctx.Process(context.Background()))
+	assert.Equal(t, []string{"age"}, changed)
+	assert.Equal(t, true, pep.CheckInvoke("path/to/MyFunc2", MyStruct{Name: "Nisse", Age: 17}).Allowed())
+}
+
+// TestPolicyContextConcurrentReload is intended to be run with the race detector, i.e.
+// go test -race.
+func TestPolicyContextConcurrentReload(t *testing.T) {
+
+	root := t.TempDir()
+	then := time.Now().Add(-time.Hour)
+
+	writeModule(t, root, "licpol/test.rego", agePolicy, then)
+
+	prp, err := NewFilesystemPRP(root, 0)
+	assert.Equal(t, nil, err)
+
+	pctx := New().
+		RegisterPRP(context.Background(), prp).
+		CompileModuleSet("age", "licpol/test.rego")
+
+	pep := NewPolicyEnforcementPoint(
+		map[string]PEPRegistration{
+			"path/to/MyFunc2": {
+				Function:   MyFunc2,
+				Parameters: []string{"ms"},
+				Returns:    []string{"output"},
+			},
+		}).
+		UsePolicyContext("data.licpol.test.allow", pctx, "age", nil).
+		UseDecisionCache(NewDecisionCache(10, time.Minute))
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+
+	for i := 0; i < 4; i++ {
+
+		wg.Add(1)
+
+		go func() {
+
+			defer wg.Done()
+
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				compiler, err := pctx.Policy("age")
+				assert.Equal(t, nil, err)
+				assert.NotNil(t, compiler)
+
+				invoke := pep.CheckInvoke("path/to/MyFunc2", MyStruct{Name: "Nisse", Age: 17})
+				assert.NotEqual(t, nil, invoke)
+			}
+
+		}()
+
+	}
+
+	for i := 0; i < 20; i++ {
+
+		limit := 16 + i%4
+		writeModule(t, root, "licpol/test.rego",
+			strings.Replace(agePolicy, ">= 18", fmt.Sprintf(">= %d", limit), 1), then.Add(time.Duration(i+1)*time.Second))
+
+		assert.Equal(t, nil, pctx.Process(context.Background()))
+	}
+
+	close(done)
+	wg.Wait()
+
+	// the last written limit is 19
+	assert.Equal(t, false, pep.CheckInvoke("path/to/MyFunc2", MyStruct{Name: "Nisse", Age: 18}).Allowed())
+}
+
+func TestSubContextOverridesParent(t *testing.T) {
+
+	root := t.TempDir()
+	writeModule(t, root, "licpol/other.rego", "package licpol.other", time.Now().Add(-time.Hour))
+
+	prp, err := NewFilesystemPRP(root, 0)
+	assert.Equal(t, nil, err)
+
+	base := New().
+		RegisterModule("licpol.test", agePolicy).
+		RegisterPRP(context.Background(), prp).
+		CompileModuleSet("age", "licpol.test").
+		CompileModuleSet("other", "licpol/other.rego")
+
+	assert.Equal(t, nil, base.Error())
+	assert.Nil(t, base.Parent())
+
+	tenant := base.CreateSubContext().
+		RegisterModule("licpol.test", strings.Replace(agePolicy, ">= 18", ">= 16", 1)).
+		RegisterModule("licpol.tenant", "package licpol.tenant").
+		CompileModuleSet("age", "licpol.test")
+
+	assert.Equal(t, nil, tenant.Error())
+	assert.Equal(t, base, tenant.Parent())
+
+	baseAge, _ := base.Policy("age")
+	tenantAge, _ := tenant.Policy("age")
+	assert.NotSame(t, baseAge, tenantAge)
+
+	baseOther, _ := base.Policy("other")
+	tenantOther, err := tenant.Policy("other")
+	assert.Equal(t, nil, err)
+	assert.Same(t, baseOther, tenantOther)
+
+	assert.Equal(t, []PolicyModuleInfo{
+		{Name: "licpol.tenant", Origin: PolicyModuleOriginLocal},
+		{Name: "licpol.test", Origin: PolicyModuleOriginLocal, Shadows: true},
+		{Name: "licpol/other.rego", Origin: PolicyModuleOriginPRP, Depth: 1, PRP: prp},
+	}, tenant.Modules(context.Background()))
+
+	registration := map[string]PEPRegistration{
+		"path/to/MyFunc2": {
+			Function:   MyFunc2,
+			Parameters: []string{"ms"},
+			Returns:    []string{"output"},
+		},
+	}
+
+	basePEP := NewPolicyEnforcementPoint(registration).UsePolicyContext("data.licpol.test.allow", base, "age", nil)
+	tenantPEP := NewPolicyEnforcementPoint(registration).UsePolicyContext("data.licpol.test.allow", tenant, "age", nil)
+
+	assert.Equal(t, false, basePEP.CheckInvoke("path/to/MyFunc2", MyStruct{Name: "Nisse", Age: 17}).Allowed())
+	assert.Equal(t, true, tenantPEP.CheckInvoke("path/to/MyFunc2", MyStruct{Name: "Nisse", Age: 17}).Allowed())
+}
+
+func TestSubContextNotifiedOnParentRecompile(t *testing.T) {
+
+	root := t.TempDir()
+	writeModule(t, root, "licpol/other.rego", "package licpol.other", time.Now().Add(-time.Hour))
+
+	prp, err := NewFilesystemPRP(root, 0)
+	assert.Equal(t, nil, err)
+
+	base := New().
+		RegisterPRP(context.Background(), prp).
+		CompileModuleSet("other", "licpol/other.rego")
+
+	var changed []string
+
+	base.CreateSubContext().OnChange(func(pc PolicyContext, compiled string) {
+		changed = append(changed, compiled)
+	})
+
+	writeModule(t, root, "licpol/other.rego", "package licpol.other\n\nx = 1", time.Now())
+
+	assert.Equal(t, nil, base.Process(context.Background()))
+	assert.Equal(t, []string{"other"}, changed)
+}
+
+func TestNotifyAllNotifiesEachSetOncePerContext(t *testing.T) {
+
+	base := New().
+		RegisterModule("licpol.test", agePolicy).
+		CompileModuleSet("age", "licpol.test")
+
+	tenant := base.CreateSubContext().
+		RegisterModule("licpol.tenant", "package licpol.tenant").
+		CompileModuleSet("tenant", "licpol.tenant")
+
+	user := tenant.CreateSubContext()
+
+	changed := map[string]int{}
+	record := func(name string) PolicyContextChangeFunc {
+		return func(pc PolicyContext, compiled string) {
+			changed[name+":"+compiled]++
+		}
+	}
+
+	base.OnChange(record("base"))
+	tenant.OnChange(record("tenant"))
+	user.OnChange(record("user"))
+
+	base.(*policyContext).prpChanged(nil, "", PolicyRetrievalPointChangeDataUpdated)
+
+	assert.Equal(t, map[string]int{
+		"base:age":      1,
+		"tenant:age":    1,
+		"tenant:tenant": 1,
+		"user:age":      1,
+		"user:tenant":   1,
+	}, changed)
+}
+
+func TestReleasedSubContextIsDetached(t *testing.T) {
+
+	base := New().
+		RegisterModule("licpol.test", agePolicy).
+		CompileModuleSet("age", "licpol.test")
+
+	tenant := base.CreateSubContext()
+	other := base.CreateSubContext()
+
+	var changed []string
+
+	tenant.OnChange(func(pc PolicyContext, compiled string) {
+		changed = append(changed, compiled)
+	})
+
+	tenant.Release()
+	tenant.Release()
+	base.Release()
+
+	assert.Equal(t, []*policyContext{other.(*policyContext)}, base.(*policyContext).children)
+
+	base.CompileModuleSet("again", "licpol.test")
+	assert.Equal(t, nil, base.Error())
+	assert.Empty(t, changed)
+
+	_, err := tenant.Policy("age")
+	assert.Equal(t, nil, err)
+}
@@ -0,0 +1,386 @@
+package licpol
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/mariotoffia/gojwtlic/license"
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/storage/inmem"
+	"github.com/open-policy-agent/opa/util"
+)
+
+// PolicyBuiltin is a custom built-in function that is made available to all policies
+// compiled and evaluated in a `PolicyContext`.
+type PolicyBuiltin struct {
+	// Decl is the name and type declaration of the function.
+	Decl *rego.Function
+	// Impl is the implementation of the function.
+	Impl rego.BuiltinDyn
+}
+
+// UseStore sets the data store that is attached by `Evaluate` and `NewEval`.
+func (pc *policyContext) UseStore(store storage.Store) PolicyContext {
+
+	pc.mu.Lock()
+	pc.store = store
+	pc.mu.Unlock()
+
+	return pc
+}
+
+// RegisterBuiltins registers custom built-in functions.
+func (pc *policyContext) RegisterBuiltins(builtins ...PolicyBuiltin) error {
+
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	names := map[string]bool{}
+
+	for _, b := range builtins {
+
+		if _, ok := pc.builtins[b.Decl.Name]; ok || names[b.Decl.Name] {
+			return fmt.Errorf("builtin %s already registered", b.Decl.Name)
+		}
+
+		names[b.Decl.Name] = true
+	}
+
+	for _, b := range builtins {
+		pc.builtins[b.Decl.Name] = b
+	}
+
+	return nil
+}
+
+// SetLicense sets the license that is available as _data.license_.
+func (pc *policyContext) SetLicense(c context.Context, info *license.FeatureInfo) error {
+
+	data, err := json.Marshal(info)
+
+	if err != nil {
+		return err
+	}
+
+	var lic map[string]interface{}
+
+	if err := util.UnmarshalJSON(data, &lic); err != nil {
+		return err
+	}
+
+	pc.mu.Lock()
+	pc.license = lic
+	store := pc.store
+	pc.mu.Unlock()
+
+	if store != nil {
+
+		if err := storage.WriteOne(c, store, storage.AddOp, storage.Path{"license"}, lic); err != nil {
+			return err
+		}
+
+	}
+
+	pc.invalidateStore()
+	return nil
+}
+
+// Evaluate evaluates the _query_ using the _compiled_ module set, the store, the custom
+// built-in functions and the license data of this context.
+func (pc *policyContext) Evaluate(
+	c context.Context,
+	compiled, query string,
+	input interface{}) (rego.ResultSet, error) {
+
+	compiler, err := pc.Policy(compiled)
+
+	if err != nil {
+		return nil, err
+	}
+
+	options, err := pc.evalOptions(c)
+
+	if err != nil {
+		return nil, err
+	}
+
+	options = append(options, rego.Compiler(compiler), rego.Query(query))
+
+	if v, ok := input.(ast.Value); ok {
+		options = append(options, rego.ParsedInput(v))
+	} else if input != nil {
+		options = append(options, rego.Input(input))
+	}
+
+	return rego.New(options...).Eval(c)
+}
+
+// evalOptions returns the store and all built-in functions as options.
+func (pc *policyContext) evalOptions(c context.Context) ([]func(r *rego.Rego), error) {
+
+	store, err := pc.effectiveStore(c)
+
+	if err != nil {
+		return nil, err
+	}
+
+	options := []func(r *rego.Rego){rego.Store(store)}
+
+	for _, b := range pc.effectiveBuiltins() {
+		options = append(options, rego.FunctionDyn(b.Decl, b.Impl))
+	}
+
+	return options, nil
+}
+
+// effectiveBuiltins returns all built-in functions in the parent chain where a sub-context
+// overrides a parent built-in function with the same name.
+func (pc *policyContext) effectiveBuiltins() map[string]PolicyBuiltin {
+
+	builtins := map[string]PolicyBuiltin{}
+
+	if pc.parent != nil {
+		builtins = pc.parent.effectiveBuiltins()
+	}
+
+	pc.mu.RLock()
+	defer pc.mu.RUnlock()
+
+	for name, b := range pc.builtins {
+		builtins[name] = b
+	}
+
+	return builtins
+}
+
+// compileModules parses and compiles the _modules_ with all built-in functions declared.
+func (pc *policyContext) compileModules(modules map[string]string) (*ast.Compiler, error) {
+
+	parsed := make(map[string]*ast.Module, len(modules))
+
+	for name, module := range modules {
+
+		m, err := ast.ParseModule(name, module)
+
+		if err != nil {
+			return nil, err
+		}
+
+		parsed[name] = m
+	}
+
+	decls := map[string]*ast.Builtin{}
+
+	for name, b := range pc.effectiveBuiltins() {
+		decls[name] = &ast.Builtin{Name: name, Decl: b.Decl.Decl}
+	}
+
+	compiler := ast.NewCompiler().WithBuiltins(decls)
+	compiler.Compile(parsed)
+
+	if compiler.Failed() {
+		return nil, compiler.Errors
+	}
+
+	return compiler, nil
+}
+
+// explicitStore returns the store set by `UseStore` in this or the closest parent context
+// and the license, if any, set in a context below the one that owns the store. Such a
+// license is never written to the shared store and hence needs to be layered on it.
+func (pc *policyContext) explicitStore() (storage.Store, map[string]interface{}) {
+
+	var overlay map[string]interface{}
+
+	for ctx := pc; ctx != nil; ctx = ctx.parent {
+
+		ctx.mu.RLock()
+		store, lic := ctx.store, ctx.license
+		ctx.mu.RUnlock()
+
+		if store != nil {
+			return store, overlay
+		}
+
+		if overlay == nil {
+			overlay = lic
+		}
+
+	}
+
+	return nil, nil
+}
+
+// effectiveLicense returns the license data in this or the closest parent context.
+func (pc *policyContext) effectiveLicense() map[string]interface{} {
+
+	for ctx := pc; ctx != nil; ctx = ctx.parent {
+
+		ctx.mu.RLock()
+		lic := ctx.license
+		ctx.mu.RUnlock()
+
+		if lic != nil {
+			return lic
+		}
+
+	}
+
+	return nil
+}
+
+// effectiveStore returns the explicit store, with the license of this context layered on
+// when set below the context that owns the store, or, if none, a in-memory store created from
+// the `Data` and the license. The in-memory store is kept until the data or license changes.
+func (pc *policyContext) effectiveStore(c context.Context) (storage.Store, error) {
+
+	if store, overlay := pc.explicitStore(); store != nil {
+
+		if overlay != nil {
+			return &licenseStore{Store: store, license: overlay}, nil
+		}
+
+		return store, nil
+	}
+
+	pc.mu.RLock()
+	store, gen := pc.defaultStore, pc.storeGen
+	pc.mu.RUnlock()
+
+	if store != nil {
+		return store, nil
+	}
+
+	obj := pc.Data(c)
+
+	if lic := pc.effectiveLicense(); lic != nil {
+		obj["license"] = lic
+	}
+
+	var data interface{} = obj
+
+	if err := util.RoundTrip(&data); err != nil { // never share documents with the PRPs
+		return nil, err
+	}
+
+	obj, _ = data.(map[string]interface{})
+
+	if obj == nil {
+		obj = map[string]interface{}{}
+	}
+
+	store = inmem.NewFromObject(obj)
+
+	pc.mu.Lock()
+
+	if pc.defaultStore != nil {
+		store = pc.defaultStore
+	} else if pc.storeGen == gen {
+		pc.defaultStore = store
+	}
+
+	pc.mu.Unlock()
+
+	return store, nil
+}
+
+// invalidateStore drops the in-memory store in this and all sub-contexts such that it
+// is recreated on next evaluation.
+func (pc *policyContext) invalidateStore() {
+
+	pc.mu.Lock()
+	pc.defaultStore = nil
+	pc.storeGen++
+	children := append([]*policyContext{}, pc.children...)
+	pc.mu.Unlock()
+
+	for _, child := range children {
+		child.invalidateStore()
+	}
+}
+
+// licenseStore layers a license on a shared store such that _data.license_ is read from
+// the _license_ while all other documents, and all writes, go to the shared store.
+type licenseStore struct {
+	storage.Store
+	license map[string]interface{}
+}
+
+// Read reads the _path_ from the license when below _license_ or from the shared store.
+// When the root document is read, the license replaces the one in the shared store.
+func (ls *licenseStore) Read(c context.Context, txn storage.Transaction, path storage.Path) (interface{}, error) {
+
+	if len(path) > 0 && path[0] == "license" {
+		return readDocument(ls.license, path, 1)
+	}
+
+	v, err := ls.Store.Read(c, txn, path)
+
+	if err != nil || len(path) > 0 {
+		return v, err
+	}
+
+	root, ok := v.(map[string]interface{})
+
+	if !ok {
+		return v, nil
+	}
+
+	data := make(map[string]interface{}, len(root)+1)
+
+	for k, v := range root {
+		data[k] = v
+	}
+
+	data["license"] = ls.license
+	return data, nil
+}
+
+// readDocument reads the _path_, starting at _index_, from the _doc_. If not found, a
+// storage not found error is returned.
+func readDocument(doc interface{}, path storage.Path, index int) (interface{}, error) {
+
+	for _, key := range path[index:] {
+
+		switch v := doc.(type) {
+		case map[string]interface{}:
+
+			next, ok := v[key]
+
+			if !ok {
+				return nil, documentNotFound(path)
+			}
+
+			doc = next
+
+		case []interface{}:
+
+			i, err := strconv.Atoi(key)
+
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, documentNotFound(path)
+			}
+
+			doc = v[i]
+
+		default:
+			return nil, documentNotFound(path)
+		}
+
+	}
+
+	return doc, nil
+}
+
+// documentNotFound creates the same not found error as the in-memory store.
+func documentNotFound(path storage.Path) error {
+
+	return &storage.Error{
+		Code:    storage.NotFoundErr,
+		Message: fmt.Sprintf("%v: document does not exist", path),
+	}
+}
@@ -0,0 +1,205 @@
+package licpol
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/mariotoffia/gojwtlic/license"
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/types"
+	"github.com/stretchr/testify/assert"
+)
+
+const licensePolicy = `
+package licpol.lic
+
+default allow = false
+
+allow {
+	scopes := split(data.license.scope, " ")
+	scopes[_] == input.scope
+	data.limits.max_users >= input.users
+}
+
+shout = licpol.upper(data.license.sub)`
+
+// dataPRP is a `PolicyDataRetrievalPoint` serving static modules and data.
+type dataPRP struct {
+	*EmbeddedPRP
+	data map[string]interface{}
+}
+
+func (p *dataPRP) GetData(c context.Context) map[string]interface{} {
+	return p.data
+}
+
+var upperBuiltin = PolicyBuiltin{
+	Decl: &rego.Function{
+		Name: "licpol.upper",
+		Decl: types.NewFunction(types.Args(types.S), types.S),
+	},
+	Impl: func(bctx rego.BuiltinContext, terms []*ast.Term) (*ast.Term, error) {
+		return ast.StringTerm(strings.ToUpper(string(terms[0].Value.(ast.String)))), nil
+	},
+}
+
+func newLicenseContext(t *testing.T) PolicyContext {
+
+	embedded, err := NewEmbeddedPRP(fstest.MapFS{
+		"licpol/lic.rego": {Data: []byte(licensePolicy)},
+	})
+
+	assert.Equal(t, nil, err)
+
+	prp := &dataPRP{
+		EmbeddedPRP: embedded,
+		data:        map[string]interface{}{"limits": map[string]interface{}{"max_users": 10}},
+	}
+
+	pctx := New()
+	assert.Equal(t, nil, pctx.RegisterBuiltins(upperBuiltin))
+
+	pctx.RegisterPRP(context.Background(), prp).
+		CompileModuleSet("lic", "licpol/lic.rego")
+
+	assert.Equal(t, nil, pctx.Error())
+
+	info := &license.FeatureInfo{}
+	info.WithSubject("nisse").Feature("simulator").Feature("ui")
+
+	assert.Equal(t, nil, pctx.SetLicense(context.Background(), info))
+	return pctx
+}
+
+func TestEvaluateAttachesStoreBuiltinsAndLicense(t *testing.T) {
+
+	pctx := newLicenseContext(t)
+
+	rs, err := pctx.Evaluate(context.Background(), "lic", "data.licpol.lic.allow",
+		map[string]interface{}{"scope": "ui", "users": 5})
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, rs[0].Expressions[0].Value)
+
+	rs, err = pctx.Evaluate(context.Background(), "lic", "data.licpol.lic.allow",
+		map[string]interface{}{"scope": "ui", "users": 11})
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, rs[0].Expressions[0].Value)
+
+	rs, err = pctx.Evaluate(context.Background(), "lic", "data.licpol.lic.shout", nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "NISSE", rs[0].Expressions[0].Value)
+
+	_, err = pctx.Evaluate(context.Background(), "nope", "data.licpol.lic.allow", nil)
+	assert.NotEqual(t, nil, err)
+}
+
+func TestRegisterBuiltinsDuplicate(t *testing.T) {
+
+	pctx := New()
+
+	assert.Equal(t, nil, pctx.RegisterBuiltins(upperBuiltin))
+	assert.NotEqual(t, nil, pctx.RegisterBuiltins(upperBuiltin))
+	assert.Equal(t, nil, pctx.Error())
+
+	// the sub-context may override the parent
+	assert.Equal(t, nil, pctx.CreateSubContext().RegisterBuiltins(upperBuiltin))
+}
+
+func TestEvaluateSubContextLicense(t *testing.T) {
+
+	pctx := newLicenseContext(t)
+	tenant := pctx.CreateSubContext()
+
+	info := &license.FeatureInfo{}
+	info.WithSubject("hult").Feature("simulator")
+
+	assert.Equal(t, nil, tenant.SetLicense(context.Background(), info))
+
+	input := map[string]interface{}{"scope": "ui", "users": 1}
+
+	rs, err := tenant.Evaluate(context.Background(), "lic", "data.licpol.lic.allow", input)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, rs[0].Expressions[0].Value)
+
+	rs, err = pctx.Evaluate(context.Background(), "lic", "data.licpol.lic.allow", input)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, rs[0].Expressions[0].Value)
+}
+
+func TestNewEvalUsesExplicitStore(t *testing.T) {
+
+	store := NewInMemStoreBuilder(context.Background()).
+		AddJSON("limits", `{"max_users": 1}`).
+		Build()
+
+	pctx := newLicenseContext(t).UseStore(store)
+
+	info := &license.FeatureInfo{}
+	info.Feature("ui")
+
+	assert.Equal(t, nil, pctx.SetLicense(context.Background(), info))
+
+	compiler, err := pctx.Policy("lic")
+	assert.Equal(t, nil, err)
+
+	r, err := pctx.NewEval(
+		rego.Query("data.licpol.lic.allow"),
+		rego.Compiler(compiler),
+		rego.Input(map[string]interface{}{"scope": "ui", "users": 1}),
+	)
+
+	assert.Equal(t, nil, err)
+
+	rs, err := r.Eval(context.Background())
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, rs[0].Expressions[0].Value)
+}
+
+func TestSubContextLicensesOverSharedStore(t *testing.T) {
+
+	store := NewInMemStoreBuilder(context.Background()).
+		AddJSON("limits", `{"max_users": 10}`).
+		Build()
+
+	pctx := newLicenseContext(t).UseStore(store)
+
+	ui := &license.FeatureInfo{}
+	ui.Feature("ui")
+
+	simulator := &license.FeatureInfo{}
+	simulator.Feature("simulator")
+
+	uiTenant := pctx.CreateSubContext()
+	simulatorTenant := pctx.CreateSubContext()
+
+	assert.Equal(t, nil, uiTenant.SetLicense(context.Background(), ui))
+	assert.Equal(t, nil, simulatorTenant.SetLicense(context.Background(), simulator))
+
+	input := map[string]interface{}{"scope": "ui", "users": 1}
+
+	rs, err := uiTenant.Evaluate(context.Background(), "lic", "data.licpol.lic.allow", input)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, rs[0].Expressions[0].Value)
+
+	rs, err = simulatorTenant.Evaluate(context.Background(), "lic", "data.licpol.lic.allow", input)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, rs[0].Expressions[0].Value)
+
+	rs, err = uiTenant.Evaluate(context.Background(), "lic", "data.license.scope", nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "ui", rs[0].Expressions[0].Value)
+
+	rs, err = simulatorTenant.Evaluate(context.Background(), "lic", "data", nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "simulator", rs[0].Expressions[0].Value.(map[string]interface{})["license"].(map[string]interface{})["scope"])
+
+	// the shared store is never written by the sub-contexts
+	_, err = NewStoreManager(context.Background(), store).Read(context.Background(), "/license")
+	assert.NotEqual(t, nil, err)
+}
@@ -0,0 +1,303 @@
+package licpol
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/cover"
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/storage/inmem"
+	"github.com/open-policy-agent/opa/tester"
+)
+
+// PolicyTestResult is the outcome of a single _test__ rule.
+type PolicyTestResult struct {
+	// Module is the name of the module where the test is declared.
+	Module string
+	// Package is the package of the test, e.g. "data.licpol.testing".
+	Package string
+	// Name is the name of the test rule, e.g. "test_allow_create".
+	Name string
+	// Fail is `true` when the test rule is undefined or false.
+	Fail bool
+	// Error is set when the test failed to evaluate.
+	Error error
+	// Duration is the time it took to evaluate the test.
+	Duration time.Duration
+}
+
+// Pass returns `true` if the test did neither fail nor error.
+func (r *PolicyTestResult) Pass() bool {
+	return !r.Fail && r.Error == nil
+}
+
+// PolicyTestModuleReport summarizes the tests and coverage of a single module.
+type PolicyTestModuleReport struct {
+	// Module is the name of the module.
+	Module string
+	// Passed is the number of passed tests declared in the module.
+	Passed int
+	// Failed is the number of failed tests declared in the module.
+	Failed int
+	// Errors is the number of tests declared in the module that failed to evaluate.
+	Errors int
+	// Coverage is the percentage of expressions that where evaluated by any test. It is
+	// only set when the tests are run with coverage.
+	Coverage float64
+}
+
+// PolicyTestReport is the outcome of `RunPolicyTests`.
+type PolicyTestReport struct {
+	// Results is all test results sorted by module, package and name.
+	Results []PolicyTestResult
+	// Modules is the report of all modules, sorted by name, including those without tests.
+	Modules []PolicyTestModuleReport
+	// Coverage is the total coverage percentage, if run with coverage.
+	Coverage float64
+}
+
+// Passed returns `true` if all tests passed.
+func (r *PolicyTestReport) Passed() bool {
+
+	for i := range r.Results {
+
+		if !r.Results[i].Pass() {
+			return false
+		}
+
+	}
+
+	return true
+}
+
+// Print writes a human readable report to _w_. If _verbose_ is `true` all tests are
+// written, otherwise only failed tests. If _coverage_ is `true` the coverage is written.
+func (r *PolicyTestReport) Print(w io.Writer, verbose, coverage bool) error {
+
+	passed := 0
+
+	for i := range r.Results {
+
+		res := &r.Results[i]
+
+		switch {
+		case res.Error != nil:
+			fmt.Fprintf(w, "ERROR: %s.%s (%s): %s\n", res.Package, res.Name, res.Module, res.Error)
+		case res.Fail:
+			fmt.Fprintf(w, "FAIL: %s.%s (%s)\n", res.Package, res.Name, res.Module)
+		default:
+
+			passed++
+
+			if verbose {
+				fmt.Fprintf(w, "PASS: %s.%s (%s) %s\n", res.Package, res.Name, res.Module, res.Duration)
+			}
+
+		}
+
+	}
+
+	fmt.Fprintln(w, "--------------------------------------------------------------------------------")
+
+	for _, m := range r.Modules {
+
+		if coverage {
+			fmt.Fprintf(w, "%s: pass %d fail %d error %d coverage %.1f%%\n", m.Module, m.Passed, m.Failed, m.Errors, m.Coverage)
+		} else if m.Passed+m.Failed+m.Errors > 0 {
+			fmt.Fprintf(w, "%s: pass %d fail %d error %d\n", m.Module, m.Passed, m.Failed, m.Errors)
+		}
+
+	}
+
+	status := "PASS"
+
+	if !r.Passed() {
+		status = "FAIL"
+	}
+
+	if coverage {
+		_, err := fmt.Fprintf(w, "%s: %d/%d coverage %.1f%%\n", status, passed, len(r.Results), r.Coverage)
+		return err
+	}
+
+	_, err := fmt.Fprintf(w, "%s: %d/%d\n", status, passed, len(r.Results))
+	return err
+}
+
+// RunPolicyTests compiles the _modules_ and runs all rules, in any module, that is prefixed
+// with _test__ using the _OPA_ tester. The _store_ is optional.
+//
+// Custom built-in functions are not available to the tests since the _OPA_ tester only
+// supports globally registered built-in functions.
+//
+// .Example Usage
+// [source,go]
+// ....
+// report, err := RunPolicyTests(ctx, prp.GetModules(ctx, true), nil, true)
+// report.Print(os.Stdout, false, true)
+// ....
+func RunPolicyTests(
+	c context.Context,
+	modules map[string]string,
+	store storage.Store,
+	coverage bool) (*PolicyTestReport, error) {
+
+	parsed := make(map[string]*ast.Module, len(modules))
+
+	for name, module := range modules {
+
+		m, err := ast.ParseModule(name, module)
+
+		if err != nil {
+			return nil, err
+		}
+
+		parsed[name] = m
+	}
+
+	if store == nil {
+		store = inmem.New()
+	}
+
+	runner := tester.NewRunner().
+		SetCompiler(ast.NewCompiler()).
+		SetStore(store)
+
+	var cov *cover.Cover
+
+	if coverage {
+		cov = cover.New()
+		runner.SetCoverageQueryTracer(cov)
+	}
+
+	ch, err := runner.Run(c, parsed)
+
+	if err != nil {
+		return nil, err
+	}
+
+	report := &PolicyTestReport{}
+	perModule := map[string]*PolicyTestModuleReport{}
+
+	for name := range parsed {
+		perModule[name] = &PolicyTestModuleReport{Module: name}
+	}
+
+	for tr := range ch {
+
+		res := PolicyTestResult{
+			Package:  tr.Package,
+			Name:     tr.Name,
+			Fail:     tr.Fail,
+			Error:    tr.Error,
+			Duration: tr.Duration,
+		}
+
+		if tr.Location != nil {
+			res.Module = tr.Location.File
+		}
+
+		if m, ok := perModule[res.Module]; ok {
+
+			switch {
+			case res.Error != nil:
+				m.Errors++
+			case res.Fail:
+				m.Failed++
+			default:
+				m.Passed++
+			}
+
+		}
+
+		report.Results = append(report.Results, res)
+	}
+
+	if cov != nil {
+
+		cr := cov.Report(parsed)
+		report.Coverage = cr.Coverage
+
+		for name, fr := range cr.Files {
+
+			if m, ok := perModule[name]; ok {
+				m.Coverage = fr.Coverage
+			}
+
+		}
+
+	}
+
+	sort.Slice(report.Results, func(i, j int) bool {
+
+		a, b := &report.Results[i], &report.Results[j]
+
+		if a.Module != b.Module {
+			return a.Module < b.Module
+		}
+
+		if a.Package != b.Package {
+			return a.Package < b.Package
+		}
+
+		return a.Name < b.Name
+	})
+
+	for _, m := range perModule {
+		report.Modules = append(report.Modules, *m)
+	}
+
+	sort.Slice(report.Modules, func(i, j int) bool {
+		return report.Modules[i].Module < report.Modules[j].Module
+	})
+
+	return report, nil
+}
+
+// RunTests runs all tests in the effective module set using the store and license data
+// of the context. If a module name is served by more than one _PRP_ in the same context,
+// e.g. two directories with the same relative module name, an error is returned since
+// only the first would be tested.
+func (pc *policyContext) RunTests(c context.Context, coverage bool) (*PolicyTestReport, error) {
+
+	for ctx := pc; ctx != nil; ctx = ctx.parent {
+
+		served := map[string]bool{}
+
+		for _, p := range ctx.prps() {
+
+			for _, name := range p.GetModuleNames(c, false) {
+
+				if served[name] {
+					return nil, fmt.Errorf("module %s is served by more than one PRP", name)
+				}
+
+				served[name] = true
+			}
+
+		}
+
+	}
+
+	modules := map[string]string{}
+
+	for _, info := range pc.Modules(c) {
+
+		if module := pc.lookupModule(c, info.Name); module != "" {
+			modules[info.Name] = module
+		}
+
+	}
+
+	store, err := pc.effectiveStore(c)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return RunPolicyTests(c, modules, store, coverage)
+}
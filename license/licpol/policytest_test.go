@@ -0,0 +1,79 @@
+package licpol
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunPolicyTestsOnRepositoryModules(t *testing.T) {
+
+	prp, err := NewFilesystemPRP("../../rego", 0)
+	assert.Equal(t, nil, err)
+
+	report, err := New().
+		RegisterPRP(context.Background(), prp).
+		RunTests(context.Background(), true)
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, report.Passed())
+	assert.Equal(t, 4, len(report.Results))
+
+	for _, m := range report.Modules {
+
+		if m.Module == "test.rego" {
+			assert.Equal(t, true, m.Coverage > 0)
+		}
+
+		if m.Module == "test_test.rego" {
+			assert.Equal(t, 4, m.Passed)
+		}
+
+	}
+
+}
+
+func TestRunPolicyTestsReportsFailure(t *testing.T) {
+
+	report, err := RunPolicyTests(context.Background(), map[string]string{
+		"a.rego":      "package a\n\nx = 1",
+		"a_test.rego": "package a\n\ntest_ok { x == 1 }\n\ntest_bad { x == 2 }",
+	}, nil, false)
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, report.Passed())
+
+	assert.Equal(t, []PolicyTestModuleReport{
+		{Module: "a.rego"},
+		{Module: "a_test.rego", Passed: 1, Failed: 1},
+	}, report.Modules)
+
+	var buf bytes.Buffer
+	assert.Equal(t, nil, report.Print(&buf, false, false))
+	assert.Contains(t, buf.String(), "FAIL: data.a.test_bad (a_test.rego)")
+	assert.Contains(t, buf.String(), "FAIL: 1/2")
+}
+
+func TestRunTestsRejectsDuplicateModuleNames(t *testing.T) {
+
+	first, second := t.TempDir(), t.TempDir()
+
+	writeModule(t, first, "policy_test.rego", "package a\n\ntest_ok { true }", time.Now())
+	writeModule(t, second, "policy_test.rego", "package b\n\ntest_ok { true }", time.Now())
+
+	pctx := New()
+
+	for _, dir := range []string{first, second} {
+
+		prp, err := NewFilesystemPRP(dir, 0)
+		assert.Equal(t, nil, err)
+
+		pctx.RegisterPRP(context.Background(), prp)
+	}
+
+	_, err := pctx.RunTests(context.Background(), false)
+	assert.EqualError(t, err, "module policy_test.rego is served by more than one PRP")
+}
@@ -0,0 +1,120 @@
+package licpol
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/storage"
+)
+
+// preparedQueries caches `rego.PreparedEvalQuery` per method such that the
+// query is only parsed, compiled and planned once.
+type preparedQueries struct {
+	mu       sync.RWMutex
+	compiler *ast.Compiler
+	store    storage.Store
+	queries  map[string]*rego.PreparedEvalQuery
+}
+
+func newPreparedQueries(compiler *ast.Compiler, store storage.Store) *preparedQueries {
+
+	return &preparedQueries{
+		compiler: compiler,
+		store:    store,
+		queries:  map[string]*rego.PreparedEvalQuery{},
+	}
+
+}
+
+// get returns the prepared _query_ for _method_. If not yet prepared, it will be
+// prepared and cached.
+func (pq *preparedQueries) get(c context.Context, method, query string) (*rego.PreparedEvalQuery, error) {
+
+	pq.mu.RLock()
+	q, ok := pq.queries[method]
+	pq.mu.RUnlock()
+
+	if ok {
+		return q, nil
+	}
+
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+
+	if q, ok := pq.queries[method]; ok {
+		return q, nil
+	}
+
+	options := []func(r *rego.Rego){
+		rego.Query(query),
+		rego.Compiler(pq.compiler),
+	}
+
+	if pq.store != nil {
+		options = append(options, rego.Store(pq.store))
+	}
+
+	prepared, err := rego.New(options...).PrepareForEval(c)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare query %s for method %s: %w", query, method, err)
+	}
+
+	pq.queries[method] = &prepared
+	return &prepared, nil
+
+}
+
+// reset drops all prepared queries and makes subsequent queries to be prepared
+// using the _compiler_.
+func (pq *preparedQueries) reset(compiler *ast.Compiler) {
+
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+
+	pq.compiler = compiler
+	pq.queries = map[string]*rego.PreparedEvalQuery{}
+}
+
+// decisionFromResultSet interprets the result of a decision query. An undefined
+// result is the same as not allowed.
+//
+// The query may evaluate to a boolean or to a object with a "allow" boolean and an
+// optional "reasons" array of strings.
+func decisionFromResultSet(rs rego.ResultSet) (Decision, error) {
+
+	if len(rs) == 0 || len(rs[0].Expressions) == 0 {
+		return Decision{}, nil
+	}
+
+	expr := rs[0].Expressions[0]
+
+	switch v := expr.Value.(type) {
+	case bool:
+		return Decision{Allowed: v}, nil
+	case map[string]interface{}:
+
+		allowed, ok := v["allow"].(bool)
+
+		if !ok {
+			return Decision{}, fmt.Errorf("expected boolean allow in decision from %s", expr.Text)
+		}
+
+		decision := Decision{Allowed: allowed}
+
+		if reasons, ok := v["reasons"].([]interface{}); ok {
+
+			for _, r := range reasons {
+				decision.Reasons = append(decision.Reasons, fmt.Sprint(r))
+			}
+
+		}
+
+		return decision, nil
+	}
+
+	return Decision{}, fmt.Errorf("expected boolean or object decision from %s, got %T", expr.Text, expr.Value)
+}
@@ -0,0 +1,761 @@
+package licpol
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mariotoffia/gojwtlic/license"
+)
+
+const (
+	// bundleManifestFile is the _OPA_ bundle manifest.
+	bundleManifestFile = ".manifest"
+	// bundleSignaturesFile is the _OPA_ bundle signatures file.
+	bundleSignaturesFile = ".signatures.json"
+	// bundleDataFile is the name of data documents in a _OPA_ bundle.
+	bundleDataFile = "data.json"
+	// defaultMaxBundleSize is the default maximum uncompressed size of a bundle.
+	defaultMaxBundleSize = 32 << 20
+)
+
+// ErrBundleTooLarge is returned when a bundle exceeds the max size set by `BundlePRP.MaxSize`.
+var ErrBundleTooLarge = errors.New("bundle too large")
+
+// BundleManifest is the _.manifest_ in a _OPA_ bundle.
+type BundleManifest struct {
+	Revision string   `json:"revision"`
+	Roots    []string `json:"roots,omitempty"`
+}
+
+// bundle is a downloaded and verified _OPA_ bundle.
+type bundle struct {
+	manifest BundleManifest
+	modules  map[string]string
+	data     map[string]interface{}
+}
+
+// bundleSignatures is the _.signatures.json_ in a _OPA_ bundle.
+type bundleSignatures struct {
+	Signatures []string `json:"signatures"`
+}
+
+// bundleFile is a single file entry in the signed payload.
+type bundleFile struct {
+	Name      string `json:"name"`
+	Hash      string `json:"hash"`
+	Algorithm string `json:"algorithm"`
+}
+
+// bundlePayload is the payload of the bundle signature.
+type bundlePayload struct {
+	Files []bundleFile `json:"files"`
+	KeyID string       `json:"keyid,omitempty"`
+	Scope string       `json:"scope,omitempty"`
+}
+
+// BundlePRP is a remote `PolicyRetrievalPoint` that downloads _OPA_ bundle tarballs
+// (_.manifest_, _.rego_ files and _data.json_) from a _HTTP_ endpoint.
+//
+// The endpoint is polled using the _ETag_ such that a unchanged bundle is never
+// downloaded again. If a key is set using `Verify`, the bundle signature in
+// _.signatures.json_ is verified before the bundle is served.
+//
+// If a download or verification fails, the last good bundle is still served and the
+// error is available in `Error`.
+//
+// .Example Usage
+// [source,go]
+// ....
+// prp := NewBundlePRP("https://policies.valmatics.se/bundle.tar.gz", time.Minute).Verify(keys)
+//
+// err := prp.Load(ctx) // first download
+// pctx := New().RegisterPRP(ctx, prp)
+// ....
+type BundlePRP struct {
+	mu       sync.RWMutex
+	err      error
+	url      string
+	client   *http.Client
+	keys     license.KeyPair
+	maxSize  int64
+	interval time.Duration
+	etag     string
+	current  *bundle
+	change   PolicyRetrievalPointChangeFunc
+	stop     chan struct{}
+	once     sync.Once
+}
+
+// NewBundlePRP creates a new `BundlePRP` that downloads the bundle from _url_.
+//
+// If _interval_ is greater than zero, the endpoint is polled in background with
+// that interval after `Initialize` has been invoked. Otherwise the polling is done
+// each time `Process` is invoked.
+func NewBundlePRP(url string, interval time.Duration) *BundlePRP {
+
+	return &BundlePRP{
+		url:      url,
+		client:   http.DefaultClient,
+		maxSize:  defaultMaxBundleSize,
+		interval: interval,
+		current:  &bundle{modules: map[string]string{}, data: map[string]interface{}{}},
+		stop:     make(chan struct{}),
+	}
+
+}
+
+// Client sets the _HTTP_ client to use when downloading. Default is `http.DefaultClient`.
+func (p *BundlePRP) Client(client *http.Client) *BundlePRP {
+	p.client = client
+	return p
+}
+
+// MaxSize sets the maximum uncompressed size, in bytes, of all files in a bundle. A
+// larger bundle is rejected. Default is 32MB.
+func (p *BundlePRP) MaxSize(size int64) *BundlePRP {
+
+	if size <= 0 {
+		panic("max bundle size must be greater than zero")
+	}
+
+	p.maxSize = size
+	return p
+}
+
+// Verify requires each bundle to be signed and verified using the public key in _keys_.
+//
+// The _keys_ must be a `license.RSAKeyPair` or a `license.KMSKeyPair` with a _RSA_
+// public key.
+func (p *BundlePRP) Verify(keys license.KeyPair) *BundlePRP {
+	p.keys = keys
+	return p
+}
+
+// Error returns the error from the last download, if any. The last good bundle is
+// still served.
+func (p *BundlePRP) Error() error {
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.err
+}
+
+// Revision returns the revision in the manifest of the current bundle.
+func (p *BundlePRP) Revision() string {
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.current.manifest.Revision
+}
+
+// Close stops the background polling.
+func (p *BundlePRP) Close() error {
+
+	p.once.Do(func() { close(p.stop) })
+	return nil
+}
+
+// IsShareable is `false` since the change function is bound to a single context.
+func (p *BundlePRP) IsShareable() bool {
+	return false
+}
+
+// CanMutate is `true` since a new bundle may be published.
+func (p *BundlePRP) CanMutate() bool {
+	return true
+}
+
+// HasRemoteDataSource is `true` since the bundle is downloaded.
+func (p *BundlePRP) HasRemoteDataSource() bool {
+	return true
+}
+
+// Initialize registers the _change_ function and starts the background polling,
+// if enabled. The polling is stopped when _c_ is done or `Close` is invoked.
+//
+// Use `Load` to download the first bundle before this function is invoked.
+func (p *BundlePRP) Initialize(c context.Context, change PolicyRetrievalPointChangeFunc) {
+
+	p.mu.Lock()
+	p.change = change
+	p.mu.Unlock()
+
+	if p.interval <= 0 {
+		return
+	}
+
+	go func() {
+
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-c.Done():
+				return
+			case <-p.stop:
+				return
+			case <-ticker.C:
+				p.Load(c)
+			}
+		}
+
+	}()
+}
+
+// GetModuleNames returns the sorted names of all modules in the current bundle. If
+// _force_ is `true` the bundle is downloaded first, if changed.
+func (p *BundlePRP) GetModuleNames(c context.Context, force bool) []string {
+
+	if force {
+		p.Load(c)
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	names := make([]string, 0, len(p.current.modules))
+
+	for name := range p.current.modules {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// GetModule returns the module by it's name or an empty string if not found.
+func (p *BundlePRP) GetModule(c context.Context, name string) string {
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.current.modules[name]
+}
+
+// EvictModules do nothing since a bundle is always loaded as a whole.
+func (p *BundlePRP) EvictModules(modules []string) {}
+
+// GetModules returns all modules in the current bundle. If _force_ is `true` the
+// bundle is downloaded first, if changed.
+func (p *BundlePRP) GetModules(c context.Context, force bool) map[string]string {
+
+	if force {
+		p.Load(c)
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	modules := make(map[string]string, len(p.current.modules))
+
+	for name, module := range p.current.modules {
+		modules[name] = module
+	}
+
+	return modules
+}
+
+// GetData returns the merged data documents in the current bundle.
+func (p *BundlePRP) GetData(c context.Context) map[string]interface{} {
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.current.data
+}
+
+// Process downloads the bundle, if changed. This is only needed when the background
+// polling is not enabled.
+func (p *BundlePRP) Process(c context.Context) {
+	p.Load(c)
+}
+
+// Load downloads the bundle, if changed since last download, verifies it and
+// notifies all changes. If it fails, the last good bundle is kept and the error is
+// returned.
+func (p *BundlePRP) Load(c context.Context) error {
+
+	b, etag, err := p.download(c)
+
+	p.mu.Lock()
+
+	p.err = err
+
+	if err != nil || b == nil {
+		p.mu.Unlock()
+		return err
+	}
+
+	old := p.current
+	p.current = b
+	p.etag = etag
+	change := p.change
+
+	p.mu.Unlock()
+
+	if change != nil {
+
+		for _, ch := range diffBundles(old, b) {
+			change(p, ch.module, ch.change)
+		}
+
+	}
+
+	return nil
+}
+
+// download fetches and verifies the bundle. If not modified, a `nil` bundle is returned.
+func (p *BundlePRP) download(c context.Context) (*bundle, string, error) {
+
+	req, err := http.NewRequestWithContext(c, http.MethodGet, p.url, nil)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	p.mu.RLock()
+	etag := p.etag
+	p.mu.RUnlock()
+
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := p.client.Do(req)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, "", nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("bundle download from %s failed with status %d", p.url, resp.StatusCode)
+	}
+
+	files, err := readBundleFiles(resp.Body, p.maxSize)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	if p.keys != nil {
+
+		if err := verifyBundle(files, p.keys); err != nil {
+			return nil, "", err
+		}
+
+	}
+
+	b, err := parseBundle(files)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	return b, resp.Header.Get("ETag"), nil
+}
+
+// readBundleFiles reads all regular files in the _gzip_ compressed tarball. The file
+// names are cleaned and without leading slash.
+//
+// Both the compressed tarball and the total uncompressed size of the files are
+// limited to _max_ bytes.
+func readBundleFiles(r io.Reader, max int64) (map[string][]byte, error) {
+
+	gr, err := gzip.NewReader(io.LimitReader(r, max))
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer gr.Close()
+
+	files := map[string][]byte{}
+	tr := tar.NewReader(gr)
+
+	for {
+
+		hdr, err := tr.Next()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		var buf bytes.Buffer
+
+		n, err := io.Copy(&buf, io.LimitReader(tr, max+1))
+
+		if err != nil {
+			return nil, err
+		}
+
+		if max -= n; max < 0 {
+			return nil, ErrBundleTooLarge
+		}
+
+		files[bundlePath(hdr.Name)] = buf.Bytes()
+	}
+
+	return files, nil
+}
+
+// bundlePath normalizes a file name in a bundle, e.g. "/licpol/data.json" to "licpol/data.json".
+func bundlePath(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// parseBundle parses the manifest, modules and data documents.
+func parseBundle(files map[string][]byte) (*bundle, error) {
+
+	b := &bundle{
+		modules: map[string]string{},
+		data:    map[string]interface{}{},
+	}
+
+	for name, content := range files {
+
+		switch {
+		case name == bundleManifestFile:
+
+			if err := json.Unmarshal(content, &b.manifest); err != nil {
+				return nil, fmt.Errorf("bundle manifest: %w", err)
+			}
+
+		case strings.HasSuffix(name, regoExt):
+
+			b.modules[name] = string(content)
+
+		case path.Base(name) == bundleDataFile:
+
+			var data interface{}
+
+			if err := json.Unmarshal(content, &data); err != nil {
+				return nil, fmt.Errorf("bundle data %s: %w", name, err)
+			}
+
+			if err := mergeBundleData(b.data, path.Dir(name), data); err != nil {
+				return nil, err
+			}
+
+		}
+
+	}
+
+	return b, nil
+}
+
+// mergeBundleData puts _data_ at the directory _dir_ in _root_, e.g. "licpol/roles"
+// puts the data under "licpol" and "roles".
+func mergeBundleData(root map[string]interface{}, dir string, data interface{}) error {
+
+	if dir == "." {
+		return mergeObject(root, dir, data)
+	}
+
+	parts := strings.Split(dir, "/")
+	node := root
+
+	for _, part := range parts[:len(parts)-1] {
+
+		child, ok := node[part]
+
+		if !ok {
+			child = map[string]interface{}{}
+			node[part] = child
+		}
+
+		m, ok := child.(map[string]interface{})
+
+		if !ok {
+			return fmt.Errorf("bundle data conflict at %s", dir)
+		}
+
+		node = m
+	}
+
+	last := parts[len(parts)-1]
+	existing, ok := node[last]
+
+	if !ok {
+		node[last] = data
+		return nil
+	}
+
+	return mergeObject(existing, dir, data)
+}
+
+// mergeObject adds all keys in _data_ to _node_. Both must be objects and may not share
+// any keys.
+func mergeObject(node interface{}, dir string, data interface{}) error {
+
+	dst, ok := node.(map[string]interface{})
+	src, ok2 := data.(map[string]interface{})
+
+	if !ok || !ok2 {
+		return fmt.Errorf("bundle data conflict at %s", dir)
+	}
+
+	for k, v := range src {
+
+		if _, exists := dst[k]; exists {
+			return fmt.Errorf("bundle data conflict at %s/%s", dir, k)
+		}
+
+		dst[k] = v
+	}
+
+	return nil
+}
+
+// verifyBundle verifies the _.signatures.json_ and that each file in the bundle is
+// signed with a matching hash.
+func verifyBundle(files map[string][]byte, keys license.KeyPair) error {
+
+	content, ok := files[bundleSignaturesFile]
+
+	if !ok {
+		return fmt.Errorf("%w: bundle is not signed", ErrInvalidSignature)
+	}
+
+	var sigs bundleSignatures
+
+	if err := json.Unmarshal(content, &sigs); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidSignature, err)
+	}
+
+	if len(sigs.Signatures) != 1 {
+		return fmt.Errorf("%w: expected exactly one signature, got %d", ErrInvalidSignature, len(sigs.Signatures))
+	}
+
+	var payload bundlePayload
+
+	if err := verifyJWS(sigs.Signatures[0], keys, &payload); err != nil {
+		return err
+	}
+
+	signed := map[string]bool{}
+
+	for _, f := range payload.Files {
+
+		name := bundlePath(f.Name)
+		content, ok := files[name]
+
+		if !ok {
+			return fmt.Errorf("%w: signed file %s missing in bundle", ErrInvalidSignature, name)
+		}
+
+		if !strings.EqualFold(f.Algorithm, "SHA-256") {
+			return fmt.Errorf("%w: unsupported hash algorithm %s", ErrInvalidSignature, f.Algorithm)
+		}
+
+		hash, err := bundleFileHash(name, content)
+
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidSignature, err)
+		}
+
+		if hash != f.Hash {
+			return fmt.Errorf("%w: hash mismatch for %s", ErrInvalidSignature, name)
+		}
+
+		signed[name] = true
+	}
+
+	for name := range files {
+
+		if name != bundleSignaturesFile && !signed[name] {
+			return fmt.Errorf("%w: file %s is not signed", ErrInvalidSignature, name)
+		}
+
+	}
+
+	return nil
+}
+
+// bundleFileHash computes the hex encoded _SHA-256_ of a bundle file. As in _OPA_, _JSON_
+// files are hashed in compact form with sorted keys.
+func bundleFileHash(name string, content []byte) (string, error) {
+
+	if strings.HasSuffix(name, ".json") || name == bundleManifestFile {
+
+		var x interface{}
+
+		dec := json.NewDecoder(bytes.NewReader(content))
+		dec.UseNumber()
+
+		if err := dec.Decode(&x); err != nil {
+			return "", err
+		}
+
+		canonical, err := json.Marshal(x) // maps are marshalled with sorted keys
+
+		if err != nil {
+			return "", err
+		}
+
+		content = canonical
+	}
+
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// verifyJWS verifies a compact serialized _JWS_ using a _RSA_ public key in _keys_ and
+// unmarshals the payload into _payload_.
+func verifyJWS(token string, keys license.KeyPair, payload interface{}) error {
+
+	parts := strings.Split(token, ".")
+
+	if len(parts) != 3 {
+		return fmt.Errorf("%w: malformed signature", ErrInvalidSignature)
+	}
+
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidSignature, err)
+	}
+
+	var hdr struct {
+		Alg string `json:"alg"`
+	}
+
+	if err := json.Unmarshal(header, &hdr); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidSignature, err)
+	}
+
+	var hash crypto.Hash
+
+	switch hdr.Alg {
+	case "RS256":
+		hash = crypto.SHA256
+	case "RS384":
+		hash = crypto.SHA384
+	case "RS512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("%w: unsupported algorithm %s", ErrInvalidSignature, hdr.Alg)
+	}
+
+	pk, err := rsaPublicKeyOf(keys)
+
+	if err != nil {
+		return err
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidSignature, err)
+	}
+
+	h := hash.New()
+	h.Write([]byte(parts[0] + "." + parts[1]))
+
+	if err := rsa.VerifyPKCS1v15(pk, hash, h.Sum(nil), sig); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidSignature, err)
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(parts[1])
+
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidSignature, err)
+	}
+
+	if err := json.Unmarshal(data, payload); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidSignature, err)
+	}
+
+	return nil
+}
+
+// rsaPublicKeyOf resolves the _RSA_ public key from a `license.RSAKeyPair` or a
+// `license.KMSKeyPair`.
+func rsaPublicKeyOf(keys license.KeyPair) (*rsa.PublicKey, error) {
+
+	switch k := keys.(type) {
+	case license.RSAKeyPair:
+
+		if pk := k.PublicKey(); pk != nil {
+			return pk, nil
+		}
+
+	case license.KMSKeyPair:
+
+		if pk, ok := k.PublicKey(false).(*rsa.PublicKey); ok {
+			return pk, nil
+		}
+
+	}
+
+	return nil, fmt.Errorf("%w: no RSA public key in %s", ErrInvalidSignature, keys.PublicKeyID())
+}
+
+// diffBundles returns the changes from _old_ to _new_ sorted by module name. A change
+// in the data documents is notified last as `PolicyRetrievalPointChangeDataUpdated`.
+func diffBundles(old, new *bundle) []prpChange {
+
+	var changes []prpChange
+
+	for name, module := range new.modules {
+
+		if prev, ok := old.modules[name]; !ok {
+			changes = append(changes, prpChange{name, PolicyRetrievalPointChangeModuleAdded})
+		} else if prev != module {
+			changes = append(changes, prpChange{name, PolicyRetrievalPointChangeModuleUpdated})
+		}
+
+	}
+
+	for name := range old.modules {
+
+		if _, ok := new.modules[name]; !ok {
+			changes = append(changes, prpChange{name, PolicyRetrievalPointChangeModuleRemoved})
+		}
+
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].module < changes[j].module
+	})
+
+	if !reflect.DeepEqual(old.data, new.data) {
+		changes = append(changes, prpChange{"", PolicyRetrievalPointChangeDataUpdated})
+	}
+
+	return changes
+}
@@ -0,0 +1,247 @@
+package licpol
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mariotoffia/gojwtlic/license"
+	"github.com/mariotoffia/gojwtlic/license/licjwt/licbuiltin"
+	"github.com/stretchr/testify/assert"
+)
+
+// makeBundle creates a gzip compressed tarball of _files_. If _keys_ is set, a
+// _.signatures.json_ is added.
+func makeBundle(t *testing.T, files map[string]string, keys license.RSAKeyPair) []byte {
+
+	if keys != nil {
+
+		var payload bundlePayload
+
+		for name, content := range files {
+
+			hash, err := bundleFileHash(name, []byte(content))
+			assert.Equal(t, nil, err)
+
+			payload.Files = append(payload.Files, bundleFile{Name: "/" + name, Hash: hash, Algorithm: "SHA-256"})
+		}
+
+		header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256"}`))
+		data, _ := json.Marshal(payload)
+		signing := header + "." + base64.RawURLEncoding.EncodeToString(data)
+
+		sum := sha256.Sum256([]byte(signing))
+		sig, err := rsa.SignPKCS1v15(rand.Reader, keys.PrivateKey(), crypto.SHA256, sum[:])
+		assert.Equal(t, nil, err)
+
+		sigs, _ := json.Marshal(bundleSignatures{Signatures: []string{signing + "." + base64.RawURLEncoding.EncodeToString(sig)}})
+		files[bundleSignaturesFile] = string(sigs)
+	}
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+
+	for name, content := range files {
+
+		assert.Equal(t, nil, tw.WriteHeader(&tar.Header{
+			Name:     "/" + name,
+			Mode:     0644,
+			Size:     int64(len(content)),
+			Typeflag: tar.TypeReg,
+		}))
+
+		_, err := tw.Write([]byte(content))
+		assert.Equal(t, nil, err)
+	}
+
+	assert.Equal(t, nil, tw.Close())
+	assert.Equal(t, nil, gw.Close())
+
+	return buf.Bytes()
+}
+
+// bundleServer serves a bundle with a ETag and supports _If-None-Match_.
+type bundleServer struct {
+	mu        sync.Mutex
+	etag      string
+	bundle    []byte
+	status    int
+	downloads int
+}
+
+func (bs *bundleServer) set(etag string, bundle []byte, status int) {
+
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	bs.etag, bs.bundle, bs.status = etag, bundle, status
+}
+
+func (bs *bundleServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	if bs.status != http.StatusOK {
+		w.WriteHeader(bs.status)
+		return
+	}
+
+	if r.Header.Get("If-None-Match") == bs.etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	bs.downloads++
+	w.Header().Set("ETag", bs.etag)
+	w.Write(bs.bundle)
+}
+
+func TestBundlePRP(t *testing.T) {
+
+	keys := licbuiltin.NewRSAKeys(2048)
+	bs := &bundleServer{}
+
+	server := httptest.NewServer(bs)
+	defer server.Close()
+
+	bs.set(`"v1"`, makeBundle(t, map[string]string{
+		".manifest":              `{"revision": "v1"}`,
+		"licpol/test.rego":       "package licpol.testing",
+		"licpol/scope.rego":      "package licpol.scope",
+		"data.json":              `{"license": {"scope": "ui"}}`,
+		"licpol/roles/data.json": `{"admin": ["nisse"]}`,
+	}, keys), http.StatusOK)
+
+	prp := NewBundlePRP(server.URL, 0).Client(server.Client()).Verify(keys)
+	assert.Equal(t, true, prp.HasRemoteDataSource())
+	assert.Equal(t, nil, prp.Load(context.Background()))
+
+	var changes []recordedChange
+
+	pctx := New().RegisterPRP(context.Background(), prp)
+	prp.Initialize(context.Background(), func(p PolicyRetrievalPoint, module string, change PolicyRetrievalPointChange) {
+		changes = append(changes, recordedChange{module, change})
+	})
+
+	assert.Equal(t, "v1", prp.Revision())
+	assert.Equal(t, []string{"licpol/scope.rego", "licpol/test.rego"}, prp.GetModuleNames(context.Background(), false))
+	assert.Equal(t, map[string]interface{}{
+		"license": map[string]interface{}{"scope": "ui"},
+		"licpol": map[string]interface{}{
+			"roles": map[string]interface{}{"admin": []interface{}{"nisse"}},
+		},
+	}, pctx.Data(context.Background()))
+
+	// not modified
+	prp.Process(context.Background())
+	assert.Equal(t, 1, bs.downloads)
+	assert.Equal(t, 0, len(changes))
+
+	bs.set(`"v2"`, makeBundle(t, map[string]string{
+		".manifest":         `{"revision": "v2"}`,
+		"licpol/test.rego":  "package licpol.testing\n\ndefault allow = false",
+		"licpol/other.rego": "package licpol.other",
+		"data.json":         `{"license": {"scope": "ui simulator"}}`,
+	}, keys), http.StatusOK)
+
+	prp.Process(context.Background())
+	assert.Equal(t, nil, prp.Error())
+	assert.Equal(t, "v2", prp.Revision())
+	assert.Equal(t, []recordedChange{
+		{"licpol/other.rego", PolicyRetrievalPointChangeModuleAdded},
+		{"licpol/scope.rego", PolicyRetrievalPointChangeModuleRemoved},
+		{"licpol/test.rego", PolicyRetrievalPointChangeModuleUpdated},
+		{"", PolicyRetrievalPointChangeDataUpdated},
+	}, changes)
+
+	// keeps serving the last good bundle
+	bs.set(`"v3"`, nil, http.StatusInternalServerError)
+
+	assert.NotEqual(t, nil, prp.Load(context.Background()))
+	assert.Equal(t, "v2", prp.Revision())
+	assert.Equal(t, "package licpol.other", prp.GetModule(context.Background(), "licpol/other.rego"))
+}
+
+func TestBundlePRPRejectsInvalidSignature(t *testing.T) {
+
+	keys := licbuiltin.NewRSAKeys(2048)
+	bs := &bundleServer{}
+
+	server := httptest.NewServer(bs)
+	defer server.Close()
+
+	files := map[string]string{
+		".manifest":        `{"revision": "v1"}`,
+		"licpol/test.rego": "package licpol.testing",
+	}
+
+	bs.set(`"v1"`, makeBundle(t, files, keys), http.StatusOK)
+
+	prp := NewBundlePRP(server.URL, 0).Verify(keys)
+	assert.Equal(t, nil, prp.Load(context.Background()))
+
+	// signed by another key
+	bs.set(`"v2"`, makeBundle(t, map[string]string{
+		".manifest":        `{"revision": "v2"}`,
+		"licpol/test.rego": "package licpol.testing",
+	}, licbuiltin.NewRSAKeys(2048)), http.StatusOK)
+
+	assert.True(t, errors.Is(prp.Load(context.Background()), ErrInvalidSignature))
+
+	// a unsigned file has been added
+	files = map[string]string{".manifest": `{"revision": "v3"}`}
+	makeBundle(t, files, keys)
+	files["licpol/evil.rego"] = "package licpol.evil"
+
+	bs.set(`"v3"`, makeBundle(t, files, nil), http.StatusOK)
+
+	assert.True(t, errors.Is(prp.Load(context.Background()), ErrInvalidSignature))
+
+	// unsigned
+	bs.set(`"v4"`, makeBundle(t, map[string]string{".manifest": `{"revision": "v4"}`}, nil), http.StatusOK)
+
+	assert.True(t, errors.Is(prp.Load(context.Background()), ErrInvalidSignature))
+	assert.Equal(t, "v1", prp.Revision())
+}
+
+func TestBundlePRPMaxSize(t *testing.T) {
+
+	bs := &bundleServer{}
+
+	server := httptest.NewServer(bs)
+	defer server.Close()
+
+	bs.set(`"v1"`, makeBundle(t, map[string]string{
+		".manifest":        `{"revision": "v1"}`,
+		"licpol/test.rego": "package licpol.testing",
+	}, nil), http.StatusOK)
+
+	prp := NewBundlePRP(server.URL, 0).MaxSize(1024)
+	assert.Equal(t, nil, prp.Load(context.Background()))
+
+	// compresses well but is larger than max when uncompressed
+	bs.set(`"v2"`, makeBundle(t, map[string]string{
+		".manifest":        `{"revision": "v2"}`,
+		"licpol/data.json": `{"padding": "` + strings.Repeat("x", 4096) + `"}`,
+	}, nil), http.StatusOK)
+
+	assert.True(t, errors.Is(prp.Load(context.Background()), ErrBundleTooLarge))
+	assert.Equal(t, "v1", prp.Revision())
+
+	assert.Panics(t, func() { NewBundlePRP(server.URL, 0).MaxSize(0) })
+}
@@ -0,0 +1,208 @@
+package licpol
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+
+	"github.com/mariotoffia/gojwtlic/license"
+)
+
+// ModuleSetSignatureFile is the name of the detached signature file in the root of
+// a signed module set. It contains the base64 encoded signature of `ModuleSetDigest`.
+const ModuleSetSignatureFile = ".signature"
+
+// ErrInvalidSignature is returned when a signature over a module set do not verify.
+var ErrInvalidSignature = errors.New("invalid module set signature")
+
+// EmbeddedPRP is a immutable and shareable `PolicyRetrievalPoint` that loads all _.rego_
+// files from a `fs.FS`, for example a `embed.FS`, when created.
+//
+// Each module is named by it's path relative to the root of the file system. Use `fs.Sub`
+// to serve a sub directory.
+//
+// .Example Usage
+// [source,go]
+// ....
+// //go:embed policies
+// var policies embed.FS
+//
+// sub, _ := fs.Sub(policies, "policies")
+// prp, err := NewEmbeddedPRP(sub)
+// ....
+type EmbeddedPRP struct {
+	modules map[string]string
+	names   []string
+}
+
+// NewEmbeddedPRP creates a new `EmbeddedPRP` and loads all modules in _fsys_.
+func NewEmbeddedPRP(fsys fs.FS) (*EmbeddedPRP, error) {
+
+	seen, err := walkModules(fsys)
+
+	if err != nil {
+		return nil, err
+	}
+
+	p := &EmbeddedPRP{
+		modules: make(map[string]string, len(seen)),
+		names:   make([]string, 0, len(seen)),
+	}
+
+	for name := range seen {
+
+		data, err := fs.ReadFile(fsys, name)
+
+		if err != nil {
+			return nil, err
+		}
+
+		p.modules[name] = string(data)
+		p.names = append(p.names, name)
+	}
+
+	sort.Strings(p.names)
+	return p, nil
+}
+
+// NewSignedEmbeddedPRP is the same as `NewEmbeddedPRP` but verifies the detached signature in
+// `ModuleSetSignatureFile` using the public key in _keys_ before serving any module.
+//
+// Sign a module set using `SignModuleSet`.
+func NewSignedEmbeddedPRP(fsys fs.FS, keys license.RSAKeyPair) (*EmbeddedPRP, error) {
+
+	p, err := NewEmbeddedPRP(fsys)
+
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := fs.ReadFile(fsys, ModuleSetSignatureFile)
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSignature, err)
+	}
+
+	if err := VerifyModuleSet(p.modules, string(signature), keys); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// IsShareable is `true` since all modules are loaded when created.
+func (p *EmbeddedPRP) IsShareable() bool {
+	return true
+}
+
+// CanMutate is `false` since the modules never changes.
+func (p *EmbeddedPRP) CanMutate() bool {
+	return false
+}
+
+// HasRemoteDataSource is `false` since the modules are local.
+func (p *EmbeddedPRP) HasRemoteDataSource() bool {
+	return false
+}
+
+// Initialize do nothing since all modules are loaded and will never change.
+func (p *EmbeddedPRP) Initialize(c context.Context, change PolicyRetrievalPointChangeFunc) {}
+
+// GetModuleNames returns the sorted names of all modules.
+func (p *EmbeddedPRP) GetModuleNames(c context.Context, force bool) []string {
+	return append([]string{}, p.names...)
+}
+
+// GetModule returns the module by it's name or an empty string if not found.
+func (p *EmbeddedPRP) GetModule(c context.Context, name string) string {
+	return p.modules[name]
+}
+
+// EvictModules do nothing since the modules are immutable.
+func (p *EmbeddedPRP) EvictModules(modules []string) {}
+
+// GetModules returns a copy of all modules.
+func (p *EmbeddedPRP) GetModules(c context.Context, force bool) map[string]string {
+
+	modules := make(map[string]string, len(p.modules))
+
+	for name, module := range p.modules {
+		modules[name] = module
+	}
+
+	return modules
+}
+
+// Process do nothing since the modules are immutable.
+func (p *EmbeddedPRP) Process(c context.Context) {}
+
+// ModuleSetDigest computes a _SHA-256_ digest over the _modules_.
+//
+// The modules are sorted by name and each name and module is length prefixed, hence
+// the digest is stable regardless of the map order and it is not possible to move
+// content between modules without changing the digest.
+func ModuleSetDigest(modules map[string]string) []byte {
+
+	names := make([]string, 0, len(modules))
+
+	for name := range modules {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	h := sha256.New()
+
+	for _, name := range names {
+		fmt.Fprintf(h, "%d:%s%d:%s", len(name), name, len(modules[name]), modules[name])
+	}
+
+	return h.Sum(nil)
+}
+
+// SignModuleSet signs the `ModuleSetDigest` of _modules_ using the private key in _keys_
+// (_RSASSA-PKCS1-v1_5_ with _SHA-256_). The base64 encoded signature is returned and is
+// to be written to `ModuleSetSignatureFile`.
+func SignModuleSet(modules map[string]string, keys license.RSAKeyPair) (string, error) {
+
+	if keys == nil || keys.PrivateKey() == nil {
+		return "", fmt.Errorf("a private key is required to sign a module set")
+	}
+
+	sig, err := rsa.SignPKCS1v15(rand.Reader, keys.PrivateKey(), crypto.SHA256, ModuleSetDigest(modules))
+
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(sig), nil
+}
+
+// VerifyModuleSet verifies the base64 encoded _signature_, created by `SignModuleSet`, over
+// the _modules_ using the public key in _keys_.
+func VerifyModuleSet(modules map[string]string, signature string, keys license.RSAKeyPair) error {
+
+	if keys == nil || keys.PublicKey() == nil {
+		return fmt.Errorf("%w: no public key", ErrInvalidSignature)
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(signature))
+
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidSignature, err)
+	}
+
+	if err := rsa.VerifyPKCS1v15(keys.PublicKey(), crypto.SHA256, ModuleSetDigest(modules), sig); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidSignature, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package licpol
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/mariotoffia/gojwtlic/license/licjwt/licbuiltin"
+	"github.com/stretchr/testify/assert"
+)
+
+func testModuleFS() fstest.MapFS {
+
+	return fstest.MapFS{
+		"test.rego":                  {Data: []byte("package licpol.testing")},
+		"cbprovider/cbprovider.rego": {Data: []byte("package cbprovider")},
+		"cbprovider/data.json":       {Data: []byte("{}")},
+	}
+}
+
+func TestEmbeddedPRPLoadsAll(t *testing.T) {
+
+	prp, err := NewEmbeddedPRP(testModuleFS())
+	assert.Equal(t, nil, err)
+
+	assert.Equal(t, true, prp.IsShareable())
+	assert.Equal(t, false, prp.CanMutate())
+	assert.Equal(t, []string{"cbprovider/cbprovider.rego", "test.rego"}, prp.GetModuleNames(context.Background(), false))
+	assert.Equal(t, "package cbprovider", prp.GetModule(context.Background(), "cbprovider/cbprovider.rego"))
+
+	prp.EvictModules([]string{"test.rego"})
+	assert.Equal(t, "package licpol.testing", prp.GetModule(context.Background(), "test.rego"))
+
+	modules := prp.GetModules(context.Background(), true)
+	delete(modules, "test.rego")
+	assert.Equal(t, 2, len(prp.GetModules(context.Background(), false)))
+}
+
+func TestSignedEmbeddedPRP(t *testing.T) {
+
+	keys := licbuiltin.NewRSAKeys(2048)
+	fsys := testModuleFS()
+
+	prp, err := NewEmbeddedPRP(fsys)
+	assert.Equal(t, nil, err)
+
+	signature, err := SignModuleSet(prp.GetModules(context.Background(), false), keys)
+	assert.Equal(t, nil, err)
+
+	fsys[ModuleSetSignatureFile] = &fstest.MapFile{Data: []byte(signature + "\n")}
+
+	_, err = NewSignedEmbeddedPRP(fsys, keys)
+	assert.Equal(t, nil, err)
+
+	_, err = NewSignedEmbeddedPRP(fsys, licbuiltin.NewRSAKeys(2048))
+	assert.True(t, errors.Is(err, ErrInvalidSignature))
+
+	fsys["test.rego"] = &fstest.MapFile{Data: []byte("package licpol.tampered")}
+
+	_, err = NewSignedEmbeddedPRP(fsys, keys)
+	assert.True(t, errors.Is(err, ErrInvalidSignature))
+
+	delete(fsys, ModuleSetSignatureFile)
+
+	_, err = NewSignedEmbeddedPRP(fsys, keys)
+	assert.True(t, errors.Is(err, ErrInvalidSignature))
+}
+
+func TestModuleSetDigestIsUnambiguous(t *testing.T) {
+
+	assert.NotEqual(t,
+		ModuleSetDigest(map[string]string{"a": "bc"}),
+		ModuleSetDigest(map[string]string{"ab": "c"}),
+	)
+}
@@ -0,0 +1,323 @@
+package licpol
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// regoExt is the file extension of policy modules.
+const regoExt = ".rego"
+
+// fileState is the last seen state of a module file.
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// prpChange is a single detected change that is to be notified.
+type prpChange struct {
+	module string
+	change PolicyRetrievalPointChange
+}
+
+// FilesystemPRP is a `PolicyRetrievalPoint` that loads all _.rego_ files in a directory
+// tree. Each module is named by it's path relative to the root, e.g.
+// "cbprovider/cbprovider.rego".
+//
+// The directory tree is polled for changes and added, updated or removed modules are
+// notified to the `PolicyRetrievalPointChangeFunc` given in `Initialize`.
+type FilesystemPRP struct {
+	mu       sync.RWMutex
+	err      error
+	fsys     fs.FS
+	interval time.Duration
+	modules  map[string]string
+	state    map[string]fileState
+	change   PolicyRetrievalPointChangeFunc
+	stop     chan struct{}
+	once     sync.Once
+}
+
+// NewFilesystemPRP creates a new `FilesystemPRP` and loads all modules under _root_.
+//
+// If _interval_ is greater than zero, the directory tree is polled in background with
+// that interval after `Initialize` has been invoked. Otherwise the polling is done
+// each time `Process` is invoked.
+func NewFilesystemPRP(root string, interval time.Duration) (*FilesystemPRP, error) {
+
+	p := &FilesystemPRP{
+		fsys:     os.DirFS(root),
+		interval: interval,
+		modules:  map[string]string{},
+		state:    map[string]fileState{},
+		stop:     make(chan struct{}),
+	}
+
+	if _, err := p.scan(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// Error returns the error from the last scan, if any. The last successfully
+// loaded modules are still served.
+func (p *FilesystemPRP) Error() error {
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.err
+}
+
+// Close stops the background polling.
+func (p *FilesystemPRP) Close() error {
+
+	p.once.Do(func() { close(p.stop) })
+	return nil
+}
+
+// IsShareable is `false` since the change function is bound to a single context.
+func (p *FilesystemPRP) IsShareable() bool {
+	return false
+}
+
+// CanMutate is `true` since the files may change.
+func (p *FilesystemPRP) CanMutate() bool {
+	return true
+}
+
+// HasRemoteDataSource is `false` since it reads from the local filesystem.
+func (p *FilesystemPRP) HasRemoteDataSource() bool {
+	return false
+}
+
+// Initialize registers the _change_ function and starts the background polling,
+// if enabled. The polling is stopped when _c_ is done or `Close` is invoked.
+func (p *FilesystemPRP) Initialize(c context.Context, change PolicyRetrievalPointChangeFunc) {
+
+	p.mu.Lock()
+	p.change = change
+	p.mu.Unlock()
+
+	if p.interval <= 0 {
+		return
+	}
+
+	go func() {
+
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-c.Done():
+				return
+			case <-p.stop:
+				return
+			case <-ticker.C:
+				p.poll()
+			}
+		}
+
+	}()
+}
+
+// GetModuleNames returns the names of all modules. If _force_ is `true` the
+// directory tree is scanned first.
+func (p *FilesystemPRP) GetModuleNames(c context.Context, force bool) []string {
+
+	if force {
+		p.poll()
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	names := make([]string, 0, len(p.state))
+
+	for name := range p.state {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// GetModule returns the module by it's name. If the module has been evicted it
+// is loaded again.
+func (p *FilesystemPRP) GetModule(c context.Context, name string) string {
+
+	p.mu.RLock()
+	module, ok := p.modules[name]
+	_, exists := p.state[name]
+	p.mu.RUnlock()
+
+	if ok || !exists {
+		return module
+	}
+
+	data, err := fs.ReadFile(p.fsys, name)
+
+	if err != nil {
+		return ""
+	}
+
+	p.mu.Lock()
+	p.modules[name] = string(data)
+	p.mu.Unlock()
+
+	return string(data)
+}
+
+// EvictModules unloads the _modules_ from memory. They are loaded again on
+// demand by `GetModule`.
+func (p *FilesystemPRP) EvictModules(modules []string) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, name := range modules {
+		delete(p.modules, name)
+	}
+}
+
+// GetModules returns all modules. If _force_ is `true` the directory tree is
+// scanned first.
+func (p *FilesystemPRP) GetModules(c context.Context, force bool) map[string]string {
+
+	modules := map[string]string{}
+
+	for _, name := range p.GetModuleNames(c, force) {
+
+		if module := p.GetModule(c, name); module != "" {
+			modules[name] = module
+		}
+
+	}
+
+	return modules
+}
+
+// Process scans the directory tree for changes. This is only needed when the
+// background polling is not enabled.
+func (p *FilesystemPRP) Process(c context.Context) {
+	p.poll()
+}
+
+// poll scans for changes and notifies them.
+func (p *FilesystemPRP) poll() {
+
+	changes, _ := p.scan()
+
+	p.mu.RLock()
+	change := p.change
+	p.mu.RUnlock()
+
+	if change == nil {
+		return
+	}
+
+	for _, c := range changes {
+		change(p, c.module, c.change)
+	}
+}
+
+// scan walks the directory tree and loads added or updated modules. The detected
+// changes are returned.
+func (p *FilesystemPRP) scan() ([]prpChange, error) {
+
+	seen, err := walkModules(p.fsys)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.err = err
+
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []prpChange
+
+	for name, state := range seen {
+
+		old, exists := p.state[name]
+
+		if exists && old.modTime.Equal(state.modTime) && old.size == state.size {
+			continue
+		}
+
+		data, err := fs.ReadFile(p.fsys, name)
+
+		if err != nil {
+			p.err = err
+			continue
+		}
+
+		p.modules[name] = string(data)
+		p.state[name] = state
+
+		if exists {
+			changes = append(changes, prpChange{name, PolicyRetrievalPointChangeModuleUpdated})
+		} else {
+			changes = append(changes, prpChange{name, PolicyRetrievalPointChangeModuleAdded})
+		}
+
+	}
+
+	for name := range p.state {
+
+		if _, ok := seen[name]; !ok {
+
+			delete(p.state, name)
+			delete(p.modules, name)
+
+			changes = append(changes, prpChange{name, PolicyRetrievalPointChangeModuleRemoved})
+
+		}
+
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].module < changes[j].module
+	})
+
+	return changes, p.err
+}
+
+// walkModules returns the state of all _.rego_ files in _fsys_ keyed by their
+// slash separated path.
+func walkModules(fsys fs.FS) (map[string]fileState, error) {
+
+	seen := map[string]fileState{}
+
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() || !strings.HasSuffix(name, regoExt) {
+			return nil
+		}
+
+		info, err := d.Info()
+
+		if err != nil {
+			return err
+		}
+
+		seen[path.Clean(name)] = fileState{modTime: info.ModTime(), size: info.Size()}
+		return nil
+
+	})
+
+	return seen, err
+}
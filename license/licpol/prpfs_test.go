@@ -0,0 +1,102 @@
+package licpol
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordedChange struct {
+	module string
+	change PolicyRetrievalPointChange
+}
+
+func writeModule(t *testing.T, root, name, module string, mod time.Time) {
+
+	fp := filepath.Join(root, filepath.FromSlash(name))
+
+	assert.Equal(t, nil, os.MkdirAll(filepath.Dir(fp), 0755))
+	assert.Equal(t, nil, os.WriteFile(fp, []byte(module), 0644))
+	assert.Equal(t, nil, os.Chtimes(fp, mod, mod))
+}
+
+func TestFilesystemPRPLoadsTree(t *testing.T) {
+
+	prp, err := NewFilesystemPRP("../../rego", 0)
+	assert.Equal(t, nil, err)
+
+	assert.Equal(t, []string{"cbprovider/cbprovider.rego", "test.rego", "test_test.rego"}, prp.GetModuleNames(context.Background(), false))
+	assert.Contains(t, prp.GetModule(context.Background(), "test.rego"), "package licpol.testing")
+	assert.Equal(t, true, prp.CanMutate())
+	assert.Equal(t, false, prp.HasRemoteDataSource())
+}
+
+func TestFilesystemPRPDetectsChanges(t *testing.T) {
+
+	root := t.TempDir()
+	then := time.Now().Add(-time.Hour)
+
+	writeModule(t, root, "a.rego", "package a", then)
+	writeModule(t, root, "sub/b.rego", "package b", then)
+	writeModule(t, root, "sub/data.json", "{}", then)
+
+	prp, err := NewFilesystemPRP(root, 0)
+	assert.Equal(t, nil, err)
+
+	var changes []recordedChange
+	prp.Initialize(context.Background(), func(p PolicyRetrievalPoint, module string, change PolicyRetrievalPointChange) {
+		changes = append(changes, recordedChange{module, change})
+	})
+
+	assert.Equal(t, map[string]string{"a.rego": "package a", "sub/b.rego": "package b"}, prp.GetModules(context.Background(), false))
+
+	writeModule(t, root, "a.rego", "package a2", time.Now())
+	writeModule(t, root, "c.rego", "package c", time.Now())
+	assert.Equal(t, nil, os.Remove(filepath.Join(root, "sub", "b.rego")))
+
+	prp.Process(context.Background())
+
+	assert.Equal(t, []recordedChange{
+		{"a.rego", PolicyRetrievalPointChangeModuleUpdated},
+		{"c.rego", PolicyRetrievalPointChangeModuleAdded},
+		{"sub/b.rego", PolicyRetrievalPointChangeModuleRemoved},
+	}, changes)
+
+	assert.Equal(t, "package a2", prp.GetModule(context.Background(), "a.rego"))
+	assert.Equal(t, "", prp.GetModule(context.Background(), "sub/b.rego"))
+
+	prp.EvictModules([]string{"a.rego"})
+	assert.Equal(t, "package a2", prp.GetModule(context.Background(), "a.rego"))
+}
+
+func TestFilesystemPRPPollsInBackground(t *testing.T) {
+
+	root := t.TempDir()
+	writeModule(t, root, "a.rego", "package a", time.Now().Add(-time.Hour))
+
+	prp, err := NewFilesystemPRP(root, 5*time.Millisecond)
+	assert.Equal(t, nil, err)
+
+	defer prp.Close()
+
+	added := make(chan string, 1)
+
+	prp.Initialize(context.Background(), func(p PolicyRetrievalPoint, module string, change PolicyRetrievalPointChange) {
+		if change == PolicyRetrievalPointChangeModuleAdded {
+			added <- module
+		}
+	})
+
+	writeModule(t, root, "b.rego", "package b", time.Now())
+
+	select {
+	case module := <-added:
+		assert.Equal(t, "b.rego", module)
+	case <-time.After(2 * time.Second):
+		t.Fatal("module b.rego was never detected")
+	}
+}
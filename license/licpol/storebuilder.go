@@ -2,14 +2,25 @@ package licpol
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
 	"strings"
 
 	"github.com/open-policy-agent/opa/storage"
 	"github.com/open-policy-agent/opa/storage/inmem"
 	"github.com/open-policy-agent/opa/util"
+	"github.com/pelletier/go-toml"
+	"gopkg.in/yaml.v3"
 )
 
+// dataFileNames is the data files, in load order, that `InMemStoreBuilder.AddDirectory`
+// loads from each directory.
+var dataFileNames = []string{"data.json", "data.yaml", "data.yml", "data.toml"}
+
 // InMemStoreBuilder is a simple builder to create a opa storeage for in memory use.
 type InMemStoreBuilder struct {
 	err  error
@@ -43,12 +54,18 @@ func (isb *InMemStoreBuilder) ClearError() *InMemStoreBuilder {
 }
 
 // Add will add a single data-set with a specified path. The path is separated with a '/'.
+// The _data_ must not be `nil`.
 func (isb *InMemStoreBuilder) Add(path string, data map[string]interface{}) *InMemStoreBuilder {
 
 	if isb.err != nil {
 		return isb
 	}
 
+	if data == nil {
+		isb.err = fmt.Errorf("data for path %s is nil", path)
+		return isb
+	}
+
 	if _, ok := isb.data[path]; ok {
 		isb.err = fmt.Errorf("already exists data for path %s", path)
 		return isb
@@ -77,35 +94,286 @@ func (isb *InMemStoreBuilder) AddJSON(path, json string) *InMemStoreBuilder {
 
 }
 
-// Build will iterate all paths and build up a in-memory store.
-func (isb *InMemStoreBuilder) Build() storage.Store {
+// AddYAML will add a single _YAML_ document to the in memory store. The path is separated with '/'.
+func (isb *InMemStoreBuilder) AddYAML(path, yaml string) *InMemStoreBuilder {
 
 	if isb.err != nil {
-		return nil
+		return isb
+	}
+
+	data, err := parseDataFile("data.yaml", []byte(yaml))
+
+	if err != nil {
+		isb.err = err
+		return isb
+	}
+
+	return isb.Add(path, data)
+
+}
+
+// AddTOML will add a single _TOML_ document to the in memory store. The path is separated with '/'.
+func (isb *InMemStoreBuilder) AddTOML(path, toml string) *InMemStoreBuilder {
+
+	if isb.err != nil {
+		return isb
+	}
+
+	data, err := parseDataFile("data.toml", []byte(toml))
+
+	if err != nil {
+		isb.err = err
+		return isb
+	}
+
+	return isb.Add(path, data)
+
+}
+
+// AddDirectory will add all _data.json_, _data.yaml_, _data.yml_ and _data.toml_ files
+// in the directory tree _root_. As in _OPA_, the data is added to the path of the directory
+// relative to _root_, e.g. _a/b/data.json_ is added to path _a/b_.
+//
+// When several files contribute to the same path, the documents are merged. It is a
+// conflict error when two files set the same non-object value.
+//
+// .Example Usage
+// [source,go]
+// ....
+// store := NewInMemStoreBuilder(ctx).
+// AddDirectory("rego").
+// Build()
+// ....
+func (isb *InMemStoreBuilder) AddDirectory(root string) *InMemStoreBuilder {
+
+	if isb.err != nil {
+		return isb
 	}
 
-	store := inmem.New()
+	err := fs.WalkDir(os.DirFS(root), ".", func(name string, d fs.DirEntry, err error) error {
+
+		if err != nil || !d.IsDir() {
+			return err
+		}
+
+		for _, file := range dataFileNames {
+
+			buf, err := fs.ReadFile(os.DirFS(root), path.Join(name, file))
+
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			if err != nil {
+				return err
+			}
+
+			data, err := parseDataFile(file, buf)
+
+			if err != nil {
+				return fmt.Errorf("%s: %w", path.Join(name, file), err)
+			}
+
+			dir := name
+
+			if dir == "." {
+				dir = ""
+			}
+
+			if err := isb.merge(dir, data); err != nil {
+				return fmt.Errorf("%s: %w", path.Join(name, file), err)
+			}
+
+		}
+
+		return nil
+
+	})
 
-	txn, err := store.NewTransaction(isb.ctx, storage.WriteParams)
 	if err != nil {
 		isb.err = err
+	}
+
+	return isb
+
+}
+
+// merge merges the _data_ into a copy of the data of _path_.
+func (isb *InMemStoreBuilder) merge(path string, data map[string]interface{}) error {
+
+	existing, ok := isb.data[path]
+
+	if !ok {
+		isb.data[path] = data
 		return nil
 	}
 
-	for k, v := range isb.data {
+	copied, err := jsonValue(existing)
+
+	if err != nil {
+		return err
+	}
 
-		if err := store.Write(isb.ctx, txn, storage.AddOp, strings.Split(k, "/"), v); err != nil {
+	if err := mergeDocument(copied.(map[string]interface{}), path, data); err != nil {
+		return err
+	}
+
+	isb.data[path] = copied.(map[string]interface{})
+	return nil
+}
+
+// Build will iterate all paths and build up a in-memory store. Overlapping paths, e.g.
+// _a_ and _a/b_, are merged.
+func (isb *InMemStoreBuilder) Build() storage.Store {
+
+	if isb.err != nil {
+		return nil
+	}
+
+	paths := make([]string, 0, len(isb.data))
+
+	for k := range isb.data {
+		paths = append(paths, k)
+	}
+
+	sort.Strings(paths)
+
+	root := map[string]interface{}{}
+
+	for _, k := range paths {
+
+		v, err := jsonValue(isb.data[k])
+
+		if err != nil {
+			isb.err = err
+			return nil
+		}
+
+		if err := mergeDocumentAt(root, splitStorePath(k), v.(map[string]interface{})); err != nil {
 			isb.err = err
 			return nil
 		}
 
 	}
 
-	if err := store.Commit(isb.ctx, txn); err != nil {
-		isb.err = err
-		return nil
+	return inmem.NewFromObject(root)
+
+}
+
+// parseDataFile parses a _JSON_, _YAML_ or _TOML_ object depending on the extension of _name_.
+func parseDataFile(name string, buf []byte) (map[string]interface{}, error) {
+
+	var data map[string]interface{}
+
+	switch path.Ext(name) {
+	case ".json":
+
+		if err := util.UnmarshalJSON(buf, &data); err != nil {
+			return nil, err
+		}
+
+	case ".yaml", ".yml":
+
+		var doc interface{}
+
+		if err := yaml.Unmarshal(buf, &doc); err != nil {
+			return nil, err
+		}
+
+		// normalize to JSON types, e.g. int and time.Time
+		js, err := json.Marshal(doc)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err := util.UnmarshalJSON(js, &data); err != nil {
+			return nil, err
+		}
+
+	case ".toml":
+
+		tree, err := toml.LoadBytes(buf)
+
+		if err != nil {
+			return nil, err
+		}
+
+		// normalize to JSON types, e.g. int64 and time.Time
+		js, err := json.Marshal(tree.ToMap())
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err := util.UnmarshalJSON(js, &data); err != nil {
+			return nil, err
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported data file %s", name)
+	}
+
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+
+	return data, nil
+}
+
+// mergeDocumentAt merges _data_ into the object at _path_ in _root_ and creates any
+// missing parent objects.
+func mergeDocumentAt(root map[string]interface{}, path storage.Path, data map[string]interface{}) error {
+
+	node := root
+
+	for i, part := range path {
+
+		child, ok := node[part]
+
+		if !ok {
+			child = map[string]interface{}{}
+			node[part] = child
+		}
+
+		m, ok := child.(map[string]interface{})
+
+		if !ok {
+			return fmt.Errorf("data conflict at %s", strings.Join(path[:i+1], "/"))
+		}
+
+		node = m
 	}
 
-	return store
+	return mergeDocument(node, strings.Join(path, "/"), data)
+}
+
+// mergeDocument deep merges _src_ into _dst_. Objects are merged and it is a conflict
+// if both _dst_ and _src_ have a non-object value for the same key.
+func mergeDocument(dst map[string]interface{}, at string, src map[string]interface{}) error {
+
+	for k, v := range src {
+
+		key := strings.TrimPrefix(at+"/"+k, "/")
+		existing, ok := dst[k]
+
+		if !ok {
+			dst[k] = v
+			continue
+		}
+
+		a, ok := existing.(map[string]interface{})
+		b, ok2 := v.(map[string]interface{})
+
+		if !ok || !ok2 {
+			return fmt.Errorf("data conflict at %s", key)
+		}
+
+		if err := mergeDocument(a, key, b); err != nil {
+			return err
+		}
+
+	}
 
+	return nil
 }
@@ -0,0 +1,91 @@
+package licpol
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeDataFile(t *testing.T, root, name, data string) {
+
+	fp := filepath.Join(root, filepath.FromSlash(name))
+
+	assert.Equal(t, nil, os.MkdirAll(filepath.Dir(fp), 0755))
+	assert.Equal(t, nil, os.WriteFile(fp, []byte(data), 0644))
+}
+
+func TestStoreBuilderYAMLAndTOML(t *testing.T) {
+
+	c := context.Background()
+
+	store := NewInMemStoreBuilder(c).
+		AddYAML("limits", "max_users: 10\ntrial: true\n").
+		AddTOML("tenants", "[acme]\nseats = 5\n").
+		Build()
+
+	assert.NotNil(t, store)
+
+	v, err := storage.ReadOne(c, store, storage.Path{"limits"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, map[string]interface{}{"max_users": json.Number("10"), "trial": true}, v)
+
+	v, err = storage.ReadOne(c, store, storage.Path{"tenants", "acme", "seats"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, json.Number("5"), v)
+}
+
+func TestStoreBuilderAddDirectory(t *testing.T) {
+
+	c := context.Background()
+	root := t.TempDir()
+
+	writeDataFile(t, root, "data.json", `{"a": {"x": 1}}`)
+	writeDataFile(t, root, "a/b/data.yaml", "y: 2\n")
+	writeDataFile(t, root, "a/b/data.toml", "z = 3\n")
+	writeDataFile(t, root, "a/data.json", `{"b": {"w": 4}}`)
+
+	builder := NewInMemStoreBuilder(c).AddDirectory(root)
+	assert.Equal(t, nil, builder.Error())
+
+	v, err := storage.ReadOne(c, builder.Build(), storage.Path{"a"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, map[string]interface{}{
+		"x": json.Number("1"),
+		"b": map[string]interface{}{"y": json.Number("2"), "z": json.Number("3"), "w": json.Number("4")},
+	}, v)
+
+	writeDataFile(t, root, "a/b/data.yml", "y: 5\n")
+
+	builder = NewInMemStoreBuilder(c).AddDirectory(root)
+	assert.NotEqual(t, nil, builder.Error())
+	assert.Contains(t, builder.Error().Error(), "data conflict at a/b/y")
+	assert.Nil(t, builder.Build())
+}
+
+func TestStoreBuilderAddNil(t *testing.T) {
+
+	builder := NewInMemStoreBuilder(context.Background()).Add("limits", nil)
+
+	assert.NotEqual(t, nil, builder.Error())
+	assert.Nil(t, builder.Build())
+}
+
+func TestStoreBuilderAddDirectoryFixtures(t *testing.T) {
+
+	c := context.Background()
+
+	store := NewInMemStoreBuilder(c).
+		AddDirectory("../../rego").
+		Build()
+
+	assert.NotNil(t, store)
+
+	v, err := storage.ReadOne(c, store, storage.Path{"cbprovider", "target", "IDT"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, json.Number("22"), v)
+}
@@ -0,0 +1,611 @@
+package licpol
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/storage/inmem"
+	"github.com/open-policy-agent/opa/util"
+)
+
+const (
+	// DiskStoreSnapshotFile is the name of the snapshot file in the `DiskStore` directory.
+	DiskStoreSnapshotFile = "snapshot.json"
+	// DiskStoreLogFile is the name of the append-only log in the `DiskStore` directory.
+	DiskStoreLogFile = "data.log"
+	// DefaultDiskStoreSnapshotInterval is the default number of logged transactions
+	// before a new snapshot is written.
+	DefaultDiskStoreSnapshotInterval = 1000
+)
+
+// diskOp is a single logged write.
+type diskOp struct {
+	Op    string          `json:"op"`
+	Path  storage.Path    `json:"path,omitempty"`
+	ID    string          `json:"id,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// diskEntry is a single committed transaction in the log.
+type diskEntry struct {
+	Seq uint64   `json:"seq"`
+	Ops []diskOp `json:"ops"`
+}
+
+// diskSnapshot is the content of the snapshot file.
+type diskSnapshot struct {
+	Seq      uint64            `json:"seq"`
+	Data     json.RawMessage   `json:"data"`
+	Policies map[string][]byte `json:"policies,omitempty"`
+}
+
+// DiskStore is a `storage.Store` that keeps all data in memory and persists each committed
+// transaction to an append-only _JSON_ log in a directory. When the log grows beyond the
+// snapshot interval, a snapshot of the whole store is written and the log is truncated.
+//
+// Reads, triggers and indexing are served by an in-memory store and are as fast as
+// _inmem_. Only committing a write transaction touches the disk.
+//
+// .Example Usage
+// [source,go]
+// ....
+// store, err := NewDiskStore(ctx, "/var/lib/licpol")
+//
+// defer store.Close()
+//
+// sm := NewStoreManager(ctx, store)
+// err = sm.Upsert(ctx, "revoked/fcd2174b-664a-11eb-afe1-1629c910062f", true)
+// ....
+type DiskStore struct {
+	storage.Store
+	dir      string
+	mu       sync.Mutex
+	pending  map[uint64][]diskOp
+	logMu    sync.Mutex
+	log      *os.File
+	seq      uint64
+	entries  int
+	interval int
+	onerror  func(err error)
+}
+
+// NewDiskStore opens, or creates, a `DiskStore` in _dir_. The last snapshot is loaded
+// and all logged transactions are replayed.
+//
+// A partially written transaction at the end of the log, e.g. due to a crash, is discarded.
+func NewDiskStore(c context.Context, dir string) (*DiskStore, error) {
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	ds := &DiskStore{
+		dir:      dir,
+		pending:  map[uint64][]diskOp{},
+		interval: DefaultDiskStoreSnapshotInterval,
+	}
+
+	if err := ds.loadSnapshot(c); err != nil {
+		return nil, err
+	}
+
+	if err := ds.replay(c); err != nil {
+		return nil, err
+	}
+
+	log, err := os.OpenFile(ds.path(DiskStoreLogFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ds.log = log
+	return ds, nil
+}
+
+// SnapshotInterval sets the number of logged transactions before a new snapshot is
+// written. Zero or less disables automatic snapshots.
+func (ds *DiskStore) SnapshotInterval(transactions int) *DiskStore {
+
+	ds.logMu.Lock()
+	ds.interval = transactions
+	ds.logMu.Unlock()
+
+	return ds
+}
+
+// OnError sets a function that is invoked when a automatic snapshot fails. Since a
+// snapshot never fails the commit itself, errors are otherwise dropped. A failed
+// snapshot is retried on next commit.
+func (ds *DiskStore) OnError(f func(err error)) *DiskStore {
+
+	ds.logMu.Lock()
+	ds.onerror = f
+	ds.logMu.Unlock()
+
+	return ds
+}
+
+// Close closes the log. The store must not be written to after it has been closed.
+func (ds *DiskStore) Close() error {
+
+	ds.logMu.Lock()
+	defer ds.logMu.Unlock()
+
+	if ds.log == nil {
+		return nil
+	}
+
+	err := ds.log.Close()
+	ds.log = nil
+
+	return err
+}
+
+// Write writes to the in-memory store and records the operation to be logged on commit.
+func (ds *DiskStore) Write(
+	c context.Context,
+	txn storage.Transaction,
+	op storage.PatchOp,
+	path storage.Path,
+	value interface{}) error {
+
+	if err := ds.Store.Write(c, txn, op, path, value); err != nil {
+		return err
+	}
+
+	dop := diskOp{Path: path}
+
+	switch op {
+	case storage.AddOp:
+		dop.Op = "add"
+	case storage.RemoveOp:
+		dop.Op = "remove"
+	case storage.ReplaceOp:
+		dop.Op = "replace"
+	}
+
+	if op != storage.RemoveOp {
+
+		data, err := json.Marshal(value)
+
+		if err != nil {
+			return err
+		}
+
+		dop.Value = data
+	}
+
+	ds.record(txn, dop)
+	return nil
+}
+
+// UpsertPolicy upserts the policy in the in-memory store and records it to be logged
+// on commit.
+func (ds *DiskStore) UpsertPolicy(c context.Context, txn storage.Transaction, id string, bs []byte) error {
+
+	if err := ds.Store.UpsertPolicy(c, txn, id, bs); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(bs)
+
+	if err != nil {
+		return err
+	}
+
+	ds.record(txn, diskOp{Op: "upsert_policy", ID: id, Value: data})
+	return nil
+}
+
+// DeletePolicy deletes the policy in the in-memory store and records it to be logged
+// on commit.
+func (ds *DiskStore) DeletePolicy(c context.Context, txn storage.Transaction, id string) error {
+
+	if err := ds.Store.DeletePolicy(c, txn, id); err != nil {
+		return err
+	}
+
+	ds.record(txn, diskOp{Op: "delete_policy", ID: id})
+	return nil
+}
+
+// Commit appends all writes in _txn_ to the log before they are committed to the in-memory
+// store. If the log cannot be written, the transaction is aborted and if the in-memory
+// store fails to commit, the log entry is removed such that it is never replayed.
+//
+// When a automatic snapshot fails, the commit still succeeds, see `OnError`.
+func (ds *DiskStore) Commit(c context.Context, txn storage.Transaction) error {
+
+	ds.mu.Lock()
+	ops := ds.pending[txn.ID()]
+	delete(ds.pending, txn.ID())
+	ds.mu.Unlock()
+
+	if len(ops) == 0 {
+		return ds.Store.Commit(c, txn)
+	}
+
+	ds.logMu.Lock()
+	defer ds.logMu.Unlock()
+
+	size, err := ds.logSize()
+
+	if err != nil {
+		ds.Store.Abort(c, txn)
+		return err
+	}
+
+	if err := ds.append(ops); err != nil {
+		ds.Store.Abort(c, txn)
+
+		if terr := ds.truncate(size); terr != nil {
+			return fmt.Errorf("%w, log not restored: %v", err, terr)
+		}
+
+		return err
+	}
+
+	if err := ds.Store.Commit(c, txn); err != nil {
+
+		if terr := ds.truncate(size); terr != nil {
+			return fmt.Errorf("%w, log entry %d not removed: %v", err, ds.seq, terr)
+		}
+
+		ds.seq--
+		ds.entries--
+
+		return err
+	}
+
+	if ds.interval > 0 && ds.entries >= ds.interval {
+
+		if err := ds.snapshot(c); err != nil && ds.onerror != nil {
+			ds.onerror(err)
+		}
+
+	}
+
+	return nil
+}
+
+// Abort aborts the transaction and discards all recorded writes.
+func (ds *DiskStore) Abort(c context.Context, txn storage.Transaction) {
+
+	ds.mu.Lock()
+	delete(ds.pending, txn.ID())
+	ds.mu.Unlock()
+
+	ds.Store.Abort(c, txn)
+}
+
+// Snapshot writes a snapshot of the whole store and truncates the log.
+func (ds *DiskStore) Snapshot(c context.Context) error {
+
+	ds.logMu.Lock()
+	defer ds.logMu.Unlock()
+
+	return ds.snapshot(c)
+}
+
+func (ds *DiskStore) record(txn storage.Transaction, op diskOp) {
+
+	ds.mu.Lock()
+	ds.pending[txn.ID()] = append(ds.pending[txn.ID()], op)
+	ds.mu.Unlock()
+}
+
+func (ds *DiskStore) path(name string) string {
+	return filepath.Join(ds.dir, name)
+}
+
+// logSize returns the current size of the log.
+func (ds *DiskStore) logSize() (int64, error) {
+
+	if ds.log == nil {
+		return 0, fmt.Errorf("disk store %s is closed", ds.dir)
+	}
+
+	fi, err := ds.log.Stat()
+
+	if err != nil {
+		return 0, err
+	}
+
+	return fi.Size(), nil
+}
+
+// truncate removes everything after _size_ in the log, e.g. a partially written or
+// uncommitted log entry.
+func (ds *DiskStore) truncate(size int64) error {
+
+	if err := ds.log.Truncate(size); err != nil {
+		return err
+	}
+
+	return ds.log.Sync()
+}
+
+// append writes a single log entry and syncs the log to disk.
+func (ds *DiskStore) append(ops []diskOp) error {
+
+	data, err := json.Marshal(diskEntry{Seq: ds.seq + 1, Ops: ops})
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := ds.log.Write(append(data, '\n')); err != nil {
+		return err
+	}
+
+	if err := ds.log.Sync(); err != nil {
+		return err
+	}
+
+	ds.seq++
+	ds.entries++
+
+	return nil
+}
+
+// snapshot writes the snapshot to a temporary file that replaces the current snapshot
+// before the log is truncated. The sequence number in the snapshot makes sure that no
+// transaction is replayed twice if the log could not be truncated.
+func (ds *DiskStore) snapshot(c context.Context) error {
+
+	snap := diskSnapshot{Seq: ds.seq, Policies: map[string][]byte{}}
+
+	err := storage.Txn(c, ds.Store, storage.TransactionParams{}, func(txn storage.Transaction) error {
+
+		data, err := ds.Store.Read(c, txn, storage.Path{})
+
+		if err != nil {
+			return err
+		}
+
+		if snap.Data, err = json.Marshal(data); err != nil {
+			return err
+		}
+
+		ids, err := ds.Store.ListPolicies(c, txn)
+
+		if err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+
+			if snap.Policies[id], err = ds.Store.GetPolicy(c, txn, id); err != nil {
+				return err
+			}
+
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(snap)
+
+	if err != nil {
+		return err
+	}
+
+	tmp := ds.path(DiskStoreSnapshotFile + ".tmp")
+
+	if err := writeFileSync(tmp, data); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, ds.path(DiskStoreSnapshotFile)); err != nil {
+		return err
+	}
+
+	if ds.log != nil {
+
+		if err := ds.log.Truncate(0); err != nil {
+			return err
+		}
+
+		if err := ds.log.Sync(); err != nil {
+			return err
+		}
+
+	}
+
+	ds.entries = 0
+	return nil
+}
+
+// loadSnapshot creates the in-memory store from the snapshot, if any.
+func (ds *DiskStore) loadSnapshot(c context.Context) error {
+
+	data, err := os.ReadFile(ds.path(DiskStoreSnapshotFile))
+
+	if os.IsNotExist(err) {
+		ds.Store = inmem.New()
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	var snap diskSnapshot
+
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("%s: %w", DiskStoreSnapshotFile, err)
+	}
+
+	var obj map[string]interface{}
+
+	if err := util.UnmarshalJSON(snap.Data, &obj); err != nil {
+		return fmt.Errorf("%s: %w", DiskStoreSnapshotFile, err)
+	}
+
+	if obj == nil {
+		obj = map[string]interface{}{}
+	}
+
+	ds.Store = inmem.NewFromObject(obj)
+	ds.seq = snap.Seq
+
+	if len(snap.Policies) == 0 {
+		return nil
+	}
+
+	return storage.Txn(c, ds.Store, storage.WriteParams, func(txn storage.Transaction) error {
+
+		for id, bs := range snap.Policies {
+
+			if err := ds.Store.UpsertPolicy(c, txn, id, bs); err != nil {
+				return err
+			}
+
+		}
+
+		return nil
+	})
+}
+
+// replay applies all logged transactions that are newer than the snapshot. A partially
+// written last entry is truncated from the log.
+func (ds *DiskStore) replay(c context.Context) error {
+
+	f, err := os.OpenFile(ds.path(DiskStoreLogFile), os.O_RDWR, 0644)
+
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	offset := int64(0)
+
+	for {
+
+		line, err := r.ReadBytes('\n')
+
+		if err == io.EOF {
+
+			if len(bytes.TrimSpace(line)) > 0 {
+				return f.Truncate(offset) // partially written entry
+			}
+
+			return nil
+
+		}
+
+		if err != nil {
+			return err
+		}
+
+		var entry diskEntry
+
+		if err := util.UnmarshalJSON(line, &entry); err != nil {
+			return fmt.Errorf("%s at offset %d: %w", DiskStoreLogFile, offset, err)
+		}
+
+		offset += int64(len(line))
+
+		if entry.Seq <= ds.seq {
+			continue
+		}
+
+		if err := ds.apply(c, entry.Ops); err != nil {
+			return fmt.Errorf("%s transaction %d: %w", DiskStoreLogFile, entry.Seq, err)
+		}
+
+		ds.seq = entry.Seq
+		ds.entries++
+	}
+}
+
+// apply applies the logged operations in a single transaction on the in-memory store.
+func (ds *DiskStore) apply(c context.Context, ops []diskOp) error {
+
+	return storage.Txn(c, ds.Store, storage.WriteParams, func(txn storage.Transaction) error {
+
+		for _, op := range ops {
+
+			var value interface{}
+
+			if len(op.Value) > 0 {
+
+				if err := util.UnmarshalJSON(op.Value, &value); err != nil {
+					return err
+				}
+
+			}
+
+			var err error
+
+			switch op.Op {
+			case "add":
+				err = ds.Store.Write(c, txn, storage.AddOp, op.Path, value)
+			case "remove":
+				err = ds.Store.Write(c, txn, storage.RemoveOp, op.Path, nil)
+			case "replace":
+				err = ds.Store.Write(c, txn, storage.ReplaceOp, op.Path, value)
+			case "upsert_policy":
+
+				var bs []byte
+
+				if err = json.Unmarshal(op.Value, &bs); err == nil {
+					err = ds.Store.UpsertPolicy(c, txn, op.ID, bs)
+				}
+
+			case "delete_policy":
+				err = ds.Store.DeletePolicy(c, txn, op.ID)
+			default:
+				err = fmt.Errorf("unknown operation %s", op.Op)
+			}
+
+			if err != nil {
+				return err
+			}
+
+		}
+
+		return nil
+	})
+}
+
+// writeFileSync writes and syncs _data_ to the file _name_.
+func writeFileSync(name string, data []byte) error {
+
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
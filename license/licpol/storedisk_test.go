@@ -0,0 +1,171 @@
+package licpol
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiskStoreSurvivesRestart(t *testing.T) {
+
+	c := context.Background()
+	dir := t.TempDir()
+
+	store, err := NewDiskStore(c, dir)
+	assert.Equal(t, nil, err)
+
+	sm := NewStoreManager(c, store)
+
+	assert.Equal(t, nil, sm.Upsert(c, "revoked/abc", true))
+	assert.Equal(t, nil, sm.Upsert(c, "usage/acme", 1))
+	assert.Equal(t, nil, sm.Upsert(c, "usage/acme", 2))
+	assert.Equal(t, nil, sm.Delete(c, "revoked/abc"))
+	assert.Equal(t, nil, store.Close())
+
+	store, err = NewDiskStore(c, dir)
+	assert.Equal(t, nil, err)
+
+	defer store.Close()
+
+	v, err := storage.ReadOne(c, store, storage.Path{"usage", "acme"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, json.Number("2"), v)
+
+	_, err = storage.ReadOne(c, store, storage.Path{"revoked", "abc"})
+	assert.Equal(t, true, storage.IsNotFound(err))
+}
+
+func TestDiskStoreSnapshotAndTruncatedLog(t *testing.T) {
+
+	c := context.Background()
+	dir := t.TempDir()
+
+	store, err := NewDiskStore(c, dir)
+	assert.Equal(t, nil, err)
+
+	sm := NewStoreManager(c, store.SnapshotInterval(2))
+
+	assert.Equal(t, nil, sm.Upsert(c, "usage/a", 1))
+	assert.Equal(t, nil, sm.Upsert(c, "usage/b", 2)) // snapshot
+	assert.Equal(t, nil, sm.Upsert(c, "usage/c", 3))
+	assert.Equal(t, nil, store.Close())
+
+	_, err = os.Stat(filepath.Join(dir, DiskStoreSnapshotFile))
+	assert.Equal(t, nil, err)
+
+	// simulate a crash while writing a transaction
+	f, err := os.OpenFile(filepath.Join(dir, DiskStoreLogFile), os.O_WRONLY|os.O_APPEND, 0644)
+	assert.Equal(t, nil, err)
+
+	_, err = f.WriteString(`{"seq": 4, "ops": [{"op": "add", "pa`)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, f.Close())
+
+	store, err = NewDiskStore(c, dir)
+	assert.Equal(t, nil, err)
+
+	v, err := storage.ReadOne(c, store, storage.Path{"usage"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, map[string]interface{}{
+		"a": json.Number("1"),
+		"b": json.Number("2"),
+		"c": json.Number("3"),
+	}, v)
+
+	assert.Equal(t, nil, NewStoreManager(c, store).Upsert(c, "usage/d", 4))
+	assert.Equal(t, nil, store.Close())
+
+	store, err = NewDiskStore(c, dir)
+	assert.Equal(t, nil, err)
+
+	defer store.Close()
+
+	v, err = storage.ReadOne(c, store, storage.Path{"usage", "d"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, json.Number("4"), v)
+}
+
+// failingCommitStore fails all commits such as a in-memory store rejecting a transaction.
+type failingCommitStore struct {
+	storage.Store
+}
+
+func (s *failingCommitStore) Commit(c context.Context, txn storage.Transaction) error {
+
+	s.Store.Abort(c, txn)
+	return errors.New("commit failed")
+}
+
+func TestDiskStoreFailedCommitIsNotReplayed(t *testing.T) {
+
+	c := context.Background()
+	dir := t.TempDir()
+
+	store, err := NewDiskStore(c, dir)
+	assert.Equal(t, nil, err)
+
+	sm := NewStoreManager(c, store)
+	assert.Equal(t, nil, sm.Upsert(c, "usage/a", 1))
+
+	inner := store.Store
+	store.Store = &failingCommitStore{Store: inner}
+
+	assert.EqualError(t, sm.Upsert(c, "usage/b", 2), "commit failed")
+
+	store.Store = inner
+
+	assert.Equal(t, nil, sm.Upsert(c, "usage/c", 3))
+	assert.Equal(t, nil, store.Close())
+
+	store, err = NewDiskStore(c, dir)
+	assert.Equal(t, nil, err)
+
+	defer store.Close()
+
+	v, err := storage.ReadOne(c, store, storage.Path{"usage"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, map[string]interface{}{
+		"a": json.Number("1"),
+		"c": json.Number("3"),
+	}, v)
+}
+
+func TestDiskStoreFailedSnapshotIsRetried(t *testing.T) {
+
+	c := context.Background()
+	dir := t.TempDir()
+
+	// a directory in place of the temporary snapshot file makes the snapshot fail
+	tmp := filepath.Join(dir, DiskStoreSnapshotFile+".tmp")
+	assert.Equal(t, nil, os.Mkdir(tmp, 0755))
+
+	store, err := NewDiskStore(c, dir)
+	assert.Equal(t, nil, err)
+
+	defer store.Close()
+
+	var errs []error
+
+	sm := NewStoreManager(c, store.SnapshotInterval(1).OnError(func(err error) {
+		errs = append(errs, err)
+	}))
+
+	assert.Equal(t, nil, sm.Upsert(c, "usage/a", 1))
+	assert.Equal(t, 1, len(errs))
+
+	_, err = os.Stat(filepath.Join(dir, DiskStoreSnapshotFile))
+	assert.Equal(t, true, os.IsNotExist(err))
+
+	assert.Equal(t, nil, os.Remove(tmp))
+	assert.Equal(t, nil, sm.Upsert(c, "usage/b", 2))
+	assert.Equal(t, 1, len(errs))
+
+	_, err = os.Stat(filepath.Join(dir, DiskStoreSnapshotFile))
+	assert.Equal(t, nil, err)
+}
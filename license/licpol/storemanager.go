@@ -0,0 +1,438 @@
+package licpol
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/mariotoffia/gojwtlic/license"
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/storage/inmem"
+	"github.com/open-policy-agent/opa/util"
+)
+
+// StoreChangeFunc is invoked when data has been committed to the store of a `StoreManager`.
+// The _paths_ are all paths that where written or removed in the transaction.
+//
+// When the write was not done through the `StoreManager`, the _paths_ are those reported by
+// the store. The in-memory store coalesces writes below a path that was written earlier in
+// the same transaction into that path.
+//
+// The function is invoked while the store is committing and must therefore not access the
+// store.
+type StoreChangeFunc func(sm *StoreManager, paths []storage.Path)
+
+// StoreManager manages a mutable data store where paths may be upserted and deleted at
+// runtime.
+//
+// All writes to the store, also those not done through the `StoreManager`, are reported
+// to the functions registered with `OnChange`.
+//
+// .Example Usage
+// [source,go]
+// ....
+// sm := NewInMemStoreBuilder(ctx).
+// AddJSON("limits", `{"max_users": 10}`).
+// BuildManager().
+// OnChange(cache.OnStoreChange)
+//
+// pctx.UseStore(sm.Store())
+//
+// err := sm.Upsert(ctx, "limits/max_users", 20)
+// ....
+type StoreManager struct {
+	mu       sync.RWMutex
+	store    storage.Store
+	onchange []StoreChangeFunc
+	written  map[uint64][]storage.Path
+}
+
+// StoreTxn is a write transaction on a `StoreManager` store. It is only valid within the
+// function passed to `StoreManager.Update`.
+type StoreTxn struct {
+	c     context.Context
+	store storage.Store
+	txn   storage.Transaction
+	paths []storage.Path
+}
+
+// PatchOperation is a single _JSON Patch_ (_RFC 6902_) operation.
+type PatchOperation struct {
+	// Op is one of "add", "remove", "replace", "move", "copy" or "test".
+	Op string `json:"op"`
+	// Path is the _JSON Pointer_ to operate on.
+	Path string `json:"path"`
+	// From is the _JSON Pointer_ to move or copy from.
+	From string `json:"from,omitempty"`
+	// Value is the value to add, replace or test.
+	Value interface{} `json:"value,omitempty"`
+}
+
+// NewStoreManager creates a new `StoreManager` that manages _store_. If _store_ is `nil`
+// an empty in-memory store is created.
+//
+// It will panic if the _store_ does not support triggers.
+func NewStoreManager(c context.Context, store storage.Store) *StoreManager {
+
+	if store == nil {
+		store = inmem.New()
+	}
+
+	sm := &StoreManager{store: store, written: map[uint64][]storage.Path{}}
+
+	err := storage.Txn(c, store, storage.WriteParams, func(txn storage.Transaction) error {
+
+		_, err := store.Register(c, txn, storage.TriggerConfig{OnCommit: sm.onCommit})
+		return err
+
+	})
+
+	if err != nil {
+		panic(fmt.Sprintf("cannot register store trigger: %s", err))
+	}
+
+	return sm
+}
+
+// BuildManager will build the store and wrap it in a `StoreManager`.
+func (isb *InMemStoreBuilder) BuildManager() *StoreManager {
+
+	store := isb.Build()
+
+	if store == nil {
+		return nil
+	}
+
+	return NewStoreManager(isb.ctx, store)
+}
+
+// Store returns the managed store, e.g. to be used in `PolicyContext.UseStore`.
+func (sm *StoreManager) Store() storage.Store {
+	return sm.store
+}
+
+// OnChange registers a function that is invoked each time data has been committed.
+func (sm *StoreManager) OnChange(f StoreChangeFunc) *StoreManager {
+
+	sm.mu.Lock()
+	sm.onchange = append(sm.onchange, f)
+	sm.mu.Unlock()
+
+	return sm
+}
+
+// Read reads the value of _path_. The path is separated with '/'.
+func (sm *StoreManager) Read(c context.Context, path string) (interface{}, error) {
+	return storage.ReadOne(c, sm.store, splitStorePath(path))
+}
+
+// Upsert writes the _value_ at _path_ in a single transaction. The path is separated
+// with '/' and any missing parent is created.
+func (sm *StoreManager) Upsert(c context.Context, path string, value interface{}) error {
+
+	return sm.Update(c, func(txn *StoreTxn) error {
+		return txn.Upsert(path, value)
+	})
+
+}
+
+// Delete removes the _path_ in a single transaction. It is not an error if the path does
+// not exist.
+func (sm *StoreManager) Delete(c context.Context, path string) error {
+
+	return sm.Update(c, func(txn *StoreTxn) error {
+		return txn.Delete(path)
+	})
+
+}
+
+// SetLicense replaces the license data, _data.license_, with _info_.
+func (sm *StoreManager) SetLicense(c context.Context, info *license.FeatureInfo) error {
+	return sm.Upsert(c, "license", info)
+}
+
+// ApplyPatch applies a _JSON Patch_ document in a single transaction. Either all
+// operations are applied or none.
+func (sm *StoreManager) ApplyPatch(c context.Context, patch []byte) error {
+
+	var ops []PatchOperation
+
+	if err := util.UnmarshalJSON(patch, &ops); err != nil {
+		return err
+	}
+
+	return sm.Update(c, func(txn *StoreTxn) error {
+		return txn.Patch(ops...)
+	})
+
+}
+
+// Update runs _f_ in a write transaction. If _f_ returns a error, the transaction is
+// aborted, otherwise committed.
+//
+// .Example Usage
+// [source,go]
+// ....
+// err := sm.Update(ctx, func(txn *StoreTxn) error {
+// if err := txn.Upsert("limits/max_users", 20); err != nil {
+// return err
+// }
+// return txn.Delete("limits/trial")
+// })
+// ....
+func (sm *StoreManager) Update(c context.Context, f func(txn *StoreTxn) error) error {
+
+	var id uint64
+
+	defer func() {
+		sm.mu.Lock()
+		delete(sm.written, id)
+		sm.mu.Unlock()
+	}()
+
+	return storage.Txn(c, sm.store, storage.WriteParams, func(txn storage.Transaction) error {
+
+		st := &StoreTxn{c: c, store: sm.store, txn: txn}
+
+		if err := f(st); err != nil {
+			return err
+		}
+
+		// the store may coalesce the writes, hence the committed paths are recorded
+		id = txn.ID()
+
+		sm.mu.Lock()
+		sm.written[id] = st.paths
+		sm.mu.Unlock()
+
+		return nil
+	})
+
+}
+
+// onCommit dispatches the written paths to all registered change functions.
+func (sm *StoreManager) onCommit(c context.Context, txn storage.Transaction, event storage.TriggerEvent) {
+
+	if !event.DataChanged() {
+		return
+	}
+
+	sm.mu.RLock()
+	paths, ok := sm.written[txn.ID()]
+	sm.mu.RUnlock()
+
+	if !ok {
+
+		paths = make([]storage.Path, 0, len(event.Data))
+
+		for _, d := range event.Data {
+			paths = append(paths, d.Path)
+		}
+
+	}
+
+	sm.mu.RLock()
+	onchange := append([]StoreChangeFunc{}, sm.onchange...)
+	sm.mu.RUnlock()
+
+	for _, f := range onchange {
+		f(sm, paths)
+	}
+}
+
+// Read reads the value of _path_. The path is separated with '/'.
+func (st *StoreTxn) Read(path string) (interface{}, error) {
+	return st.store.Read(st.c, st.txn, splitStorePath(path))
+}
+
+// Upsert writes the _value_ at _path_. The path is separated with '/' and any missing
+// parent is created. The _value_ may be any value that can be marshalled to _JSON_.
+func (st *StoreTxn) Upsert(path string, value interface{}) error {
+	return st.upsert(splitStorePath(path), value)
+}
+
+// Delete removes the _path_. It is not an error if the path does not exist.
+func (st *StoreTxn) Delete(path string) error {
+
+	err := st.storeWrite(storage.RemoveOp, splitStorePath(path), nil)
+
+	if storage.IsNotFound(err) {
+		return nil
+	}
+
+	return err
+}
+
+// Patch applies the _JSON Patch_ operations in order.
+func (st *StoreTxn) Patch(ops ...PatchOperation) error {
+
+	for i, op := range ops {
+
+		if err := st.patch(op); err != nil {
+			return fmt.Errorf("patch operation %d (%s %s): %w", i, op.Op, op.Path, err)
+		}
+
+	}
+
+	return nil
+}
+
+func (st *StoreTxn) patch(op PatchOperation) error {
+
+	path, err := parseJSONPointer(op.Path)
+
+	if err != nil {
+		return err
+	}
+
+	switch op.Op {
+	case "add":
+		return st.write(storage.AddOp, path, op.Value)
+	case "remove":
+		return st.storeWrite(storage.RemoveOp, path, nil)
+	case "replace":
+		return st.write(storage.ReplaceOp, path, op.Value)
+	case "test":
+
+		value, err := st.store.Read(st.c, st.txn, path)
+
+		if err != nil {
+			return err
+		}
+
+		expected, err := jsonValue(op.Value)
+
+		if err != nil {
+			return err
+		}
+
+		if util.Compare(value, expected) != 0 {
+			return fmt.Errorf("test failed, value is %v", value)
+		}
+
+		return nil
+	case "move", "copy":
+
+		from, err := parseJSONPointer(op.From)
+
+		if err != nil {
+			return err
+		}
+
+		value, err := st.store.Read(st.c, st.txn, from)
+
+		if err != nil {
+			return err
+		}
+
+		if op.Op == "move" {
+
+			if err := st.storeWrite(storage.RemoveOp, from, nil); err != nil {
+				return err
+			}
+
+		}
+
+		return st.write(storage.AddOp, path, value)
+	}
+
+	return fmt.Errorf("unsupported operation %s", op.Op)
+}
+
+// upsert replaces the value if _path_ exists, otherwise all missing parents are created
+// and the value is added.
+func (st *StoreTxn) upsert(path storage.Path, value interface{}) error {
+
+	if len(path) == 0 {
+		return st.write(storage.ReplaceOp, path, value)
+	}
+
+	if _, err := st.store.Read(st.c, st.txn, path); err == nil {
+		return st.write(storage.ReplaceOp, path, value)
+	} else if !storage.IsNotFound(err) {
+		return err
+	}
+
+	if err := storage.MakeDir(st.c, st.store, st.txn, path[:len(path)-1]); err != nil {
+		return err
+	}
+
+	return st.write(storage.AddOp, path, value)
+}
+
+// write converts the _value_ to a _JSON_ value before writing it.
+func (st *StoreTxn) write(op storage.PatchOp, path storage.Path, value interface{}) error {
+
+	v, err := jsonValue(value)
+
+	if err != nil {
+		return err
+	}
+
+	return st.storeWrite(op, path, v)
+}
+
+// storeWrite writes to the store and records the _path_ if successful.
+func (st *StoreTxn) storeWrite(op storage.PatchOp, path storage.Path, value interface{}) error {
+
+	if err := st.store.Write(st.c, st.txn, op, path, value); err != nil {
+		return err
+	}
+
+	st.paths = append(st.paths, path)
+	return nil
+}
+
+// jsonValue converts _value_ into a value that only consists of _JSON_ types such that
+// it never shares state with the caller.
+func jsonValue(value interface{}) (interface{}, error) {
+
+	data, err := json.Marshal(value)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var v interface{}
+
+	if err := util.UnmarshalJSON(data, &v); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// splitStorePath splits a '/' separated path. Leading and trailing '/' are ignored and
+// the empty path is the root document.
+func splitStorePath(path string) storage.Path {
+
+	path = strings.Trim(path, "/")
+
+	if path == "" {
+		return storage.Path{}
+	}
+
+	return strings.Split(path, "/")
+}
+
+// parseJSONPointer parses a _RFC 6901 JSON Pointer_ into a path.
+func parseJSONPointer(pointer string) (storage.Path, error) {
+
+	if pointer == "" {
+		return storage.Path{}, nil
+	}
+
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid json pointer %q", pointer)
+	}
+
+	path := strings.Split(pointer[1:], "/")
+
+	for i, p := range path {
+		path[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(p)
+	}
+
+	return path, nil
+}
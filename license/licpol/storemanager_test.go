@@ -0,0 +1,109 @@
+package licpol
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/mariotoffia/gojwtlic/license"
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStoreManagerUpsertAndDelete(t *testing.T) {
+
+	c := context.Background()
+
+	sm := NewInMemStoreBuilder(c).
+		AddJSON("limits", `{"max_users": 10}`).
+		BuildManager()
+
+	var changed []storage.Path
+	sm.OnChange(func(sm *StoreManager, paths []storage.Path) {
+		changed = append(changed, paths...)
+	})
+
+	assert.Equal(t, nil, sm.Upsert(c, "limits/max_users", 20))
+	assert.Equal(t, nil, sm.Upsert(c, "tenants/acme/seats", 5))
+
+	v, err := sm.Read(c, "limits/max_users")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, json.Number("20"), v)
+
+	v, err = sm.Read(c, "tenants")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, map[string]interface{}{"acme": map[string]interface{}{"seats": json.Number("5")}}, v)
+
+	assert.Equal(t, nil, sm.Delete(c, "tenants/acme"))
+	assert.Equal(t, nil, sm.Delete(c, "tenants/acme"))
+
+	_, err = sm.Read(c, "tenants/acme")
+	assert.Equal(t, true, storage.IsNotFound(err))
+
+	assert.Equal(t, []storage.Path{
+		{"limits", "max_users"},
+		{"tenants", "acme", "seats"},
+		{"tenants", "acme"},
+	}, changed)
+
+	// not written through the manager
+	changed = nil
+	assert.Equal(t, nil, storage.WriteOne(c, sm.Store(), storage.AddOp, storage.Path{"limits", "trial"}, true))
+	assert.Equal(t, []storage.Path{{"limits", "trial"}}, changed)
+}
+
+func TestStoreManagerApplyPatchIsAtomic(t *testing.T) {
+
+	c := context.Background()
+	sm := NewStoreManager(c, nil)
+
+	assert.Equal(t, nil, sm.ApplyPatch(c, []byte(`[
+		{"op": "add", "path": "/limits", "value": {"max_users": 10, "trial": true}},
+		{"op": "replace", "path": "/limits/max_users", "value": 5},
+		{"op": "copy", "from": "/limits/max_users", "path": "/limits/min_users"},
+		{"op": "move", "from": "/limits/trial", "path": "/trial"},
+		{"op": "test", "path": "/trial", "value": true}
+	]`)))
+
+	v, err := sm.Read(c, "")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, map[string]interface{}{
+		"limits": map[string]interface{}{"max_users": json.Number("5"), "min_users": json.Number("5")},
+		"trial":  true,
+	}, v)
+
+	err = sm.ApplyPatch(c, []byte(`[
+		{"op": "remove", "path": "/trial"},
+		{"op": "test", "path": "/limits/max_users", "value": 6}
+	]`))
+
+	assert.NotEqual(t, nil, err)
+
+	v, err = sm.Read(c, "trial")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, v)
+}
+
+func TestStoreManagerReplacesLicenseAndInvalidatesCache(t *testing.T) {
+
+	c := context.Background()
+	dc := NewDecisionCache(10, 0)
+
+	sm := NewStoreManager(c, nil).OnChange(dc.OnStoreChange)
+
+	info := &license.FeatureInfo{}
+	info.WithSubject("nisse").Feature("ui")
+
+	assert.Equal(t, nil, sm.SetLicense(c, info))
+
+	info = &license.FeatureInfo{}
+	info.WithSubject("hult").Feature("simulator")
+
+	assert.Equal(t, nil, sm.SetLicense(c, info))
+
+	v, err := sm.Read(c, "license/sub")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "hult", v)
+
+	assert.Equal(t, uint64(2), dc.Stats().Invalidations)
+}
@@ -0,0 +1,421 @@
+package licsrv
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mariotoffia/gojwtlic/license"
+)
+
+const (
+	// DefaultRetries is the default number of retries of a failed request.
+	DefaultRetries = 3
+	// DefaultBackoff is the default delay before the first retry. The delay is doubled
+	// for each retry.
+	DefaultBackoff = 500 * time.Millisecond
+)
+
+// errRetry marks a failure that is worth retrying, i.e. a network error or a server
+// error.
+var errRetry = errors.New("temporary failure")
+
+// Client is a client of a license `Server`. It authenticates using the _OAuth 2.0_
+// client credentials grant, retries failed requests and caches the last good license
+// for offline periods.
+//
+// .Example Usage
+// [source,go]
+// ....
+// client := licsrv.NewClient("https://lic.valmatics.se", "mortviken", "secret", fpt).
+// CacheFile("/var/lib/valmatics/license.jwt").
+// Validator(licbuiltin.NewValidator(keys))
+//
+// lic, err := client.Activate(ctx)
+// ....
+type Client struct {
+	mu            sync.Mutex
+	url           string
+	clientID      string
+	clientSecret  string
+	fingerprint   string
+	client        *http.Client
+	retries       int
+	backoff       time.Duration
+	validator     license.Validator
+	cacheFile     string
+	token         string
+	tokenExpires  time.Time
+	license       string
+	offline       bool
+	revocationSeq int64
+	revoked       map[string]bool
+}
+
+// NewClient creates a new `Client` to the server at _url_ that authenticates using
+// _clientID_ and _clientSecret_ for the installation with the _fingerprint_.
+func NewClient(url, clientID, clientSecret, fingerprint string) *Client {
+
+	return &Client{
+		url:          strings.TrimSuffix(url, "/"),
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		fingerprint:  fingerprint,
+		client:       http.DefaultClient,
+		retries:      DefaultRetries,
+		backoff:      DefaultBackoff,
+		revoked:      map[string]bool{},
+	}
+
+}
+
+// NewClientFromLicense is the same as `NewClient` but uses the `license.OauthInfo`
+// credentials of _info_.
+//
+// NOTE: The `Server` never embeds the client secret in the licenses it issues, hence
+// this is only useful with licenses that carries the secret, preferably encrypted using
+// a `license.ClaimEncryptor`.
+func NewClientFromLicense(url string, info *license.FeatureInfo, fingerprint string) *Client {
+	return NewClient(url, info.ClientID, info.ClientSecret, fingerprint)
+}
+
+// HTTPClient sets the _HTTP_ client to use. Default is `http.DefaultClient`.
+func (c *Client) HTTPClient(client *http.Client) *Client {
+	c.client = client
+	return c
+}
+
+// Retries sets the number of _retries_ and the delay before the first retry.
+func (c *Client) Retries(retries int, backoff time.Duration) *Client {
+	c.retries = retries
+	c.backoff = backoff
+	return c
+}
+
+// Validator sets a validator that each received license must pass before it is cached
+// and that the cached license must pass when used offline.
+func (c *Client) Validator(validator license.Validator) *Client {
+	c.validator = validator
+	return c
+}
+
+// CacheFile sets a file where the last good license is persisted. If the file exists,
+// it is used as the cached license.
+func (c *Client) CacheFile(file string) *Client {
+
+	c.cacheFile = file
+
+	if data, err := ioutil.ReadFile(file); err == nil {
+		c.license = strings.TrimSpace(string(data))
+	}
+
+	return c
+}
+
+// Offline returns `true` if the last license was served from the cache since the server
+// could not be reached.
+func (c *Client) Offline() bool {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.offline
+}
+
+// Activate activates this installation and returns the license.
+func (c *Client) Activate(ctx context.Context) (string, error) {
+
+	body, err := json.Marshal(&ActivationRequest{Fingerprint: c.fingerprint})
+
+	if err != nil {
+		return "", err
+	}
+
+	var resp LicenseResponse
+
+	if err := c.do(ctx, http.MethodPost, "/v1/activations", body, &resp); err != nil {
+		return "", err
+	}
+
+	return resp.License, c.store(resp.License)
+}
+
+// Deactivate deactivates this installation and removes the cached license.
+func (c *Client) Deactivate(ctx context.Context) error {
+
+	if err := c.do(ctx, http.MethodDelete, "/v1/activations/"+url.PathEscape(c.fingerprint), nil, nil); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.license = ""
+
+	if c.cacheFile != "" {
+
+		if err := os.Remove(c.cacheFile); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+	}
+
+	return nil
+}
+
+// License fetches the current license of this installation. If the server can not be
+// reached, the last good license is returned and `Offline` returns `true`.
+func (c *Client) License(ctx context.Context) (string, error) {
+
+	var resp LicenseResponse
+
+	err := c.do(ctx, http.MethodGet, "/v1/license?fpt="+url.QueryEscape(c.fingerprint), nil, &resp)
+
+	if err == nil {
+		return resp.License, c.store(resp.License)
+	}
+
+	if !errors.Is(err, errRetry) {
+		return "", err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.license == "" {
+		return "", err
+	}
+
+	if c.validator != nil {
+
+		if _, verr := c.validator.Validate(c.license); verr != nil {
+			return "", fmt.Errorf("%s, cached license: %w", err, verr)
+		}
+
+	}
+
+	c.offline = true
+	return c.license, nil
+}
+
+// UpdateRevocations downloads the revocations since the last update and returns all
+// revoked license ids.
+func (c *Client) UpdateRevocations(ctx context.Context) ([]string, error) {
+
+	c.mu.Lock()
+	since := c.revocationSeq
+	c.mu.Unlock()
+
+	var resp RevocationResponse
+
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/v1/revocations?since=%d", since), nil, &resp); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, id := range resp.Revoked {
+		c.revoked[id] = true
+	}
+
+	c.revocationSeq = resp.Seq
+
+	ids := make([]string, 0, len(c.revoked))
+
+	for id := range c.revoked {
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// IsRevoked returns `true` if the _licenseID_ was revoked in any of the downloaded
+// revocations.
+func (c *Client) IsRevoked(licenseID string) bool {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.revoked[licenseID]
+}
+
+// store validates and caches the _lic_ as the last good license.
+func (c *Client) store(lic string) error {
+
+	if c.validator != nil {
+
+		info, err := c.validator.Validate(lic)
+
+		if err != nil {
+			return err
+		}
+
+		if info.Fingerprint != c.fingerprint {
+			return fmt.Errorf("license is bound to fingerprint %s", info.Fingerprint)
+		}
+
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.license = lic
+	c.offline = false
+
+	if c.cacheFile != "" {
+		return ioutil.WriteFile(c.cacheFile, []byte(lic), 0600)
+	}
+
+	return nil
+}
+
+// do invokes the _path_ with a access token and decodes the response into _out_ if
+// not `nil`. A rejected access token is renewed once.
+func (c *Client) do(ctx context.Context, method, path string, body []byte, out interface{}) error {
+
+	for attempt := 0; ; attempt++ {
+
+		token, err := c.accessToken(ctx, attempt > 0)
+
+		if err != nil {
+			return err
+		}
+
+		err = c.retry(ctx, func() error {
+
+			req, err := http.NewRequestWithContext(ctx, method, c.url+path, bytes.NewReader(body))
+
+			if err != nil {
+				return err
+			}
+
+			req.Header.Set("Authorization", "Bearer "+token)
+
+			if body != nil {
+				req.Header.Set("Content-Type", "application/json")
+			}
+
+			return c.send(req, out)
+		})
+
+		if attempt == 0 && errors.Is(err, ErrInvalidClient) {
+			continue
+		}
+
+		return err
+	}
+
+}
+
+// accessToken returns the cached access token or requests a new if expired or _renew_.
+func (c *Client) accessToken(ctx context.Context, renew bool) (string, error) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !renew && c.token != "" && time.Now().Before(c.tokenExpires) {
+		return c.token, nil
+	}
+
+	var resp tokenResponse
+
+	err := c.retry(ctx, func() error {
+
+		form := url.Values{"grant_type": {"client_credentials"}}
+		req, err := http.NewRequestWithContext(
+			ctx, http.MethodPost, c.url+"/oauth/token", strings.NewReader(form.Encode()),
+		)
+
+		if err != nil {
+			return err
+		}
+
+		req.SetBasicAuth(c.clientID, c.clientSecret)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		return c.send(req, &resp)
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	c.token = resp.AccessToken
+	// renew a bit before the server expires the token
+	c.tokenExpires = time.Now().Add(time.Duration(resp.ExpiresIn)*time.Second - 10*time.Second)
+
+	return c.token, nil
+}
+
+// retry invokes _fn_ until it succeeds, fails with a non `errRetry` error or all retries
+// are exhausted.
+func (c *Client) retry(ctx context.Context, fn func() error) error {
+
+	backoff := c.backoff
+
+	for attempt := 0; ; attempt++ {
+
+		err := fn()
+
+		if err == nil || !errors.Is(err, errRetry) || attempt >= c.retries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %s", errRetry, ctx.Err())
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+	}
+
+}
+
+// send sends the _req_ and maps the status code to an error.
+func (c *Client) send(req *http.Request, out interface{}) error {
+
+	resp, err := c.client.Do(req)
+
+	if err != nil {
+		return fmt.Errorf("%w: %s", errRetry, err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+
+		if out == nil || resp.StatusCode == http.StatusNoContent {
+			return nil
+		}
+
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	err = fmt.Errorf("license server returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return fmt.Errorf("%w: %s", ErrInvalidClient, err)
+	case resp.StatusCode == http.StatusNotFound:
+		return fmt.Errorf("%w: %s", ErrNotActivated, err)
+	case resp.StatusCode == http.StatusConflict:
+		return fmt.Errorf("%w: %s", ErrInstallationLimit, err)
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("%w: %s", errRetry, err)
+	}
+
+	return err
+}
@@ -0,0 +1,631 @@
+package licsrv
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mariotoffia/gojwtlic/license"
+)
+
+var (
+	// ErrInvalidClient is returned (wrapped) when the client credentials, or the access
+	// token, is not accepted.
+	ErrInvalidClient = errors.New("invalid client")
+	// ErrNotActivated is returned (wrapped) when the installation is not activated.
+	ErrNotActivated = errors.New("installation not activated")
+	// ErrInstallationLimit is returned (wrapped) when all installations of an account
+	// are activated.
+	ErrInstallationLimit = errors.New("installation limit reached")
+)
+
+const (
+	// DefaultTokenLength is the default lifetime of an access token.
+	DefaultTokenLength = time.Hour
+	// tokenPruneInterval is the minimum interval between sweeps of expired access tokens.
+	tokenPruneInterval = time.Minute
+	// maxRequestSize is the maximum size of a request body.
+	maxRequestSize = 1 << 16
+)
+
+// Account is a customer account that a product authenticates as using the _OAuth 2.0_
+// client credentials grant.
+type Account struct {
+	// ClientID is the _OAuth 2.0_ client id of the account.
+	ClientID string
+	// ClientSecret is the _OAuth 2.0_ client secret of the account. It is never embedded
+	// in the issued licenses, only the client id is.
+	ClientSecret string
+	// Subject is the subject of all licenses issued to the account.
+	Subject string
+	// Edition is the, optional, edition selection that the licenses are created from.
+	Edition license.EditionSelection
+	// Features is the features that are granted when no `Account.Edition` is selected.
+	Features []string
+	// MaxInstallations is the number of installations that may be activated at the
+	// same time. Zero is unlimited.
+	MaxInstallations int
+}
+
+// ActivationRequest is the body of a activation.
+type ActivationRequest struct {
+	// Fingerprint is the machine fingerprint of the installation.
+	Fingerprint string `json:"fpt"`
+	// Product is the name of the product.
+	Product string `json:"product,omitempty"`
+	// Version is the version of the product.
+	Version string `json:"ver,omitempty"`
+}
+
+// LicenseResponse is the response of a activation or when fetching the license.
+type LicenseResponse struct {
+	// License is the license _JWT_.
+	License string `json:"license"`
+}
+
+// RevocationResponse is the response of a revocation update.
+type RevocationResponse struct {
+	// Seq is the sequence number of the last revocation. Pass it as _since_ in the next
+	// request to get only new revocations.
+	Seq int64 `json:"seq"`
+	// Revoked is the license ids that was revoked after _since_.
+	Revoked []string `json:"revoked"`
+}
+
+// tokenResponse is the _OAuth 2.0_ access token response.
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// accessToken is a issued access token.
+type accessToken struct {
+	clientID string
+	expires  time.Time
+}
+
+// installation is a activated installation of an account.
+type installation struct {
+	ActivationRequest
+	license string
+	info    *license.FeatureInfo
+}
+
+// Server is a license server that is mounted as a `http.Handler`. All state is kept in
+// memory.
+//
+// The endpoints are:
+//
+// POST /oauth/token, _OAuth 2.0_ client credentials grant.
+// POST /v1/activations, activates the installation in the `ActivationRequest` body.
+// DELETE /v1/activations/{fpt}, deactivates an installation and revokes it's license.
+// GET /v1/license?fpt={fpt}, gets the current license of an installation.
+// GET /v1/revocations?since={seq}, gets the revoked license ids after _seq_.
+//
+// .Example Usage
+// [source,go]
+// ....
+// srv := licsrv.NewServer(generator).
+// AddAccount(licsrv.Account{
+// ClientID:         "mortviken",
+// ClientSecret:     "secret",
+// Subject:          "Mörtvikens Såg AB",
+// Edition:          license.EditionSelection{Edition: "pro"},
+// MaxInstallations: 2,
+// })
+//
+// http.ListenAndServe(":8080", srv)
+// ....
+//
+// The _mu_ guards all maps and the revocation list but is never held while a license is
+// issued, hence a slow signer do not block other requests.
+type Server struct {
+	mu            sync.Mutex
+	generator     *license.GeneratorBuilder
+	tokenLength   time.Duration
+	accounts      map[string]*Account
+	tokens        map[string]*accessToken
+	pruned        time.Time
+	installations map[string]map[string]*installation
+	revoked       []string
+	mux           *http.ServeMux
+}
+
+// NewServer creates a new `Server` that issues licenses using _generator_.
+func NewServer(generator *license.GeneratorBuilder) *Server {
+
+	if generator == nil {
+		panic("generator must be specified")
+	}
+
+	s := &Server{
+		generator:     generator,
+		tokenLength:   DefaultTokenLength,
+		accounts:      map[string]*Account{},
+		tokens:        map[string]*accessToken{},
+		installations: map[string]map[string]*installation{},
+		mux:           http.NewServeMux(),
+	}
+
+	s.mux.HandleFunc("/oauth/token", s.handleToken)
+	s.mux.HandleFunc("/v1/activations", s.authenticated(s.handleActivate))
+	s.mux.HandleFunc("/v1/activations/", s.authenticated(s.handleDeactivate))
+	s.mux.HandleFunc("/v1/license", s.authenticated(s.handleLicense))
+	s.mux.HandleFunc("/v1/revocations", s.authenticated(s.handleRevocations))
+
+	return s
+}
+
+// TokenLength sets the lifetime of issued access tokens.
+func (s *Server) TokenLength(length time.Duration) *Server {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokenLength = length
+	return s
+}
+
+// AddAccount adds, or replaces, the _account_.
+func (s *Server) AddAccount(account Account) *Server {
+
+	if account.ClientID == "" || account.ClientSecret == "" {
+		panic("account must have both client id and client secret")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.accounts[account.ClientID] = &account
+	return s
+}
+
+// Installations returns the activated fingerprints of the account _clientID_.
+func (s *Server) Installations(clientID string) []string {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fpts := make([]string, 0, len(s.installations[clientID]))
+
+	for fpt := range s.installations[clientID] {
+		fpts = append(fpts, fpt)
+	}
+
+	return fpts
+}
+
+// Revoke adds the _licenseID_ to the revocation list. An empty _licenseID_ is ignored.
+func (s *Server) Revoke(licenseID string) {
+
+	if licenseID == "" {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.revoked = append(s.revoked, licenseID)
+}
+
+// Revocations returns the sequence number of the last revocation and the license ids
+// that was revoked after the sequence number _since_.
+func (s *Server) Revocations(since int64) (int64, []string) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if since < 0 || since > int64(len(s.revoked)) {
+		since = 0
+	}
+
+	return int64(len(s.revoked)), append([]string{}, s.revoked[since:]...)
+}
+
+// ServeHTTP makes the `Server` mountable as a `http.Handler`.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// handleToken handles the client credentials grant where the credentials are passed
+// either using basic authentication or in the form.
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	if r.PostForm.Get("grant_type") != "client_credentials" {
+		writeOAuthError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+
+	id, secret, ok := r.BasicAuth()
+
+	if !ok {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+
+	s.mu.Lock()
+	account, ok := s.accounts[id]
+	tokenLength := s.tokenLength
+	s.mu.Unlock()
+
+	if !ok || subtle.ConstantTimeCompare([]byte(account.ClientSecret), []byte(secret)) != 1 {
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+
+	now := time.Now()
+	token := newToken()
+
+	s.mu.Lock()
+	s.pruneTokens(now)
+	s.tokens[token] = &accessToken{clientID: id, expires: now.Add(tokenLength)}
+	s.mu.Unlock()
+
+	w.Header().Set("Cache-Control", "no-store")
+
+	writeJSON(w, http.StatusOK, &tokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(tokenLength / time.Second),
+	})
+}
+
+// authenticated resolves the bearer token and passes the account to _next_. The server
+// lock is not held when _next_ is invoked.
+func (s *Server) authenticated(
+	next func(w http.ResponseWriter, r *http.Request, account *Account)) http.HandlerFunc {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		now := time.Now()
+
+		s.mu.Lock()
+		s.pruneTokens(now)
+
+		at, ok := s.tokens[token]
+
+		var account *Account
+
+		if ok {
+			account = s.accounts[at.clientID]
+		}
+
+		s.mu.Unlock()
+
+		if !ok || now.After(at.expires) {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, "invalid access token", http.StatusUnauthorized)
+			return
+		}
+
+		if account == nil {
+			http.Error(w, "unknown account", http.StatusUnauthorized)
+			return
+		}
+
+		next(w, r, account)
+	}
+
+}
+
+// pruneTokens removes all expired access tokens, at most once per `tokenPruneInterval`.
+// It requires the lock to be held.
+func (s *Server) pruneTokens(now time.Time) {
+
+	if now.Sub(s.pruned) < tokenPruneInterval {
+		return
+	}
+
+	for token, at := range s.tokens {
+
+		if now.After(at.expires) {
+			delete(s.tokens, token)
+		}
+
+	}
+
+	s.pruned = now
+}
+
+// handleActivate activates an installation. An already activated installation gets it's
+// current license.
+func (s *Server) handleActivate(w http.ResponseWriter, r *http.Request, account *Account) {
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ActivationRequest
+
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxRequestSize)).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid activation request: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	if req.Fingerprint == "" {
+		http.Error(w, "missing fingerprint", http.StatusBadRequest)
+		return
+	}
+
+	lic, err := s.licenseFor(account, req, true)
+
+	if err != nil {
+		writeLicenseError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &LicenseResponse{License: lic})
+}
+
+// handleDeactivate deactivates an installation and revokes it's license.
+func (s *Server) handleDeactivate(w http.ResponseWriter, r *http.Request, account *Account) {
+
+	if r.Method != http.MethodDelete {
+		w.Header().Set("Allow", "DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	fpt := strings.TrimPrefix(r.URL.Path, "/v1/activations/")
+
+	s.mu.Lock()
+	inst, ok := s.installations[account.ClientID][fpt]
+
+	if ok {
+
+		delete(s.installations[account.ClientID], fpt)
+
+		if inst.info != nil && inst.info.LicenseID != "" {
+			s.revoked = append(s.revoked, inst.info.LicenseID)
+		}
+
+	}
+
+	s.mu.Unlock()
+
+	if !ok {
+		http.Error(w, ErrNotActivated.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleLicense returns the current license of a installation. A expired license is
+// renewed.
+func (s *Server) handleLicense(w http.ResponseWriter, r *http.Request, account *Account) {
+
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	req := ActivationRequest{Fingerprint: r.URL.Query().Get("fpt")}
+	lic, err := s.licenseFor(account, req, false)
+
+	if err != nil {
+		writeLicenseError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &LicenseResponse{License: lic})
+}
+
+// handleRevocations returns the revocations after the _since_ query parameter.
+func (s *Server) handleRevocations(w http.ResponseWriter, r *http.Request, account *Account) {
+
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var since int64
+
+	if v := r.URL.Query().Get("since"); v != "" {
+
+		var err error
+
+		if since, err = strconv.ParseInt(v, 10, 64); err != nil {
+			http.Error(w, fmt.Sprintf("invalid since: %s", err), http.StatusBadRequest)
+			return
+		}
+
+	}
+
+	seq, revoked := s.Revocations(since)
+	writeJSON(w, http.StatusOK, &RevocationResponse{Seq: seq, Revoked: revoked})
+}
+
+// licenseFor returns the current license of the installation in _req_. If it has no
+// valid license, a new license is issued without holding the lock. If _activate_ is set,
+// a installation that is not activated is activated unless the account has reached
+// it's installation limit.
+func (s *Server) licenseFor(account *Account, req ActivationRequest, activate bool) (string, error) {
+
+	s.mu.Lock()
+	inst, err := s.installation(account, req.Fingerprint, activate)
+
+	if err == nil && inst.valid() {
+		lic := inst.license
+		s.mu.Unlock()
+
+		return lic, nil
+	}
+
+	s.mu.Unlock()
+
+	if err != nil {
+		return "", err
+	}
+
+	info, lic, err := s.issue(account, req.Fingerprint)
+
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// the installation may have been deactivated, activated or renewed while issuing
+	if inst, err = s.installation(account, req.Fingerprint, activate); err != nil {
+		return "", err
+	}
+
+	if inst.valid() {
+		return inst.license, nil
+	}
+
+	if inst == nil {
+
+		if s.installations[account.ClientID] == nil {
+			s.installations[account.ClientID] = map[string]*installation{}
+		}
+
+		inst = &installation{ActivationRequest: req}
+		s.installations[account.ClientID][req.Fingerprint] = inst
+	}
+
+	inst.info = info
+	inst.license = lic
+	return lic, nil
+}
+
+// installation returns the installation _fpt_ of the _account_. If not activated and
+// _activate_ is set, `nil` is returned unless the account has reached it's installation
+// limit. It requires the lock to be held.
+func (s *Server) installation(account *Account, fpt string, activate bool) (*installation, error) {
+
+	installations := s.installations[account.ClientID]
+
+	if inst, ok := installations[fpt]; ok {
+		return inst, nil
+	}
+
+	if !activate {
+		return nil, ErrNotActivated
+	}
+
+	if account.MaxInstallations > 0 && len(installations) >= account.MaxInstallations {
+		return nil, ErrInstallationLimit
+	}
+
+	return nil, nil
+}
+
+// valid returns `true` if the installation has a valid license.
+func (inst *installation) valid() bool {
+	return inst != nil && inst.info != nil && inst.info.Valid() == nil
+}
+
+// issue issues a new license for the installation _fpt_ of the _account_. The error is
+// returned per call, hence it never depends on the error state of the generator.
+func (s *Server) issue(account *Account, fpt string) (*license.FeatureInfo, string, error) {
+
+	var info *license.FeatureInfo
+
+	if account.Edition.Edition != "" {
+
+		var err error
+
+		if info, err = s.generator.CreateEditionInfoE(account.Edition); err != nil {
+			return nil, "", err
+		}
+
+	} else {
+
+		info = s.generator.CreateFeatureInfo()
+
+		for _, feature := range account.Features {
+			info.Feature(feature)
+		}
+
+	}
+
+	if info.LicenseID == "" {
+		return nil, "", fmt.Errorf("no license id created for %s", account.ClientID)
+	}
+
+	info.WithSubject(account.Subject)
+	info.ClientID = account.ClientID
+	info.Fingerprint = fpt
+
+	caller := map[string]interface{}{
+		"client_id": account.ClientID,
+		"sub":       account.Subject,
+	}
+
+	lic, err := s.generator.CreateForE(caller, info)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	return info, lic, nil
+}
+
+// newToken creates a new random access token.
+func newToken() string {
+
+	b := make([]byte, 32)
+
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeLicenseError writes _err_ with the status of a `licenseFor` error.
+func writeLicenseError(w http.ResponseWriter, err error) {
+
+	switch {
+	case errors.Is(err, ErrNotActivated):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrInstallationLimit):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusForbidden)
+	}
+
+}
+
+// writeOAuthError writes a _OAuth 2.0_ error response, see _RFC 6749_ section 5.2.
+func writeOAuthError(w http.ResponseWriter, status int, code string) {
+	writeJSON(w, status, map[string]string{"error": code})
+}
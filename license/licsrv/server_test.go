@@ -0,0 +1,319 @@
+package licsrv
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mariotoffia/gojwtlic/license"
+	"github.com/mariotoffia/gojwtlic/license/licjwt"
+	"github.com/mariotoffia/gojwtlic/license/licjwt/licbuiltin"
+	"github.com/stretchr/testify/assert"
+)
+
+var testKeys = licbuiltin.NewRSAKeys(2048)
+
+func newTestServer() *Server {
+
+	generator := licjwt.NewGeneratorBuilderWithSigner(licbuiltin.NewSignCreator(testKeys, "RS256")).
+		Audience("https://api.valmatics.se").
+		Issuer("https://api.valmatics.se/licsrv").
+		LicenseLength(time.Hour)
+
+	return NewServer(generator).
+		AddAccount(Account{
+			ClientID:         "mortviken",
+			ClientSecret:     "secret",
+			Subject:          "Mörtvikens Såg AB",
+			Features:         []string{"ui", "simulator"},
+			MaxInstallations: 1,
+		})
+}
+
+func TestActivateFetchDeactivate(t *testing.T) {
+
+	srv := newTestServer()
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	ctx := context.Background()
+	validator := licbuiltin.NewValidator(testKeys)
+
+	client := NewClient(ts.URL, "mortviken", "secret", "fpt-1").Validator(validator)
+
+	lic, err := client.Activate(ctx)
+	assert.NoError(t, err)
+
+	info, err := validator.Validate(lic)
+	assert.NoError(t, err)
+	assert.Equal(t, "fpt-1", info.Fingerprint)
+	assert.Equal(t, "Mörtvikens Såg AB", info.Subject)
+	assert.Equal(t, "mortviken", info.ClientID)
+	assert.Empty(t, info.ClientSecret)
+	assert.True(t, info.HasFeature("simulator"))
+
+	fetched, err := client.License(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, lic, fetched)
+	assert.False(t, client.Offline())
+
+	other, err := NewClient(ts.URL, info.ClientID, "secret", "fpt-2").Activate(ctx)
+	assert.Empty(t, other)
+	assert.True(t, errors.Is(err, ErrInstallationLimit))
+
+	assert.NoError(t, client.Deactivate(ctx))
+	assert.Empty(t, srv.Installations("mortviken"))
+
+	revoked, err := client.UpdateRevocations(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{info.LicenseID}, revoked)
+	assert.True(t, client.IsRevoked(info.LicenseID))
+
+	_, err = client.License(ctx)
+	assert.True(t, errors.Is(err, ErrNotActivated))
+}
+
+func TestInvalidClientCredentials(t *testing.T) {
+
+	ts := httptest.NewServer(newTestServer())
+	defer ts.Close()
+
+	_, err := NewClient(ts.URL, "mortviken", "wrong", "fpt-1").Activate(context.Background())
+	assert.True(t, errors.Is(err, ErrInvalidClient))
+}
+
+func TestTokenEndpointAcceptsFormCredentials(t *testing.T) {
+
+	ts := httptest.NewServer(newTestServer())
+	defer ts.Close()
+
+	resp, err := http.PostForm(ts.URL+"/oauth/token", url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {"mortviken"},
+		"client_secret": {"secret"},
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp.Body.Close()
+
+	resp, err = http.Get(ts.URL + "/v1/license?fpt=fpt-1")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp.Body.Close()
+}
+
+func TestRetriesAndOfflineCache(t *testing.T) {
+
+	srv := newTestServer()
+
+	var failing int32
+	var calls int32
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		atomic.AddInt32(&calls, 1)
+
+		if atomic.LoadInt32(&failing) > 0 {
+			atomic.AddInt32(&failing, -1)
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		srv.ServeHTTP(w, r)
+	}))
+
+	defer ts.Close()
+
+	ctx := context.Background()
+	cache := filepath.Join(t.TempDir(), "license.jwt")
+
+	client := NewClient(ts.URL, "mortviken", "secret", "fpt-1").
+		Retries(2, time.Millisecond).
+		CacheFile(cache).
+		Validator(licbuiltin.NewValidator(testKeys))
+
+	// two failures are retried
+	atomic.StoreInt32(&failing, 2)
+
+	lic, err := client.Activate(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&calls)) // 2 failed, token, activation
+
+	// the server is down, the cached license is used
+	atomic.StoreInt32(&failing, 100)
+
+	cached, err := client.License(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, lic, cached)
+	assert.True(t, client.Offline())
+
+	// a new client picks up the license from the cache file
+	restarted := NewClient(ts.URL, "mortviken", "secret", "fpt-1").
+		Retries(0, time.Millisecond).
+		CacheFile(cache)
+
+	cached, err = restarted.License(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, lic, cached)
+	assert.True(t, restarted.Offline())
+
+	// back online
+	atomic.StoreInt32(&failing, 0)
+
+	fetched, err := client.License(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, lic, fetched)
+	assert.False(t, client.Offline())
+}
+
+func TestEditionAccount(t *testing.T) {
+
+	generator := licjwt.NewGeneratorBuilderWithSigner(licbuiltin.NewSignCreator(testKeys, "RS256")).
+		LicenseLength(time.Hour).
+		Editions(license.NewEditionCatalog().
+			Define(license.Edition{Name: "pro", Features: map[string]map[string]interface{}{
+				"ui":       nil,
+				"settings": {"access": "rw"},
+			}}))
+
+	ts := httptest.NewServer(NewServer(generator).AddAccount(Account{
+		ClientID:     "hult",
+		ClientSecret: "secret",
+		Subject:      "nisse@hult.se",
+		Edition:      license.EditionSelection{Edition: "pro"},
+	}))
+
+	defer ts.Close()
+
+	lic, err := NewClient(ts.URL, "hult", "secret", "fpt-1").Activate(context.Background())
+	assert.NoError(t, err)
+
+	info, err := licbuiltin.NewValidator(testKeys).Validate(lic)
+	assert.NoError(t, err)
+	assert.Equal(t, "pro", info.Edition)
+	assert.True(t, info.HasFeature("settings"))
+	assert.True(t, strings.Contains(info.Features, "ui"))
+}
+
+func TestIssuanceIgnoresGeneratorErrorState(t *testing.T) {
+
+	srv := newTestServer()
+
+	// no edition catalog is set, hence the shared generator is put in error state
+	srv.generator.CreateEditionInfo(license.EditionSelection{Edition: "pro"})
+	assert.Error(t, srv.generator.Error())
+
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	_, err := NewClient(ts.URL, "mortviken", "secret", "fpt-1").Activate(context.Background())
+	assert.NoError(t, err)
+}
+
+func TestConcurrentActivationsHonourLimit(t *testing.T) {
+
+	srv := newTestServer()
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	var wg sync.WaitGroup
+
+	licenses := make([]string, 8)
+	errs := make([]error, 8)
+
+	for i := range licenses {
+
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			fpt := "fpt-1"
+
+			if i%2 == 1 {
+				fpt = "fpt-2"
+			}
+
+			licenses[i], errs[i] = NewClient(ts.URL, "mortviken", "secret", fpt).Activate(context.Background())
+		}(i)
+
+	}
+
+	wg.Wait()
+
+	assert.Equal(t, 1, len(srv.Installations("mortviken")))
+
+	issued := map[string]bool{}
+
+	for i := range licenses {
+
+		if errs[i] != nil {
+			assert.True(t, errors.Is(errs[i], ErrInstallationLimit))
+			continue
+		}
+
+		issued[licenses[i]] = true
+	}
+
+	// all activations of the same installation gets the same license
+	assert.Equal(t, 1, len(issued))
+}
+
+func TestDeactivateWithoutLicenseIDIsNotRevoked(t *testing.T) {
+
+	srv := newTestServer()
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	ctx := context.Background()
+	client := NewClient(ts.URL, "mortviken", "secret", "fpt-1")
+
+	_, err := client.Activate(ctx)
+	assert.NoError(t, err)
+
+	srv.mu.Lock()
+	srv.installations["mortviken"]["fpt-1"].info.LicenseID = ""
+	srv.mu.Unlock()
+
+	assert.NoError(t, client.Deactivate(ctx))
+
+	seq, revoked := srv.Revocations(0)
+	assert.Equal(t, int64(0), seq)
+	assert.Empty(t, revoked)
+}
+
+func TestExpiredTokensArePrunedOnAuthentication(t *testing.T) {
+
+	srv := newTestServer()
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	ctx := context.Background()
+	client := NewClient(ts.URL, "mortviken", "secret", "fpt-1")
+
+	_, err := client.Activate(ctx)
+	assert.NoError(t, err)
+
+	srv.mu.Lock()
+	srv.tokens["expired"] = &accessToken{clientID: "mortviken", expires: time.Now().Add(-time.Second)}
+	srv.pruned = time.Time{}
+	srv.mu.Unlock()
+
+	_, err = client.License(ctx)
+	assert.NoError(t, err)
+
+	srv.mu.Lock()
+	_, ok := srv.tokens["expired"]
+	srv.mu.Unlock()
+
+	assert.False(t, ok)
+}
@@ -1,5 +1,14 @@
 package license
 
+import "errors"
+
+// ErrInvalidLicense is returned (wrapped) by a `Validator` when the license could not be
+// verified.
+var ErrInvalidLicense = errors.New("invalid license")
+
 // Validator can validate licenses
 type Validator interface {
+	// Validate verifies the signature and the time constraints of the _license_ and
+	// returns the license information.
+	Validate(license string) (*FeatureInfo, error)
 }
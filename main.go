@@ -2,11 +2,21 @@ package main
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/mariotoffia/gojwtlic/license/licjwt/licbuiltin"
 )
 
 func main() {
+
+	if len(os.Args) > 1 && os.Args[1] == "test" {
+		os.Exit(testCommand(os.Args[2:]))
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "activate" {
+		os.Exit(activateCommand(os.Args[2:]))
+	}
+
 	k := licbuiltin.KeysImpl{}
 	fmt.Printf("%v", k)
 }
@@ -0,0 +1,26 @@
+package licpol.testing
+
+generate_input(scope) = {
+    "method": "POST",
+    "path": ["license", "generate", "Kåge"],
+    "claims": {"scope": scope}
+}
+
+test_allow_create_when_caller_has_all_scopes {
+    allow_create with input as generate_input("simulator regulate ui settings master-of-puppets")
+        with data.license as {"scope": "simulator ui"}
+}
+
+test_deny_create_when_license_has_more_scopes {
+    not allow_create with input as generate_input("ui")
+        with data.license as {"scope": "simulator ui"}
+}
+
+test_deny_create_when_not_post {
+    not allow_create with input as {"method": "GET", "path": ["license", "generate", "Kåge"], "claims": {"scope": "ui"}}
+        with data.license as {"scope": "ui"}
+}
+
+test_scopes_to_set {
+    scopes_to_set("a b a") == {"a", "b"}
+}